@@ -1,4 +1,4 @@
-import { WSMessage, Request, Response, RequestContext, PushEvent } from './types';
+import { WSMessage, Request, Response, RequestContext, PushEvent, ChatCaller } from './types';
 
 export class MCSMClient {
   private ws: WebSocket | null = null;
@@ -51,13 +51,8 @@ export class MCSMClient {
     }
 
     try {
-      // Append token to URL if present
-      let wsUrl = this.url;
-      if (this.token) {
-        const separator = wsUrl.includes('?') ? '&' : '?';
-        wsUrl += `${separator}token=${encodeURIComponent(this.token)}`;
-      }
-      this.ws = new (globalThis as any).WebSocket(wsUrl);
+      // The token is sent in-band once connected, never in the URL (it would end up in proxy logs)
+      this.ws = new (globalThis as any).WebSocket(this.url);
     } catch (e) {
       console.error('WS Creation Failed:', e);
       this.scheduleReconnect();
@@ -65,6 +60,9 @@ export class MCSMClient {
     }
 
     this.ws.onopen = () => {
+      if (this.token) {
+        this.ws!.send(JSON.stringify({ action: 'auth', req_id: 'auth', params: { token: this.token } }));
+      }
       this.isConnected = true;
       console.log('MCSM Bridge Connected');
       if (this.reconnectTimer) {
@@ -137,7 +135,8 @@ export class MCSMClient {
   }
 
   private handleMessage(msg: WSMessage) {
-    if (msg.type === 'response') {
+    if (msg.type === 'response' || (msg.type === 'error' && msg.req_id && this.pendingRequests.has(msg.req_id))) {
+      // Errors for a pending request carry code/message (and possibly partial data), let the caller render them.
       const resp = msg as Response;
       const cb = this.pendingRequests.get(resp.req_id);
       if (cb) {
@@ -152,20 +151,51 @@ export class MCSMClient {
   }
 
   private handleEvent(msg: PushEvent) {
-    let ctx = this.ctx; // Default fallback
+    // The server closes the connection right after; reconnecting is automatic
+    if (msg.event === 'shutdown') {
+      console.log('MCSM Bridge shutting down:', msg.data);
+      return;
+    }
+    if (msg.event === 'config_reloaded') {
+      console.log('MCSM Bridge config reloaded:', msg.data);
+      return;
+    }
+
     if (msg.req_id) {
       const session = this.sessionStore.get(msg.req_id);
       if (session) {
-        ctx = session.source_ctx;
+        this.renderEvent(session.source_ctx, msg);
+        return;
       }
     }
 
-    if (!ctx) return;
+    // Broadcasts about an alias go to the groups linked to it
+    const groupCtxs = (msg.groups || []).map((g) => this.groupContext(g)).filter((c): c is seal.MsgContext => c !== null);
+    if (groupCtxs.length > 0) {
+      for (const c of groupCtxs) this.renderEvent(c, msg);
+      return;
+    }
+
+    if (this.ctx) this.renderEvent(this.ctx, msg); // Default fallback
+  }
+
+  // groupContext builds a context for sending to groupId (e.g. "QQ-Group:123") through an endpoint of its platform.
+  private groupContext(groupId: string): seal.MsgContext | null {
+    const platform = groupId.split(/[-:]/)[0];
+    const ep = seal.getEndPoints().find((e) => e.platform === platform && e.enable) || seal.getEndPoints()[0];
+    if (!ep) return null;
+    const m = seal.newMessage();
+    m.messageType = 'group';
+    m.groupId = groupId;
+    m.platform = ep.platform;
+    return seal.createTempCtx(ep, m);
+  }
 
+  private renderEvent(ctx: seal.MsgContext, msg: PushEvent) {
     if (msg.event === 'qrcode') {
       const data = msg.data;
       if (data.url) {
-        seal.replyToSender(ctx, seal.newMessage(), `[MCSM] 请扫描二维码登录 (Alias: ${data.alias})\n[CQ:image,file=${data.url}]`);
+        seal.replyToSender(ctx, seal.newMessage(), `[MCSM] 请扫描二维码登录 (Alias: ${data.alias})\n[CQ:image,file=${escapeCQ(data.url)}]`);
       }
     } else if (msg.event === 'log') {
       if (typeof msg.data === 'string') {
@@ -173,13 +203,17 @@ export class MCSMClient {
       }
     } else if (msg.event === 'success') {
       seal.replyToSender(ctx, seal.newMessage(), `[MCSM] ${msg.data}`);
+    } else if (msg.event === 'job_result') {
+      const d = msg.data as any;
+      const detail = d.status === 'ok' ? '成功' : `失败: ${d.error}`;
+      seal.replyToSender(ctx, seal.newMessage(), `[MCSM 定时任务 #${d.job_id}] ${d.alias} ${d.kind}:${d.action} ${detail}`);
     } else if (msg.event === 'error') {
       const d = msg.data as any;
       seal.replyToSender(ctx, seal.newMessage(), `[MCSM Error] ${d.msg || d}`);
     }
   }
 
-  public async send(command: string, params: Record<string, string>, ctx?: seal.MsgContext): Promise<Response> {
+  public async send(command: string, params: Record<string, unknown>, ctx?: seal.MsgContext): Promise<Response> {
     if (!this.isConnected || !this.ws) {
       throw new Error('WebSocket未连接');
     }
@@ -191,12 +225,15 @@ export class MCSMClient {
       this.registerContext(req_id, ctx);
     }
 
-    const payload = {
+    const payload: Request = {
       action: command,
       command: command,
       req_id,
       params
     };
+    if (ctx) {
+      payload.caller = callerOf(ctx);
+    }
 
     return new Promise<Response>((resolve, reject) => {
       const timeout = setTimeout(() => {
@@ -221,3 +258,23 @@ export class MCSMClient {
     });
   }
 }
+
+// callerOf describes the chat user behind ctx for server-side ACLs.
+// Sealdice privilege levels: 100 master, 60 group owner, 50 group admin.
+export function callerOf(ctx: seal.MsgContext): ChatCaller {
+  let role = 'member';
+  if (ctx.privilegeLevel >= 100) role = 'master';
+  else if (ctx.privilegeLevel >= 60) role = 'owner';
+  else if (ctx.privilegeLevel >= 50) role = 'admin';
+  return {
+    platform: ctx.endPoint?.platform || '',
+    group_id: ctx.isPrivate ? '' : (ctx.group?.groupId || ''),
+    user_id: ctx.player?.userId || '',
+    role
+  };
+}
+
+// escapeCQ escapes a CQ code parameter value; signed URLs carry & and =.
+function escapeCQ(v: string): string {
+  return v.replace(/&/g, '&amp;').replace(/\[/g, '&#91;').replace(/]/g, '&#93;').replace(/,/g, '&#44;');
+}
@@ -1,17 +1,24 @@
 import { MCSMClient } from './client';
+import { BulkResult, GroupLink, STATUS_NAMES, STATUS_RUNNING, StepResult } from './types';
 
-// Simple state manager to track pending logins per group
+// Relogins started from each group in this session. The server also knows which
+// bindings a group owns, so "continue" still works after a plugin restart.
 const loginState = new Map<string, string>(); // groupId -> alias
 
 export function registerCommands(ext: seal.ExtInfo, client: MCSMClient) {
   const cmd = seal.ext.newCmdItemInfo();
   cmd.name = 'mcsm';
   cmd.help = `MCSM 管理指令:
-.mcsm bind <alias> <proto_uuid> <core_uuid> - 绑定实例
-.mcsm <start|stop|restart> <alias> - 管理实例
+.mcsm bind <alias> <proto_uuid> <core_uuid>|<role=uuid,...> [tag1,tag2] [描述] - 绑定实例
+.mcsm <start|stop|restart> [alias] [role|both] - 管理实例 (默认按顺序操作整组)
 .mcsm status [alias] - 查看状态
-.mcsm relogin <alias> - 扫码登录
-.mcsm continue - 确认登录完成`;
+.mcsm bulk <start|stop|restart|status> <all|a,b,c|glob|tag:xxx> - 批量操作
+.mcsm relogin [alias] - 扫码登录
+.mcsm continue - 确认登录完成
+.mcsm link <alias> [default] - 将本群关联到绑定
+.mcsm unlink <alias> - 取消本群与绑定的关联
+.mcsm links - 查看本群关联的绑定
+(关联后本群指令省略 alias 时使用本群的默认绑定)`;
 
   cmd.solve = (ctx, msg, args) => {
     const sub = args.getArgN(1);
@@ -34,12 +41,22 @@ export function registerCommands(ext: seal.ExtInfo, client: MCSMClient) {
           case 'status':
             await handleStatus(ctx, msg, args, client);
             break;
+          case 'bulk':
+            await handleBulk(ctx, msg, args, client);
+            break;
           case 'relogin':
             await handleRelogin(ctx, msg, args, client);
             break;
           case 'continue':
             await handleContinue(ctx, msg, client);
             break;
+          case 'link':
+          case 'unlink':
+            await handleLink(ctx, msg, args, client, sub);
+            break;
+          case 'links':
+            await handleLinks(ctx, msg, client);
+            break;
           default:
             seal.replyToSender(ctx, msg, cmd.help);
         }
@@ -56,28 +73,56 @@ export function registerCommands(ext: seal.ExtInfo, client: MCSMClient) {
 
 async function handleBind(ctx: seal.MsgContext, msg: seal.Message, args: seal.CmdArgs, client: MCSMClient) {
   const alias = args.getArgN(2);
-  const protoId = args.getArgN(3);
-  const coreId = args.getArgN(4);
-  if (!alias || !protoId || !coreId) {
-    seal.replyToSender(ctx, msg, '用法: .mcsm bind <alias> <proto_uuid> <core_uuid>');
+  const first = args.getArgN(3);
+  const usage = '用法: .mcsm bind <alias> <proto_uuid> <core_uuid> [tag1,tag2] [描述]\n或: .mcsm bind <alias> <role=uuid,role=uuid,...> [tag1,tag2] [描述]';
+  if (!alias || !first) {
+    seal.replyToSender(ctx, msg, usage);
     return;
   }
-  const res = await client.send('bind', { alias, protocol_id: protoId, core_id: coreId }, ctx);
+
+  // "role=uuid,..." binds an arbitrary ordered role list, otherwise the legacy protocol/core pair
+  const params: Record<string, string> = { alias };
+  let rest: number;
+  if (first.includes('=')) {
+    params['instances'] = first;
+    rest = 3;
+  } else {
+    const coreId = args.getArgN(4);
+    if (!coreId) {
+      seal.replyToSender(ctx, msg, usage);
+      return;
+    }
+    params['protocol_id'] = first;
+    params['core_id'] = coreId;
+    rest = 4;
+  }
+  const tags = args.args[rest];
+  const description = args.args.slice(rest + 1).join(' ');
+  if (tags) params['tags'] = tags;
+  if (description) params['description'] = description;
+  const res = await client.send('bind', params, ctx);
   seal.replyToSender(ctx, msg, `绑定结果: ${res.code === 200 ? '成功' : res.message}`);
 }
 
 async function handleControl(ctx: seal.MsgContext, msg: seal.Message, args: seal.CmdArgs, client: MCSMClient, action: string) {
-  const target = args.getArgN(2);
+  const target = args.getArgN(2); // Optional in groups linked to a binding
   const role = args.getArgN(3); // Optional
-  if (!target) {
-    seal.replyToSender(ctx, msg, `用法: .mcsm ${action} <alias> [role]`);
-    return;
-  }
-  const params: Record<string, string> = { target };
+  const params: Record<string, string> = {};
+  if (target) params['target'] = target;
   if (role) params['role'] = role;
 
   const res = await client.send(action, params, ctx);
-  seal.replyToSender(ctx, msg, `指令发送: ${res.code === 200 ? '成功' : res.message}`);
+  if (res.code === 400 && !target) {
+    seal.replyToSender(ctx, msg, `用法: .mcsm ${action} <alias> [role|both] (本群未关联绑定)`);
+    return;
+  }
+  let output = `指令发送: ${res.code === 200 ? '成功' : res.message}`;
+  if (res.data?.steps) {
+    for (const step of res.data.steps as StepResult[]) {
+      output += `\n- ${step.role} ${step.action}: ${step.status}${step.error ? ` (${step.error})` : ''}`;
+    }
+  }
+  seal.replyToSender(ctx, msg, output);
 }
 
 async function handleStatus(ctx: seal.MsgContext, msg: seal.Message, args: seal.CmdArgs, client: MCSMClient) {
@@ -89,30 +134,73 @@ async function handleStatus(ctx: seal.MsgContext, msg: seal.Message, args: seal.
     return;
   }
 
-  let output = '';
-  if (target) {
-    // Instance Detail
-    const d = res.data;
-    output = `实例 ${target} 状态:\n运行: ${d.status === 1 ? '是' : '否'}\nCPU: ${d.process?.cpuUsage}%\n内存: ${d.process?.memory}`;
-  } else {
+  // The panel's own responses (dashboard, instance detail) keep their {status, data} envelope.
+  const d = res.data;
+  let output: string;
+  if (Array.isArray(d?.results)) {
+    // A selector (all, a,b, glob, tag:xxx) reports each binding
+    output = `实例 ${target} 状态:${formatBulk(d.results)}`;
+  } else if (d?.data?.version !== undefined) {
     // Dashboard
-    output = `MCSM 面板状态:\n版本: ${res.data.version}\n实例数: ${res.data.remoteCount?.total}`;
+    output = `MCSM 面板状态:\n版本: ${d.data.version}\n实例数: ${d.data.remoteCount?.total}`;
+  } else if (typeof d?.data === 'object') {
+    // Instance Detail (the group's default binding when no target was given)
+    const inst = d.data;
+    output = `实例 ${target || '本群绑定'} 状态:\n运行: ${inst.status === STATUS_RUNNING ? '是' : `否 (${statusName(inst.status)})`}\nCPU: ${inst.process?.cpuUsage}%\n内存: ${inst.process?.memory}`;
+  } else {
+    // role both: role -> status code
+    output = `实例 ${target || '本群绑定'} 状态:${formatRoles(d)}`;
   }
   seal.replyToSender(ctx, msg, output);
 }
 
+function statusName(code: number): string {
+  return STATUS_NAMES[code] ?? `未知(${code})`;
+}
+
+function formatRoles(roles: Record<string, number>): string {
+  return Object.entries(roles).map(([role, st]) => ` ${role}=${statusName(st)}`).join('');
+}
+
+// formatBulk renders one line per alias of a bulk response.
+function formatBulk(results: BulkResult[]): string {
+  let output = '';
+  for (const r of results) {
+    const detail = r.data ? formatRoles(r.data) : r.error ? ` ${r.error}` : '';
+    output += `\n${r.alias}: ${r.status}${detail}`;
+  }
+  return output;
+}
+
+async function handleBulk(ctx: seal.MsgContext, msg: seal.Message, args: seal.CmdArgs, client: MCSMClient) {
+  const action = args.getArgN(2);
+  const selector = args.getArgN(3);
+  if (!['start', 'stop', 'restart', 'status'].includes(action) || !selector) {
+    seal.replyToSender(ctx, msg, '用法: .mcsm bulk <start|stop|restart|status> <all|a,b,c|glob|tag:xxx>');
+    return;
+  }
+
+  const res = await client.send(`bulk_${action}`, { selector }, ctx);
+  if (res.code !== 200) {
+    seal.replyToSender(ctx, msg, `批量操作失败: ${res.message}`);
+    return;
+  }
+
+  seal.replyToSender(ctx, msg, `批量 ${action} 结果:${formatBulk(res.data.results)}`);
+}
+
 async function handleRelogin(ctx: seal.MsgContext, msg: seal.Message, args: seal.CmdArgs, client: MCSMClient) {
-  const target = args.getArgN(2);
-  if (!target) {
-    seal.replyToSender(ctx, msg, '用法: .mcsm relogin <alias>');
+  const target = args.getArgN(2); // Optional in groups linked to a binding
+
+  const res = await client.send('relogin', target ? { target } : {}, ctx);
+  if (res.code !== 200) {
+    seal.replyToSender(ctx, msg, target ? `启动失败: ${res.message}` : '用法: .mcsm relogin <alias> (本群未关联绑定)');
     return;
   }
 
   // Register state
   const groupId = ctx.group?.groupId || 'private';
-  loginState.set(groupId, target);
-
-  await client.send('relogin', { target }, ctx);
+  if (target) loginState.set(groupId, target);
   seal.replyToSender(ctx, msg, '重登录流程已启动，请等待二维码...');
 }
 
@@ -120,12 +208,12 @@ async function handleContinue(ctx: seal.MsgContext, msg: seal.Message, client: M
   const groupId = ctx.group?.groupId || 'private';
   const alias = loginState.get(groupId);
 
-  if (!alias) {
-    seal.replyToSender(ctx, msg, '当前群没有进行中的重登录流程');
+  // Without a remembered alias the server picks the relogin pending for this group's bindings.
+  const res = await client.send('continue', alias ? { target: alias } : {}, ctx);
+  if (res.code !== 200) {
+    seal.replyToSender(ctx, msg, alias ? `发送失败: ${res.message}` : '当前群没有进行中的重登录流程');
     return;
   }
-
-  await client.send('continue', { target: alias }, ctx);
   seal.replyToSender(ctx, msg, '已发送继续指令');
 
   // Clear state after success? Or wait for success event?
@@ -134,3 +222,34 @@ async function handleContinue(ctx: seal.MsgContext, msg: seal.Message, client: M
   // Let's remove it to keep it clean.
   loginState.delete(groupId);
 }
+
+async function handleLink(ctx: seal.MsgContext, msg: seal.Message, args: seal.CmdArgs, client: MCSMClient, action: string) {
+  const alias = args.getArgN(2);
+  if (!alias || ctx.isPrivate) {
+    seal.replyToSender(ctx, msg, `用法: .mcsm ${action} <alias>${action === 'link' ? ' [default]' : ''} (仅限群聊)`);
+    return;
+  }
+  const params: Record<string, unknown> = { alias };
+  if (action === 'link' && args.getArgN(3) === 'default') params['default'] = true;
+
+  const res = await client.send(`group_${action}`, params, ctx);
+  seal.replyToSender(ctx, msg, `${action === 'link' ? '关联' : '取消关联'}: ${res.code === 200 ? '成功' : res.message}`);
+}
+
+async function handleLinks(ctx: seal.MsgContext, msg: seal.Message, client: MCSMClient) {
+  if (ctx.isPrivate) {
+    seal.replyToSender(ctx, msg, '仅限群聊');
+    return;
+  }
+  const res = await client.send('group_list', {}, ctx);
+  if (res.code !== 200) {
+    seal.replyToSender(ctx, msg, `查询失败: ${res.message}`);
+    return;
+  }
+  const links = res.data as GroupLink[];
+  if (links.length === 0) {
+    seal.replyToSender(ctx, msg, '本群未关联任何绑定');
+    return;
+  }
+  seal.replyToSender(ctx, msg, '本群关联的绑定:\n' + links.map((l) => `${l.alias}${l.default ? ' (默认)' : ''}`).join('\n'));
+}
@@ -1,7 +1,16 @@
+export interface ChatCaller {
+  platform: string;
+  group_id: string;
+  user_id: string;
+  role: 'member' | 'admin' | 'owner' | 'master' | string;
+}
+
 export interface Request {
   action: string;
+  command?: string;
   req_id: string;
-  params: Record<string, string>;
+  params: Record<string, unknown>;
+  caller?: ChatCaller;
 }
 
 export interface Response<T = any> {
@@ -12,6 +21,32 @@ export interface Response<T = any> {
   message?: string;
 }
 
+export interface StepResult {
+  role: string;
+  instance_id: string;
+  action: string;
+  status: 'ok' | 'error' | 'skipped';
+  error?: string;
+}
+
+// MCSM instance status codes, as reported by status and bulk_status.
+export const STATUS_RUNNING = 3;
+export const STATUS_NAMES: Record<number, string> = { [-1]: '忙碌', 0: '停止', 1: '停止中', 2: '启动中', 3: '运行中' };
+
+export interface BulkResult {
+  alias: string;
+  status: 'ok' | 'failed';
+  steps?: StepResult[];
+  data?: Record<string, number>;
+  error?: string;
+}
+
+export interface GroupLink {
+  group_id: string;
+  alias: string;
+  default: boolean;
+}
+
 export interface EventData {
   alias: string;
   generated_at?: string;
@@ -23,6 +58,7 @@ export interface EventData {
 export interface PushEvent {
   type: 'event';
   req_id?: string; // Optional: if server sends it
+  groups?: string[]; // Broadcasts: groups linked to the event's alias
   event: string;
   data: EventData;
 }
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+	"sealdice-mcsm/server/pkg/mcsm"
+)
+
+// command is one subcommand of the server binary. Apart from serve they work on
+// the database and panel directly and exit, so setup can be scripted over SSH.
+// They may run next to a running server, which reads bindings, group links, tokens
+// and ACL rules from the database on each use; but its scheduler only learns of
+// jobs that import creates or changes when it restarts. The WS import action
+// reschedules at once.
+type command struct {
+	name    string
+	args    string // usage after the name
+	summary string
+	run     func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"serve", "", "run the bridge server (the default)", cmdServe},
+	{"doctor", "", "check config, database, panel and bindings", cmdDoctor},
+	{"bind", "[-desc text] [-tags a,b] [-profile name] [-no-check] <alias> <role>=<instance_id>...", "create or replace a binding, roles in start order", cmdBind},
+	{"unbind", "<alias>", "delete a binding", cmdUnbind},
+	{"list", "[-tag tag] [-json]", "list bindings", cmdList},
+	{"export", "[-o file] [-format json|yaml]", "dump bindings, group links, schedules, tokens and ACL rules", cmdExport},
+	{"import", "[-mode merge|replace] [-dry-run] <file|->", "apply an export in one transaction", cmdImport},
+	{"migrate", "", "bring the database schema up to date", cmdMigrate},
+	{"token", "create -scopes s1,s2 [-name n] [-aliases a,b] [-tags t] | hash < token", "issue an API token, or hash one read from stdin for auth.token", cmdToken},
+}
+
+// runCommand dispatches os.Args; no arguments means serve.
+func runCommand(args []string) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: server %s %s\n", c.name, c.args)
+			fs.PrintDefaults()
+		}
+		err := c.run(fs, args)
+		var ec exitStatus
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 2
+		case errors.As(err, &ec):
+			return int(ec)
+		case errors.Is(err, errUsage):
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "server %s: %v\n", c.name, err)
+		return 1
+	}
+	usage(os.Stderr)
+	if name == "help" || name == "-h" || name == "--help" {
+		return 0
+	}
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: server [command] [flags]")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun server <command> -h for its flags.")
+}
+
+// errUsage makes runCommand print the command's usage.
+var errUsage = errors.New("usage")
+
+// exitStatus ends a command with a status and no message, it printed its own.
+type exitStatus int
+
+func (e exitStatus) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// parse parses flags and checks the number of positional arguments, max < 0 for no limit.
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return errUsage
+	}
+	return nil
+}
+
+// openRepo loads the config and opens its database, migrating it like serve does.
+func openRepo() (*config.Config, *data.SQLiteRepo, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	repo, err := data.NewSQLiteRepo(cfg.DBPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open %s: %w", cfg.DBPath, err)
+	}
+	return cfg, repo, nil
+}
+
+func cmdServe(fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fatal(slog.Default(), "failed to load config", err)
+	}
+	serve(cfg)
+	return nil
+}
+
+func cmdDoctor(fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("FAIL  config    %v\n", err)
+		return exitStatus(1)
+	}
+	if code := doctor(os.Stdout, cfg); code != 0 {
+		return exitStatus(code)
+	}
+	return nil
+}
+
+func cmdBind(fs *flag.FlagSet, args []string) error {
+	desc := fs.String("desc", "", "description")
+	tags := fs.String("tags", "", "comma separated tags")
+	profile := fs.String("profile", "", "protocol profile from the config (default \"default\")")
+	noCheck := fs.Bool("no-check", false, "do not check that the instances exist on the panel")
+	if err := parse(fs, args, 2, -1); err != nil {
+		return err
+	}
+	alias := fs.Arg(0)
+	instances, err := service.ParseInstances(strings.Join(fs.Args()[1:], ","))
+	if err != nil {
+		return err
+	}
+
+	cfg, repo, err := openRepo()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	if _, err := cfg.Profiles.Get(*profile); err != nil {
+		return err
+	}
+	if !*noCheck {
+		mc := mcsm.NewClient(cfg.MCSM.URL, cfg.MCSM.APIKey)
+		for _, inst := range instances {
+			detail, err := mc.InstanceDetail(inst.InstanceID, "local")
+			if err == nil && detail.Status != http.StatusOK {
+				err = fmt.Errorf("panel answered status %d", detail.Status)
+			}
+			if err != nil {
+				return fmt.Errorf("%s instance %s: %v (use -no-check to bind anyway)", inst.Role, inst.InstanceID, err)
+			}
+		}
+	}
+	if err := service.NewInstanceService(repo).Bind(alias, instances, *desc, *profile, service.ParseTags(*tags)); err != nil {
+		return err
+	}
+	fmt.Printf("bound %s\n", alias)
+	return nil
+}
+
+func cmdUnbind(fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	_, repo, err := openRepo()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	inst := service.NewInstanceService(repo)
+	// DeleteBinding succeeds for unknown aliases; a typo should not look like success.
+	if _, err := inst.GetByAlias(fs.Arg(0)); err != nil {
+		return err
+	}
+	if err := inst.Unbind(fs.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Printf("unbound %s\n", fs.Arg(0))
+	return nil
+}
+
+func cmdList(fs *flag.FlagSet, args []string) error {
+	tag := fs.String("tag", "", "only bindings with this tag")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	_, repo, err := openRepo()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	inst := service.NewInstanceService(repo)
+	var bindings []*data.Binding
+	if *tag != "" {
+		bindings, err = inst.GetByTag(*tag)
+	} else {
+		bindings, err = inst.GetAll()
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if bindings == nil {
+			bindings = []*data.Binding{}
+		}
+		return enc.Encode(bindings)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALIAS\tINSTANCES\tTAGS\tDESCRIPTION")
+	for _, b := range bindings {
+		var insts []string
+		for _, i := range b.Instances {
+			insts = append(insts, i.Role+"="+i.InstanceID)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", b.Alias, strings.Join(insts, ","), strings.Join(b.Tags, ","), b.Description)
+	}
+	return tw.Flush()
+}
+
+func cmdExport(fs *flag.FlagSet, args []string) error {
+	out := fs.String("o", "", "output file instead of stdout")
+	format := fs.String("format", "", "json or yaml, by default from the -o extension, else json")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = "json"
+		if ext := strings.ToLower(filepath.Ext(*out)); ext == ".yaml" || ext == ".yml" {
+			*format = "yaml"
+		}
+	}
+	cfg, repo, err := openRepo()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	doc, err := service.ExportAll(repo, cfg.Profiles)
+	if err != nil {
+		return err
+	}
+	b, err := service.MarshalExport(doc, *format)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	// The document holds token hashes.
+	return os.WriteFile(*out, b, 0o600)
+}
+
+func cmdImport(fs *flag.FlagSet, args []string) error {
+	mode := fs.String("mode", service.ImportMerge, "merge keeps what the file does not list, replace removes it")
+	dryRun := fs.Bool("dry-run", false, "validate and report the changes without making them")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	var raw []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	doc, err := service.ParseExport(raw)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", fs.Arg(0), err)
+	}
+
+	cfg, repo, err := openRepo()
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	res, err := service.Import(repo, doc, service.ImportOptions{Mode: *mode, DryRun: *dryRun, Profiles: cfg.Profiles})
+	if err != nil {
+		return fmt.Errorf("%w (nothing imported)", err)
+	}
+	if res.DryRun {
+		fmt.Printf("dry run, %s mode, nothing changed:\n", res.Mode)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SECTION\tCREATED\tUPDATED\tUNCHANGED\tREMOVED")
+	for _, sec := range []struct {
+		name string
+		n    service.ImportCounts
+	}{
+		{"bindings", res.Bindings},
+		{"group_links", res.GroupLinks},
+		{"jobs", res.Jobs},
+		{"tokens", res.Tokens},
+		{"acl_rules", res.ACLRules},
+	} {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", sec.name, sec.n.Created, sec.n.Updated, sec.n.Unchanged, sec.n.Removed)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(res.ProfilesDiffer) > 0 {
+		fmt.Printf("profiles missing or different in config.yaml (not imported): %s\n", strings.Join(res.ProfilesDiffer, ", "))
+	}
+	return nil
+}
+
+// readSecret reads one line from stdin, so the secret stays out of argv and shell
+// history. On a terminal it prompts on stderr and does not echo.
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func cmdMigrate(fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	before, latest, err := data.SchemaVersion(cfg.DBPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if before > latest {
+		return fmt.Errorf("schema v%d is newer than this build (v%d)", before, latest)
+	}
+	repo, err := data.NewSQLiteRepo(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	repo.Close()
+	if before == latest {
+		fmt.Printf("schema v%d, up to date\n", latest)
+	} else {
+		fmt.Printf("schema v%d -> v%d\n", before, latest)
+	}
+	return nil
+}
+
+func cmdToken(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "hash":
+		if err := parse(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		token, err := readSecret("token: ")
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			return errors.New("empty token")
+		}
+		hash, err := service.HashToken(token)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hash)
+		return nil
+	case "create":
+		name := fs.String("name", "", "token name shown in logs and audit entries")
+		scopes := fs.String("scopes", "", "comma separated scopes: "+strings.Join(service.AllScopes, ", "))
+		aliases := fs.String("aliases", "", "comma separated alias whitelist, globs allowed")
+		tags := fs.String("tags", "", "comma separated tag whitelist")
+		if err := parse(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		cfg, repo, err := openRepo()
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+		t, secret, err := service.NewTokenService(repo, cfg).Issue(*name, service.ParseTags(*scopes), service.ParseTags(*aliases), service.ParseTags(*tags))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "issued token %s, it is shown only once:\n", t.ID)
+		fmt.Println(secret)
+		return nil
+	}
+	return errUsage
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/pkg/mcsm"
+	"sealdice-mcsm/server/pkg/s3"
+)
+
+// doctorTimeout bounds each network check.
+const doctorTimeout = 5 * time.Second
+
+// doctor checks the setup without starting anything: the config, the database
+// schema, the panel and API key, the daemon that serves downloads, and every
+// binding's instances. Unlike /readyz it prints the underlying errors, it runs
+// on the operator's terminal. It returns the exit code, 1 if any check failed.
+func doctor(w io.Writer, cfg *config.Config) int {
+	failed := false
+	report := func(status, name, format string, args ...any) {
+		if status == "FAIL" {
+			failed = true
+		}
+		fmt.Fprintf(w, "%-4s  %-8s  %s\n", status, name, fmt.Sprintf(format, args...))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		for _, e := range unjoin(err) {
+			report("FAIL", "config", "%v", e)
+		}
+	} else {
+		report("ok", "config", "valid")
+	}
+
+	// The schema is only read; a missing or outdated database is migrated on the next start.
+	schemaOK := false
+	cur, latest, err := data.SchemaVersion(cfg.DBPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		report("warn", "db", "%s does not exist, it is created on first start", cfg.DBPath)
+	case err != nil:
+		report("FAIL", "db", "%s: %v", cfg.DBPath, err)
+	case cur > latest:
+		report("FAIL", "db", "schema v%d is newer than this build (v%d), upgrade the server", cur, latest)
+	case cur < latest:
+		report("warn", "db", "schema v%d, migrated to v%d on next start", cur, latest)
+	default:
+		report("ok", "db", "schema v%d", cur)
+		schemaOK = true
+	}
+
+	if detail, err := checkStore(cfg); err != nil {
+		report("FAIL", "store", "%v", err)
+	} else {
+		report("ok", "store", "%s", detail)
+	}
+
+	mc := mcsm.NewClient(cfg.MCSM.URL, cfg.MCSM.APIKey)
+	mc.HTTP.Timeout = doctorTimeout
+	panelOK := false
+	dash, err := mc.Dashboard()
+	var httpErr *mcsm.HTTPError
+	switch {
+	case err == nil && dash.Status == http.StatusOK:
+		report("ok", "mcsm", "panel %s reachable, version %s, %d daemons", cfg.MCSM.URL, dash.Data.Version, dash.Data.RemoteCount.Total)
+		report("ok", "apikey", "accepted")
+		panelOK = true
+	case err == nil:
+		report("ok", "mcsm", "panel %s reachable", cfg.MCSM.URL)
+		report("FAIL", "apikey", "panel refused the request (status %d)", dash.Status)
+	case errors.As(err, &httpErr) && (httpErr.Status == http.StatusUnauthorized || httpErr.Status == http.StatusForbidden):
+		report("ok", "mcsm", "panel %s reachable", cfg.MCSM.URL)
+		report("FAIL", "apikey", "rejected (http %d), check mcsm.apikey", httpErr.Status)
+	default:
+		report("FAIL", "mcsm", "panel %s: %v", cfg.MCSM.URL, err)
+		report("FAIL", "apikey", "not checked: panel unreachable")
+	}
+
+	if !schemaOK || !panelOK {
+		report("warn", "bindings", "not checked: needs a current database and a working panel")
+		report("warn", "daemon", "not checked: needs a current database and a working panel")
+		return exitCode(failed)
+	}
+	repo, err := data.NewSQLiteRepo(cfg.DBPath)
+	if err != nil {
+		report("FAIL", "db", "open: %v", err)
+		return exitCode(failed)
+	}
+	defer repo.Close()
+	bindings, err := repo.GetAllBindings()
+	if err != nil {
+		report("FAIL", "bindings", "%v", err)
+		return exitCode(failed)
+	}
+
+	// Same daemon as the workflows, see the "local" assumption in the service package.
+	const daemonID = "local"
+	missing := 0
+	var probe *data.BindingInstance
+	probePath := ""
+	for _, b := range bindings {
+		profile, err := cfg.Profiles.Get(b.Profile)
+		if err != nil {
+			missing++
+			report("FAIL", "bindings", "%s: %v", b.Alias, err)
+		}
+		for _, inst := range b.Instances {
+			detail, err := mc.InstanceDetail(inst.InstanceID, daemonID)
+			if err == nil && detail.Status != http.StatusOK {
+				err = fmt.Errorf("panel answered status %d", detail.Status)
+			}
+			if err != nil {
+				missing++
+				report("FAIL", "bindings", "%s/%s: instance %s: %v", b.Alias, inst.Role, inst.InstanceID, err)
+				continue
+			}
+			if probe == nil && inst.Role == "protocol" && profile.QRCodePath != "" {
+				probe, probePath = &inst, profile.QRCodePath
+			}
+		}
+	}
+	if missing == 0 {
+		report("ok", "bindings", "%d bindings, all instances and profiles found", len(bindings))
+	}
+
+	// QR codes are fetched from the daemon directly, at the address the panel hands out.
+	if probe == nil {
+		report("warn", "daemon", "not checked: no binding with a protocol instance")
+		return exitCode(failed)
+	}
+	dl, err := mc.DownloadConfig(probe.InstanceID, daemonID, probePath)
+	if err != nil {
+		report("FAIL", "daemon", "download not granted: %v", err)
+		return exitCode(failed)
+	}
+	if dl.Data.Addr == "" {
+		report("FAIL", "daemon", "panel returned no daemon address")
+		return exitCode(failed)
+	}
+	resp, err := mc.HTTP.Get("http://" + dl.Data.Addr + "/")
+	if err != nil {
+		report("FAIL", "daemon", "%s unreachable for downloads: %v", dl.Data.Addr, err)
+		return exitCode(failed)
+	}
+	resp.Body.Close()
+	report("ok", "daemon", "%s reachable for downloads", dl.Data.Addr)
+	return exitCode(failed)
+}
+
+// checkStore checks that the temp store of public.store is usable, without
+// touching files the running server may be serving.
+func checkStore(cfg *config.Config) (string, error) {
+	switch cfg.Public.Store {
+	case "memory":
+		return "temp files in memory", nil
+	case "s3":
+		c := cfg.Public.S3
+		client, err := s3.NewClient(c.Endpoint, c.Region, c.Bucket, c.AccessKey, c.SecretKey, c.PathStyle)
+		if err != nil {
+			return "", err
+		}
+		client.HTTP.Timeout = doctorTimeout
+		// A missing object answers 404 once the bucket exists and the credentials are accepted.
+		_, err = client.GetObject(c.Prefix + "doctor-probe")
+		var s3Err *s3.Error
+		if err != nil && (!s3.IsNotFound(err) || errors.As(err, &s3Err) && s3Err.Code == "NoSuchBucket") {
+			return "", fmt.Errorf("bucket %s at %s: %v", c.Bucket, c.Endpoint, err)
+		}
+		return fmt.Sprintf("bucket %s at %s reachable", c.Bucket, c.Endpoint), nil
+	}
+	if err := os.MkdirAll(cfg.Public.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("temp dir %s: %v", cfg.Public.Dir, err)
+	}
+	f, err := os.CreateTemp(cfg.Public.Dir, ".doctor-*")
+	if err != nil {
+		return "", fmt.Errorf("temp dir %s not writable: %v", cfg.Public.Dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return fmt.Sprintf("temp dir %s writable", cfg.Public.Dir), nil
+}
+
+// unjoin splits an errors.Join result back into its parts.
+func unjoin(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
+
+func exitCode(failed bool) int {
+	if failed {
+		return 1
+	}
+	return 0
+}
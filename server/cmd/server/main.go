@@ -1,42 +1,163 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 	"sealdice-mcsm/server/config"
 	"sealdice-mcsm/server/internal/api"
 	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/logging"
+	"sealdice-mcsm/server/internal/metrics"
 	"sealdice-mcsm/server/internal/service"
 	"sealdice-mcsm/server/pkg/mcsm"
+	"sealdice-mcsm/server/pkg/tempstore"
 )
 
 func main() {
-	cfg := config.Load()
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve runs the bridge until SIGINT or SIGTERM, see shutdown.
+func serve(cfg *config.Config) {
+	// Until the configured logger exists, errors go to the default one.
+	if err := cfg.Validate(); err != nil {
+		fatal(slog.Default(), "invalid config", err)
+	}
+
+	// Logger; also the default, so the standard log package and libraries go through it
+	level, _ := logging.ParseLevel(cfg.Log.Level) // checked by Validate
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(level)
+	lg, err := logging.New(os.Stderr, cfg.Log.Format, logLevel)
+	if err != nil {
+		fatal(slog.Default(), "invalid config", err)
+	}
+	slog.SetDefault(lg)
 
 	// Repo
 	repo, err := data.NewSQLiteRepo(cfg.DBPath)
 	if err != nil {
-		log.Fatal("Failed to init DB:", err)
+		fatal(lg, "failed to init DB", err)
 	}
 	defer repo.Close()
 
 	// Clients
 	mcClient := mcsm.NewClient(cfg.MCSM.URL, cfg.MCSM.APIKey)
+	mcClient.Observe = metrics.ObserveMCSM
+	mcClient.Log = lg.With("component", "mcsm")
 
 	// Service
-	svc := service.NewService(cfg, repo, mcClient)
+	svc, err := service.NewService(cfg, repo, mcClient, lg)
+	if err != nil {
+		fatal(lg, "failed to init services", err)
+	}
+	svc.LogLevel = logLevel
+	if err := svc.SchedulerSvc.Start(); err != nil {
+		fatal(lg, "failed to start scheduler", err)
+	}
+	svc.AuditSvc.Start()
+	defer svc.AuditSvc.Stop()
+	janitor := tempstore.NewJanitor(svc.TempStore, cfg.Public.SweepInterval)
+	janitor.Log = lg.With("component", "tempstore")
+	janitor.Start()
+	defer janitor.Stop()
+	svc.MonitorSvc.Start()
+	defer svc.MonitorSvc.Stop()
 
 	// API
 	handler := api.NewHandler(svc, cfg)
+	if n, err := svc.WorkflowSvc.Resume(handler.Hub); err != nil {
+		lg.Error("failed to resume saved workflows", "err", err)
+	} else if n > 0 {
+		lg.Info("resumed saved workflows", "count", n)
+	}
+
+	// Apply config file edits without a restart; an invalid file keeps the running config.
+	watching := config.Watch(func(next *config.Config, err error) {
+		var ev *service.ConfigReloaded
+		if err == nil {
+			ev, err = svc.Reload(next)
+		}
+		if err != nil {
+			lg.Error("config reload rejected, keeping the running config", "err", err)
+			return
+		}
+		if ev != nil {
+			// Sessions of a rotated config token, or opened while auth was off, end here.
+			handler.DropInvalid()
+			handler.Hub.SendEvent(service.EventConfigReloaded, ev)
+		}
+	})
+	if !watching {
+		lg.Debug("no config file, reload disabled")
+	}
 
-	// Router
-	r := gin.Default()
+	// Router; requests are logged by the handler's middleware
+	if level > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	r.Use(gin.Recovery())
 	handler.SetupRoutes(r)
 
 	// Run
-	log.Printf("Server starting on %s", cfg.Server.Port)
-	if err := r.Run(cfg.Server.Port); err != nil {
-		log.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: cfg.Server.Port, Handler: r}
+	errCh := make(chan error, 1)
+	go func() {
+		lg.Info("server starting", "addr", cfg.Server.Port)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		fatal(lg, "server failed", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process
+	lg.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout.String())
+	shutdown(cfg, lg, svc, handler, srv)
+	lg.Info("server stopped")
+}
+
+func fatal(lg *slog.Logger, msg string, err error) {
+	lg.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// shutdown stops taking requests, lets running workflows reach a safe point (or saves
+// them) within server.shutdown_timeout, then closes WS connections. The deferred
+// stops in main run after it, closing the repo last.
+func shutdown(cfg *config.Config, lg *slog.Logger, svc *service.Service, handler *api.Handler, srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	handler.BeginShutdown()
+	// Stops the listener and waits for REST requests; hijacked WS connections are not included
+	if err := srv.Shutdown(ctx); err != nil {
+		lg.Warn("http shutdown", "err", err)
+	}
+	if err := svc.WorkflowSvc.Shutdown(ctx); err != nil {
+		lg.Warn("workflow shutdown", "err", err)
+	}
+	handler.CloseConnections()
+
+	// Scheduled jobs still running are past their safe points; do not wait past the deadline
+	done := make(chan struct{})
+	go func() {
+		svc.SchedulerSvc.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		lg.Warn("scheduler shutdown: jobs still running")
 	}
 }
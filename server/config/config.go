@@ -1,20 +1,29 @@
 package config
 
 import (
-	"log"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	Server struct {
 		Port string `mapstructure:"port"`
+		// ShutdownTimeout bounds how long a stop waits for running workflows.
+		ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
 	} `mapstructure:"server"`
-	Auth struct {
-		Enable bool   `mapstructure:"enable"`
-		Token  string `mapstructure:"token"`
-	} `mapstructure:"auth"`
+	Auth Auth `mapstructure:"auth"`
+	ACL  struct {
+		Enable       bool     `mapstructure:"enable"`
+		AdminActions []string `mapstructure:"admin_actions"`
+	} `mapstructure:"acl"`
+	Audit struct {
+		Retention time.Duration `mapstructure:"retention"` // 0 keeps entries forever
+	} `mapstructure:"audit"`
 	MCSM struct {
 		URL    string `mapstructure:"url"`
 		APIKey string `mapstructure:"apikey"`
@@ -22,10 +31,131 @@ type Config struct {
 	App struct {
 		ExternalURL string `mapstructure:"external_url"`
 	} `mapstructure:"app"`
-	DBPath string
+	Public struct {
+		Secret  string        `mapstructure:"secret"` // HMAC key for /public links, random per run if empty
+		URLTTL  time.Duration `mapstructure:"url_ttl"`
+		MaxUses int           `mapstructure:"max_uses"` // fetches allowed per link, 0 = until expiry
+		Store   string        `mapstructure:"store"`    // "disk", "memory" or "s3"
+		Dir     string        `mapstructure:"dir"`      // disk store directory
+		FileTTL time.Duration `mapstructure:"file_ttl"` // how long a temp file is kept
+		// Quotas in bytes, 0 = unlimited
+		MaxFileSize   int64         `mapstructure:"max_file_size"`
+		MaxTotalSize  int64         `mapstructure:"max_total_size"`
+		SweepInterval time.Duration `mapstructure:"sweep_interval"`
+		// S3 is used with store: s3; links are then presigned bucket URLs valid for url_ttl.
+		S3 struct {
+			Endpoint  string `mapstructure:"endpoint"`
+			Region    string `mapstructure:"region"`
+			Bucket    string `mapstructure:"bucket"`
+			AccessKey string `mapstructure:"access_key"`
+			SecretKey string `mapstructure:"secret_key"`
+			PathStyle bool   `mapstructure:"path_style"`
+			Prefix    string `mapstructure:"prefix"`
+		} `mapstructure:"s3"`
+	} `mapstructure:"public"`
+	Log struct {
+		Level  string `mapstructure:"level"`  // debug, info, warn, error
+		Format string `mapstructure:"format"` // text or json
+	} `mapstructure:"log"`
+	Metrics struct {
+		Enable bool `mapstructure:"enable"` // serve /metrics
+	} `mapstructure:"metrics"`
+	Monitor struct {
+		Interval time.Duration `mapstructure:"interval"` // instance status polling, 0 disables
+	} `mapstructure:"monitor"`
+	Profiles Profiles `mapstructure:"profiles"`
+	DBPath   string   `mapstructure:"db_path"`
 }
 
-func Load() *Config {
+// Auth is its own type so it can be swapped as a whole on reload.
+type Auth struct {
+	Enable bool   `mapstructure:"enable"`
+	Token  string `mapstructure:"token"` // plaintext or a "sha256$salt$digest" hash
+	// AllowQueryToken accepts ?token= on /ws. Off by default: URLs end up in proxy logs.
+	AllowQueryToken bool `mapstructure:"allow_query_token"`
+}
+
+// DefaultProfile is the profile of bindings that do not name one.
+const DefaultProfile = "default"
+
+// Profile holds what the relogin workflow needs to know about a protocol
+// implementation: where it writes its QR code and how long to wait.
+type Profile struct {
+	QRCodePath     string        `mapstructure:"qrcode_path"` // relative to the protocol instance's directory
+	QRCodeTimeout  time.Duration `mapstructure:"qrcode_timeout"`
+	ConfirmTimeout time.Duration `mapstructure:"confirm_timeout"` // for "continue" after the QR code is sent
+}
+
+// Profiles maps profile names to profiles; it always holds DefaultProfile.
+type Profiles map[string]Profile
+
+// Get returns the named profile, DefaultProfile for an empty name.
+func (p Profiles) Get(name string) (Profile, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	prof, ok := p[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q", name)
+	}
+	return prof, nil
+}
+
+// Load reads config.yaml from . or ./config and the SEALDICE_* environment.
+// Without a config file the defaults and environment are used; a file that
+// cannot be read or decoded is an error.
+func Load() (*Config, error) {
+	v := newViper()
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+	}
+
+	c, err := decode(v)
+	if err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+	return c, nil
+}
+
+// watchDelay lets a save settle: editors often truncate and write in separate steps.
+const watchDelay = 200 * time.Millisecond
+
+// Watch calls fn with the re-read config every time the config file changes,
+// or with the error if it no longer decodes. fn runs on its own goroutine, one
+// call at a time. Without a config file there is nothing to watch and Watch
+// returns false.
+func Watch(fn func(*Config, error)) bool {
+	v := newViper()
+	if err := v.ReadInConfig(); err != nil {
+		return false
+	}
+	var mu sync.Mutex
+	var timer *time.Timer
+	v.OnConfigChange(func(fsnotify.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		// A fresh viper: v belongs to the watcher goroutine, which re-reads it on every event.
+		timer = time.AfterFunc(watchDelay, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			r := newViper()
+			if err := r.ReadInConfig(); err != nil {
+				fn(nil, err)
+				return
+			}
+			fn(decode(r))
+		})
+	})
+	v.WatchConfig()
+	return true
+}
+
+func newViper() *viper.Viper {
 	v := viper.New()
 	v.SetConfigName("config")
 	v.SetConfigType("yaml")
@@ -33,22 +163,42 @@ func Load() *Config {
 	v.AddConfigPath("./config")
 
 	v.SetDefault("server.port", ":8088")
+	v.SetDefault("server.shutdown_timeout", "30s")
 	v.SetDefault("auth.enable", false)
+	v.SetDefault("auth.allow_query_token", false)
+	v.SetDefault("acl.enable", false)
+	v.SetDefault("acl.admin_actions", []string{"kill"})
+	v.SetDefault("audit.retention", "720h")
+	v.SetDefault("public.url_ttl", "5m")
+	v.SetDefault("public.max_uses", 3)
+	v.SetDefault("public.store", "disk")
+	v.SetDefault("public.dir", "./temp")
+	v.SetDefault("public.file_ttl", "5m")
+	v.SetDefault("public.max_file_size", 1<<20)
+	v.SetDefault("public.max_total_size", 64<<20)
+	v.SetDefault("public.sweep_interval", "1m")
+	v.SetDefault("public.s3.region", "us-east-1")
+	v.SetDefault("public.s3.path_style", true)
+	v.SetDefault("public.s3.prefix", "qrcode/")
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "text")
+	v.SetDefault("metrics.enable", true)
+	v.SetDefault("monitor.interval", "1m")
+	v.SetDefault("profiles.default.qrcode_path", "qrcode.png")
+	v.SetDefault("profiles.default.qrcode_timeout", "1m")
+	v.SetDefault("profiles.default.confirm_timeout", "3m")
 	v.SetDefault("db_path", "data.db")
 
 	v.SetEnvPrefix("SEALDICE")
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
+	return v
+}
 
-	if err := v.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			log.Printf("Error reading config file: %v", err)
-		}
-	}
-
+func decode(v *viper.Viper) (*Config, error) {
 	var c Config
 	if err := v.Unmarshal(&c); err != nil {
-		log.Fatalf("Unable to decode config: %v", err)
+		return nil, err
 	}
 
 	// Manual fallback for DBPath if not in structure
@@ -57,5 +207,20 @@ func Load() *Config {
 		c.DBPath = "data.db"
 	}
 
-	return &c
+	// Fields a profile leaves out come from the default profile.
+	def := c.Profiles[DefaultProfile]
+	for name, p := range c.Profiles {
+		if p.QRCodePath == "" {
+			p.QRCodePath = def.QRCodePath
+		}
+		if p.QRCodeTimeout == 0 {
+			p.QRCodeTimeout = def.QRCodeTimeout
+		}
+		if p.ConfirmTimeout == 0 {
+			p.ConfirmTimeout = def.ConfirmTimeout
+		}
+		c.Profiles[name] = p
+	}
+
+	return &c, nil
 }
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sealdice-mcsm/server/internal/logging"
+	"sealdice-mcsm/server/pkg/tokenhash"
+)
+
+// Validate checks every field and reports all problems at once, each prefixed
+// with its config key, so a bad file can be fixed in one pass.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if err := checkAddr(c.Server.Port); err != nil {
+		bad("server.port", "%v", err)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		bad("server.shutdown_timeout", "must be positive")
+	}
+
+	// An empty auth.token is fine with auth enabled: issued tokens still authenticate.
+	if strings.HasPrefix(c.Auth.Token, tokenhash.Prefix) && !tokenhash.Valid(c.Auth.Token) {
+		bad("auth.token", "malformed hash, generate one with `server token hash`")
+	}
+	for _, a := range c.ACL.AdminActions {
+		if strings.TrimSpace(a) == "" {
+			bad("acl.admin_actions", "empty action name")
+		}
+	}
+	if c.Audit.Retention < 0 {
+		bad("audit.retention", "must not be negative")
+	}
+
+	if c.MCSM.URL == "" {
+		bad("mcsm.url", "required")
+	} else if err := checkURL(c.MCSM.URL); err != nil {
+		bad("mcsm.url", "%v", err)
+	}
+	if c.MCSM.APIKey == "" {
+		bad("mcsm.apikey", "required")
+	}
+	if c.App.ExternalURL != "" {
+		if err := checkURL(c.App.ExternalURL); err != nil {
+			bad("app.external_url", "%v", err)
+		}
+	}
+
+	p := &c.Public
+	if p.URLTTL <= 0 {
+		bad("public.url_ttl", "must be positive")
+	}
+	if p.MaxUses < 0 {
+		bad("public.max_uses", "must not be negative")
+	}
+	if p.FileTTL <= 0 {
+		bad("public.file_ttl", "must be positive")
+	}
+	if p.MaxFileSize < 0 {
+		bad("public.max_file_size", "must not be negative")
+	}
+	if p.MaxTotalSize < 0 {
+		bad("public.max_total_size", "must not be negative")
+	}
+	if p.MaxFileSize > 0 && p.MaxTotalSize > 0 && p.MaxFileSize > p.MaxTotalSize {
+		bad("public.max_file_size", "larger than public.max_total_size")
+	}
+	if p.SweepInterval < 0 {
+		bad("public.sweep_interval", "must not be negative")
+	}
+	switch p.Store {
+	case "disk":
+		if p.Dir == "" {
+			bad("public.dir", "required with store: disk")
+		}
+	case "memory":
+	case "s3":
+		if p.S3.Endpoint == "" {
+			bad("public.s3.endpoint", "required with store: s3")
+		} else if err := checkURL(p.S3.Endpoint); err != nil {
+			bad("public.s3.endpoint", "%v", err)
+		}
+		if p.S3.Bucket == "" {
+			bad("public.s3.bucket", "required with store: s3")
+		}
+		if p.S3.Region == "" {
+			bad("public.s3.region", "required with store: s3")
+		}
+		if p.S3.AccessKey == "" || p.S3.SecretKey == "" {
+			bad("public.s3", "access_key and secret_key are required with store: s3")
+		}
+	default:
+		bad("public.store", "unknown store %q, want disk, memory or s3", p.Store)
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		bad("log.level", "%v", err)
+	}
+	if f := strings.ToLower(c.Log.Format); f != "text" && f != "json" {
+		bad("log.format", "unknown format %q, want text or json", c.Log.Format)
+	}
+	if c.Monitor.Interval < 0 {
+		bad("monitor.interval", "must not be negative")
+	}
+	if _, ok := c.Profiles[DefaultProfile]; !ok {
+		bad("profiles", "the %s profile is required", DefaultProfile)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		prof, key := c.Profiles[name], "profiles."+name
+		if prof.QRCodePath == "" {
+			bad(key+".qrcode_path", "required")
+		}
+		if prof.QRCodeTimeout <= 0 {
+			bad(key+".qrcode_timeout", "must be positive")
+		}
+		if prof.ConfirmTimeout <= 0 {
+			bad(key+".confirm_timeout", "must be positive")
+		}
+	}
+	if c.DBPath == "" {
+		bad("db_path", "required")
+	}
+	return errors.Join(errs...)
+}
+
+// checkAddr accepts a listen address such as ":8088" or "127.0.0.1:8088".
+func checkAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("want host:port or :port, got %q", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q", s)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("want an http or https URL, got %q", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", s)
+	}
+	return nil
+}
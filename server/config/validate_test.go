@@ -0,0 +1,80 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// valid returns the defaults plus the keys that have none.
+func valid(t *testing.T) *Config {
+	t.Helper()
+	c, err := decode(newViper())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MCSM.URL, c.MCSM.APIKey = "http://127.0.0.1:23333", "key"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	if err := valid(t).Validate(); err != nil {
+		t.Fatalf("defaults do not validate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string // keys reported, in order
+	}{
+		{"bad port", func(c *Config) { c.Server.Port = "8088" }, []string{"server.port"}},
+		{"port out of range", func(c *Config) { c.Server.Port = ":70000" }, []string{"server.port"}},
+		{"malformed token hash", func(c *Config) { c.Auth.Token = "sha256$zz" }, []string{"auth.token"}},
+		{"plaintext token", func(c *Config) { c.Auth.Token = "plain" }, nil},
+		{"mcsm missing", func(c *Config) { c.MCSM.URL, c.MCSM.APIKey = "", "" }, []string{"mcsm.url", "mcsm.apikey"}},
+		{"mcsm url without scheme", func(c *Config) { c.MCSM.URL = "127.0.0.1:23333" }, []string{"mcsm.url"}},
+		{"file size over total", func(c *Config) { c.Public.MaxFileSize, c.Public.MaxTotalSize = 10, 5 }, []string{"public.max_file_size"}},
+		{"unknown store", func(c *Config) { c.Public.Store = "ftp" }, []string{"public.store"}},
+		{"s3 without settings", func(c *Config) { c.Public.Store, c.Public.S3.Region = "s3", "" },
+			[]string{"public.s3.endpoint", "public.s3.bucket", "public.s3.region", "public.s3"}},
+		{"log settings", func(c *Config) { c.Log.Level, c.Log.Format = "loud", "xml" }, []string{"log.level", "log.format"}},
+		{"no default profile", func(c *Config) { delete(c.Profiles, DefaultProfile) }, []string{"profiles"}},
+		{"profiles checked in name order", func(c *Config) {
+			c.Profiles["zz"] = Profile{QRCodePath: "q.png", QRCodeTimeout: time.Minute}
+			c.Profiles["aa"] = Profile{QRCodeTimeout: time.Minute, ConfirmTimeout: time.Minute}
+		}, []string{"profiles.aa.qrcode_path", "profiles.zz.confirm_timeout"}},
+		{"everything at once", func(c *Config) {
+			c.Server.ShutdownTimeout = 0
+			c.Audit.Retention = -1
+			c.Public.URLTTL = 0
+			c.Public.MaxUses = -1
+			c.Monitor.Interval = -time.Second
+			c.DBPath = ""
+		}, []string{"server.shutdown_timeout", "audit.retention", "public.url_ttl", "public.max_uses", "monitor.interval", "db_path"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid(t)
+			tt.change(c)
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate = nil, want errors for %v", tt.want)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			var keys []string
+			for _, l := range lines {
+				key, _, _ := strings.Cut(l, ": ")
+				keys = append(keys, key)
+			}
+			if strings.Join(keys, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("reported keys %v, want %v\n%s", keys, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,741 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+	"sealdice-mcsm/server/pkg/mcsm"
+)
+
+// Permission is the scope an action requires from the caller.
+type Permission string
+
+const (
+	PermRead      Permission = service.ScopeRead
+	PermControl   Permission = service.ScopeControl
+	PermBindAdmin Permission = service.ScopeBindAdmin
+	PermWorkflow  Permission = service.ScopeWorkflow
+	PermAdmin     Permission = service.ScopeAdmin
+)
+
+// Error codes carried in WSResponse.code. They follow the HTTP status of the same meaning.
+const (
+	CodeOK            = 200
+	CodeBadRequest    = 400 // params failed to decode or validate
+	CodeUnauthorized  = 401
+	CodeForbidden     = 403 // caller lacks the action's scope or the alias is outside its whitelist
+	CodeNotFound      = 404 // unknown action, alias, job...
+	CodeConflict      = 409 // e.g. relogin already running
+	CodeInternal      = 500
+	CodeBadGateway    = 502 // MCSM call failed
+	CodeUnavailable   = 503 // server is shutting down
+	CodeUnknownAction = CodeNotFound
+)
+
+// ActionError attaches an error code to an error returned by an action.
+type ActionError struct {
+	Code int
+	Err  error
+}
+
+func (e *ActionError) Error() string { return e.Err.Error() }
+func (e *ActionError) Unwrap() error { return e.Err }
+
+func codeErr(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ActionError{Code: code, Err: err}
+}
+
+// errorCode maps an action error to its code.
+func errorCode(err error) int {
+	var ae *ActionError
+	if errors.As(err, &ae) {
+		return ae.Code
+	}
+	if data.IsNotFound(err) {
+		return CodeNotFound
+	}
+	if errors.Is(err, service.ErrForbidden) {
+		return CodeForbidden
+	}
+	if errors.Is(err, service.ErrShuttingDown) {
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// actionCtx is what a running action knows about its request.
+type actionCtx struct {
+	Action   string
+	ReqID    string
+	Caller   *service.Principal
+	Chat     *service.ChatCaller // chat user the plugin acts for, nil for other clients
+	Params   any                 // decoded params, for the audit log
+	Notifier service.Notifier
+	Log      *slog.Logger // carries req_id, action and caller
+}
+
+type actionSpec struct {
+	Name     string
+	Doc      string
+	Perm     Permission
+	Params   any   // zero value of the params struct, nil if the action takes none
+	Response []any // possible data payloads
+	run      func(h *Handler, ctx *actionCtx, raw json.RawMessage) (any, error)
+}
+
+type validator interface {
+	Validate() error
+}
+
+// aliasDefaulter is implemented by params naming a binding that, when sent from a chat
+// group, fall back to the group's default alias.
+type aliasDefaulter interface {
+	MissingAlias() bool
+	SetAlias(alias string)
+}
+
+// action builds a registry entry for a handler taking params of type P.
+// Params are decoded strictly (unknown keys fail) and validated before fn runs.
+func action[P any](name, doc string, perm Permission, resp []any, fn func(h *Handler, ctx *actionCtx, p *P) (any, error)) actionSpec {
+	var zero P
+	return actionSpec{
+		Name:     name,
+		Doc:      doc,
+		Perm:     perm,
+		Params:   zero,
+		Response: resp,
+		run: func(h *Handler, ctx *actionCtx, raw json.RawMessage) (any, error) {
+			p := new(P)
+			if err := decodeStrict(raw, p); err != nil {
+				return nil, codeErr(CodeBadRequest, fmt.Errorf("invalid params: %v", err))
+			}
+			ctx.Params = p
+			if d, ok := any(p).(aliasDefaulter); ok && d.MissingAlias() {
+				if err := h.defaultAlias(ctx, d); err != nil {
+					return nil, err
+				}
+			}
+			if v, ok := any(p).(validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, codeErr(CodeBadRequest, err)
+				}
+			}
+			return fn(h, ctx, p)
+		},
+	}
+}
+
+// noParams is the params type of actions that take none.
+type noParams struct{}
+
+func decodeStrict(raw json.RawMessage, out any) error {
+	if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		raw = json.RawMessage("{}")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(out)
+}
+
+var controlResponses = []any{ControlResponse{}, StatusResponse{}, BulkResponse{}}
+
+// actionRegistry holds every WS action, keyed by name.
+var actionRegistry = func() map[string]actionSpec {
+	specs := []actionSpec{
+		action("bind", "Create or replace a binding", PermBindAdmin, []any{StatusResponse{}}, actBind),
+		action("update_binding", "Change description and/or tags", PermBindAdmin, []any{data.Binding{}}, actUpdateBinding),
+		action("unbind", "Delete a binding", PermBindAdmin, []any{StatusResponse{}}, actUnbind),
+		action("get_binding", "Fetch one binding", PermRead, []any{data.Binding{}}, actGetBinding),
+		action("list_bindings", "List bindings, optionally by tag", PermRead, []any{[]data.Binding{}}, actListBindings),
+
+		action("start", "Start an instance, binding or selection", PermControl, controlResponses, actControl),
+		action("stop", "Stop an instance, binding or selection", PermControl, controlResponses, actControl),
+		action("restart", "Restart an instance, binding or selection", PermControl, controlResponses, actControl),
+		action("fstop", "Force stop an instance or binding", PermControl, controlResponses, actControl),
+		action("kill", "Kill an instance or binding", PermControl, controlResponses, actControl),
+		action("status", "Panel dashboard, instance detail or selection status", PermRead,
+			[]any{mcsm.DashboardResponse{}, mcsm.InstanceDetailResponse{}, BulkResponse{}}, actStatus),
+
+		action("bulk_start", "Start every selected binding", PermControl, []any{BulkResponse{}}, actBulk),
+		action("bulk_stop", "Stop every selected binding", PermControl, []any{BulkResponse{}}, actBulk),
+		action("bulk_restart", "Restart every selected binding", PermControl, []any{BulkResponse{}}, actBulk),
+		action("bulk_status", "Status of every selected binding", PermRead, []any{BulkResponse{}}, actBulk),
+
+		action("job_create", "Schedule a job", PermWorkflow, []any{data.Job{}}, actJobCreate),
+		action("job_list", "List scheduled jobs", PermRead, []any{[]JobView{}}, actJobList),
+		action("job_pause", "Pause a job", PermWorkflow, []any{StatusResponse{}}, actJobUpdate),
+		action("job_resume", "Resume a paused job", PermWorkflow, []any{StatusResponse{}}, actJobUpdate),
+		action("job_delete", "Delete a job", PermWorkflow, []any{StatusResponse{}}, actJobUpdate),
+
+		action("relogin", "Start the QR relogin workflow", PermWorkflow, []any{StatusResponse{}}, actRelogin),
+		action("continue", "Confirm the QR scan of a running relogin", PermWorkflow, []any{StatusResponse{}}, actContinue),
+
+		action("group_link", "Link a chat group to a binding", PermBindAdmin, []any{StatusResponse{}}, actGroupLink),
+		action("group_unlink", "Unlink a chat group from a binding", PermBindAdmin, []any{StatusResponse{}}, actGroupLink),
+		action("group_list", "List group links of one or every group", PermRead, []any{[]data.GroupLink{}}, actGroupList),
+
+		action("token_create", "Issue an API token; the plaintext is only returned here", PermAdmin, []any{TokenIssued{}}, actTokenCreate),
+		action("token_list", "List API tokens", PermAdmin, []any{[]data.Token{}}, actTokenList),
+		action("token_revoke", "Revoke an API token", PermAdmin, []any{StatusResponse{}}, actTokenRevoke),
+		action("acl_list", "List chat ACL rules", PermAdmin, []any{[]data.ACLRule{}}, actACLList),
+		action("acl_set", "Create or replace a chat ACL rule", PermAdmin, []any{data.ACLRule{}}, actACLSet),
+		action("acl_delete", "Delete a chat ACL rule", PermAdmin, []any{StatusResponse{}}, actACLDelete),
+		action("audit_query", "Query the audit log, newest first", PermAdmin, []any{[]data.AuditEntry{}}, actAuditQuery),
+		action("export", "Dump bindings, group links, schedules, tokens (hashed) and ACL rules", PermAdmin, []any{service.Export{}}, actExport),
+		action("import", "Apply an export in one transaction", PermAdmin, []any{service.ImportResult{}}, actImport),
+	}
+
+	m := make(map[string]actionSpec, len(specs))
+	for _, s := range specs {
+		if _, dup := m[s.Name]; dup {
+			panic("duplicate action " + s.Name)
+		}
+		if _, ok := s.Params.(noParams); ok {
+			s.Params = nil
+		}
+		m[s.Name] = s
+	}
+	return m
+}()
+
+// sortedActions returns the registry in name order, for documents.
+func sortedActions() []actionSpec {
+	out := make([]actionSpec, 0, len(actionRegistry))
+	for _, s := range actionRegistry {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Dispatch runs a WS action by name.
+func (h *Handler) Dispatch(ctx *actionCtx, params json.RawMessage) (any, error) {
+	spec, ok := actionRegistry[ctx.Action]
+	if !ok {
+		return nil, codeErr(CodeUnknownAction, fmt.Errorf("unknown command: %s", ctx.Action))
+	}
+	if err := checkScope(ctx.Caller, spec.Perm); err != nil {
+		return nil, err
+	}
+	caller, err := h.Svc.ACLSvc.Apply(ctx.Caller, ctx.Chat, ctx.Action, string(spec.Perm))
+	if err != nil {
+		return nil, err
+	}
+	ctx.Caller = caller
+	return spec.run(h, ctx, params)
+}
+
+// defaultAlias fills d with the default alias of the caller's chat group, if any.
+func (h *Handler) defaultAlias(ctx *actionCtx, d aliasDefaulter) error {
+	if ctx.Chat == nil || ctx.Chat.GroupID == "" {
+		return nil
+	}
+	alias, err := h.Svc.GroupSvc.DefaultAlias(ctx.Chat.GroupID)
+	if err != nil {
+		return err
+	}
+	if alias != "" {
+		d.SetAlias(alias)
+	}
+	return nil
+}
+
+// groupPendingRelogin picks the relogin waiting for "continue" among the aliases of the caller's group.
+func (h *Handler) groupPendingRelogin(ctx *actionCtx) (string, error) {
+	if ctx.Chat == nil || ctx.Chat.GroupID == "" {
+		return "", codeErr(CodeBadRequest, fmt.Errorf("alias required"))
+	}
+	links, err := h.Svc.GroupSvc.Links(ctx.Chat.GroupID)
+	if err != nil {
+		return "", err
+	}
+	pending := h.Svc.WorkflowSvc.Pending()
+	var found []string
+	for _, l := range links {
+		if slices.Contains(pending, l.Alias) {
+			found = append(found, l.Alias)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", codeErr(CodeConflict, fmt.Errorf("no relogin pending for this group"))
+	case 1:
+		return found[0], nil
+	default:
+		return "", codeErr(CodeBadRequest, fmt.Errorf("several relogins pending (%s), name one", strings.Join(found, ", ")))
+	}
+}
+
+// target is what TargetParams resolved to: a Selector, a whole binding (Alias only),
+// or a single instance (InstanceID, with Alias/Role when it came from a binding).
+type target struct {
+	Selector   *service.Selector
+	Alias      string
+	Role       string
+	InstanceID string
+}
+
+// resolveTarget turns target/alias/role params into a selector, a whole binding or one instance.
+// Without a role, defaultRole is used, or the binding's first role if it has no defaultRole.
+// An unknown alias longer than 20 chars is taken as a raw instance UUID.
+// Selectors are limited to, and aliases checked against, the caller's whitelist.
+func (h *Handler) resolveTarget(ctx *actionCtx, p TargetParams, defaultRole string) (target, error) {
+	name := p.Target
+	if name == "" {
+		name = p.Alias
+	}
+
+	if service.IsSelector(name) {
+		sel, err := service.ParseSelector(name)
+		if err != nil {
+			return target{}, codeErr(CodeBadRequest, err)
+		}
+		restrictSelector(ctx.Caller, &sel)
+		return target{Selector: &sel}, nil
+	}
+
+	binding, err := h.allowedBinding(ctx.Caller, name)
+	if err != nil {
+		if data.IsNotFound(err) && len(name) > 20 {
+			if err := requireUnrestricted(ctx.Caller, "raw instance id"); err != nil {
+				return target{}, err
+			}
+			return target{InstanceID: name}, nil
+		}
+		return target{}, err
+	}
+
+	role := service.RoleOrDefault(binding, p.Role, defaultRole)
+	if role == "" || role == service.RoleBoth {
+		return target{Alias: binding.Alias}, nil
+	}
+	inst, ok := binding.Instance(role)
+	if !ok {
+		return target{}, codeErr(CodeBadRequest, fmt.Errorf("role %q not in binding %s (roles: %s)",
+			role, binding.Alias, strings.Join(binding.Roles(), ", ")))
+	}
+	return target{Alias: binding.Alias, Role: role, InstanceID: inst.InstanceID}, nil
+}
+
+func actBind(h *Handler, ctx *actionCtx, p *BindParams) (any, error) {
+	// Both the binding being replaced and the new one must be inside the whitelist.
+	if _, err := h.allowedBinding(ctx.Caller, p.Alias); err != nil && !data.IsNotFound(err) {
+		return nil, err
+	}
+	if err := ctx.Caller.CheckBinding(&data.Binding{Alias: p.Alias, Tags: p.Tags}); err != nil {
+		return nil, err
+	}
+
+	instances := []data.BindingInstance(p.Instances)
+	if len(instances) == 0 {
+		instances = []data.BindingInstance{
+			{Role: service.RoleProtocol, InstanceID: p.ProtocolID},
+			{Role: service.RoleCore, InstanceID: p.CoreID},
+		}
+	}
+	if _, err := h.Svc.WorkflowSvc.Profile(p.Profile); err != nil {
+		return nil, codeErr(CodeBadRequest, err)
+	}
+	if err := h.Svc.InstanceSvc.Bind(p.Alias, instances, p.Description, p.Profile, p.Tags); err != nil {
+		return nil, codeErr(CodeBadRequest, err)
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+func actUpdateBinding(h *Handler, ctx *actionCtx, p *UpdateBindingParams) (any, error) {
+	if _, err := h.allowedBinding(ctx.Caller, p.Alias); err != nil {
+		return nil, err
+	}
+	var tags []string
+	if p.Tags != nil {
+		tags = *p.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		// Retagging must not move the binding out of the caller's reach.
+		if err := ctx.Caller.CheckBinding(&data.Binding{Alias: p.Alias, Tags: tags}); err != nil {
+			return nil, err
+		}
+	}
+	return h.Svc.InstanceSvc.UpdateMeta(p.Alias, p.Description, tags)
+}
+
+func actUnbind(h *Handler, ctx *actionCtx, p *AliasParams) (any, error) {
+	if _, err := h.allowedBinding(ctx.Caller, p.Alias); err != nil {
+		return nil, err
+	}
+	if err := h.Svc.InstanceSvc.Unbind(p.Alias); err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+func actGetBinding(h *Handler, ctx *actionCtx, p *AliasParams) (any, error) {
+	return h.allowedBinding(ctx.Caller, p.Alias)
+}
+
+func actListBindings(h *Handler, ctx *actionCtx, p *ListBindingsParams) (any, error) {
+	return h.visibleBindings(ctx.Caller, p.Tag)
+}
+
+// visibleBindings returns the bindings (optionally with tag) the caller may see.
+func (h *Handler) visibleBindings(caller *service.Principal, tag string) ([]*data.Binding, error) {
+	var (
+		all []*data.Binding
+		err error
+	)
+	if tag != "" {
+		all, err = h.Svc.InstanceSvc.GetByTag(tag)
+	} else {
+		all, err = h.Svc.InstanceSvc.GetAll()
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []*data.Binding{}
+	for _, b := range all {
+		if caller.AllowsBinding(b) {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func actControl(h *Handler, ctx *actionCtx, p *ControlParams) (any, error) {
+	t, err := h.resolveTarget(ctx, p.TargetParams, "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case t.Selector != nil:
+		// Selectors ("all", "tag:xxx", globs, alias lists) fan out like the bulk actions.
+		return h.bulk(ctx, *t.Selector, ctx.Action, int(p.Concurrency))
+	case t.InstanceID == "":
+		steps, err := h.Svc.ControlSvc.BindingAction(t.Alias, ctx.Action, ctx.Log)
+		status := "ok"
+		if err != nil {
+			status = "failed"
+		}
+		return ControlResponse{Status: status, Steps: steps}, codeErr(CodeBadGateway, err)
+	default:
+		ctx.Log.Info("instance action", "alias", t.Alias, "instance_id", t.InstanceID, "op", ctx.Action)
+		if err := h.Svc.MCSM.InstanceAction(t.InstanceID, "local", ctx.Action); err != nil {
+			return nil, codeErr(CodeBadGateway, err)
+		}
+		return StatusResponse{Status: "ok"}, nil
+	}
+}
+
+func actStatus(h *Handler, ctx *actionCtx, p *StatusParams) (any, error) {
+	if p.Target == "" && p.Alias == "" {
+		if err := requireUnrestricted(ctx.Caller, "panel dashboard"); err != nil {
+			return nil, err
+		}
+		res, err := h.Svc.MCSM.Dashboard()
+		return res, codeErr(CodeBadGateway, err)
+	}
+
+	// A bare alias reports its core instance, as before roles were configurable,
+	// or its first instance when it has no core.
+	t, err := h.resolveTarget(ctx, p.TargetParams, service.RoleCore)
+	if err != nil {
+		return nil, err
+	}
+	if t.Selector != nil {
+		return h.bulk(ctx, *t.Selector, "status", int(p.Concurrency))
+	}
+	if t.InstanceID == "" {
+		st, err := h.Svc.ControlSvc.BindingStatus(t.Alias)
+		return st, codeErr(CodeBadGateway, err)
+	}
+	res, err := h.Svc.MCSM.InstanceDetail(t.InstanceID, "local")
+	return res, codeErr(CodeBadGateway, err)
+}
+
+func actBulk(h *Handler, ctx *actionCtx, p *BulkParams) (any, error) {
+	sel, err := service.ParseSelector(p.Selector)
+	if err != nil {
+		return nil, codeErr(CodeBadRequest, err)
+	}
+	restrictSelector(ctx.Caller, &sel)
+	return h.bulk(ctx, sel, strings.TrimPrefix(ctx.Action, "bulk_"), int(p.Concurrency))
+}
+
+func (h *Handler) bulk(ctx *actionCtx, sel service.Selector, action string, concurrency int) (any, error) {
+	results, err := h.Svc.ControlSvc.Bulk(sel, action, concurrency, ctx.Log)
+	if err != nil {
+		return nil, err
+	}
+	return BulkResponse{Results: results}, nil
+}
+
+func actJobCreate(h *Handler, ctx *actionCtx, p *JobCreateParams) (any, error) {
+	if _, err := h.allowedBinding(ctx.Caller, p.Alias); err != nil {
+		return nil, err
+	}
+	j, err := h.Svc.SchedulerSvc.Create(&data.Job{
+		Name:   p.Name,
+		Spec:   p.Spec,
+		Kind:   p.Kind,
+		Action: p.Action,
+		Alias:  p.Alias,
+		Role:   p.Role,
+	})
+	if err != nil && !data.IsNotFound(err) {
+		err = codeErr(CodeBadRequest, err)
+	}
+	return j, err
+}
+
+func actJobList(h *Handler, ctx *actionCtx, _ *noParams) (any, error) {
+	return h.jobList(ctx.Caller)
+}
+
+// jobList returns the jobs on bindings the caller may see, together with their next fire time.
+func (h *Handler) jobList(caller *service.Principal) (any, error) {
+	jobs, err := h.Svc.SchedulerSvc.List()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]JobView, 0, len(jobs))
+	for _, j := range jobs {
+		if caller.Restricted() {
+			if _, err := h.allowedBinding(caller, j.Alias); err != nil {
+				continue
+			}
+		}
+		v := JobView{Job: j}
+		if next := h.Svc.SchedulerSvc.NextRun(j.ID); !next.IsZero() {
+			v.NextRun = &next
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func actJobUpdate(h *Handler, ctx *actionCtx, p *JobIDParams) (any, error) {
+	id := int64(p.ID)
+	job, err := h.Svc.SchedulerSvc.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Caller.Restricted() {
+		if _, err := h.allowedBinding(ctx.Caller, job.Alias); err != nil {
+			return nil, err
+		}
+	}
+	switch ctx.Action {
+	case "job_pause":
+		err = h.Svc.SchedulerSvc.Pause(id)
+	case "job_resume":
+		err = h.Svc.SchedulerSvc.Resume(id)
+	default:
+		err = h.Svc.SchedulerSvc.Delete(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+func actRelogin(h *Handler, ctx *actionCtx, p *WorkflowParams) (any, error) {
+	if _, err := h.allowedBinding(ctx.Caller, p.Alias); err != nil {
+		return nil, err
+	}
+
+	// Async workflow
+	go func() {
+		if err := h.Svc.WorkflowSvc.Relogin(p.Alias, ctx.Caller.Name, ctx.Notifier, ctx.Log); err != nil {
+			ctx.Notifier.SendEvent(service.EventError, service.ErrorEvent{Alias: p.Alias, Msg: err.Error()})
+		}
+	}()
+	return StatusResponse{Status: "started"}, nil
+}
+
+func actContinue(h *Handler, ctx *actionCtx, p *ContinueParams) (any, error) {
+	if p.Alias == "" {
+		alias, err := h.groupPendingRelogin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.Alias = alias
+	}
+	if _, err := h.allowedBinding(ctx.Caller, p.Alias); err != nil {
+		return nil, err
+	}
+	if err := h.Svc.WorkflowSvc.Continue(p.Alias); err != nil {
+		return nil, codeErr(CodeConflict, err)
+	}
+	return StatusResponse{Status: "signal_sent"}, nil
+}
+
+func actTokenCreate(h *Handler, ctx *actionCtx, p *TokenCreateParams) (any, error) {
+	// A token can never grant more than its issuer has.
+	if err := requireUnrestricted(ctx.Caller, "token_create"); err != nil {
+		return nil, err
+	}
+	for _, sc := range p.Scopes {
+		if !ctx.Caller.Can(sc) {
+			return nil, fmt.Errorf("%w: cannot grant scope %s", service.ErrForbidden, sc)
+		}
+	}
+	t, plain, err := h.Svc.TokenSvc.Issue(p.Name, p.Scopes, p.Aliases, p.Tags)
+	if err != nil {
+		return nil, codeErr(CodeBadRequest, err)
+	}
+	return TokenIssued{Token: t, Secret: plain}, nil
+}
+
+func actTokenList(h *Handler, ctx *actionCtx, _ *noParams) (any, error) {
+	if err := requireUnrestricted(ctx.Caller, "token_list"); err != nil {
+		return nil, err
+	}
+	tokens, err := h.Svc.TokenSvc.List()
+	if tokens == nil {
+		tokens = []*data.Token{}
+	}
+	return tokens, err
+}
+
+func actTokenRevoke(h *Handler, ctx *actionCtx, p *TokenIDParams) (any, error) {
+	if err := requireUnrestricted(ctx.Caller, "token_revoke"); err != nil {
+		return nil, err
+	}
+	if err := h.Svc.TokenSvc.Revoke(p.ID); err != nil {
+		return nil, err
+	}
+	h.DropInvalid()
+	return StatusResponse{Status: "ok"}, nil
+}
+
+func actACLList(h *Handler, ctx *actionCtx, _ *noParams) (any, error) {
+	if err := requireUnrestricted(ctx.Caller, "acl_list"); err != nil {
+		return nil, err
+	}
+	rules, err := h.Svc.ACLSvc.List()
+	if rules == nil {
+		rules = []*data.ACLRule{}
+	}
+	return rules, err
+}
+
+func actACLSet(h *Handler, ctx *actionCtx, p *ACLSetParams) (any, error) {
+	if err := requireUnrestricted(ctx.Caller, "acl_set"); err != nil {
+		return nil, err
+	}
+	rule := &data.ACLRule{
+		ID:       int64(p.ID),
+		Platform: p.Platform,
+		GroupID:  p.GroupID,
+		UserID:   p.UserID,
+		Aliases:  p.Aliases,
+		Actions:  p.Actions,
+		MinRole:  p.MinRole,
+	}
+	if err := h.Svc.ACLSvc.Save(rule); err != nil {
+		if !data.IsNotFound(err) {
+			err = codeErr(CodeBadRequest, err)
+		}
+		return nil, err
+	}
+	return rule, nil
+}
+
+func actACLDelete(h *Handler, ctx *actionCtx, p *ACLIDParams) (any, error) {
+	if err := requireUnrestricted(ctx.Caller, "acl_delete"); err != nil {
+		return nil, err
+	}
+	if err := h.Svc.ACLSvc.Delete(int64(p.ID)); err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+func actExport(h *Handler, ctx *actionCtx, _ *noParams) (any, error) {
+	// The document covers every binding and token.
+	if err := requireUnrestricted(ctx.Caller, "export"); err != nil {
+		return nil, err
+	}
+	return service.ExportAll(h.Svc.Repo, h.Svc.WorkflowSvc.Profiles())
+}
+
+func actImport(h *Handler, ctx *actionCtx, p *ImportParams) (any, error) {
+	if err := requireUnrestricted(ctx.Caller, "import"); err != nil {
+		return nil, err
+	}
+	return h.importExport(p.Document, service.ImportOptions{Mode: p.Mode, DryRun: p.DryRun})
+}
+
+// importExport applies doc and reschedules the jobs it changed.
+func (h *Handler) importExport(doc *service.Export, opts service.ImportOptions) (*service.ImportResult, error) {
+	opts.Profiles = h.Svc.WorkflowSvc.Profiles()
+	res, err := service.Import(h.Svc.Repo, doc, opts)
+	if err != nil {
+		return nil, codeErr(CodeBadRequest, err)
+	}
+	if !res.DryRun {
+		if err := h.Svc.SchedulerSvc.Reload(); err != nil {
+			h.Svc.Log.Error("reschedule after import", "err", err)
+		}
+		// A replace import may have revoked or dropped tokens.
+		h.DropInvalid()
+	}
+	return res, nil
+}
+
+func actGroupLink(h *Handler, ctx *actionCtx, p *GroupLinkParams) (any, error) {
+	group := p.GroupID
+	if group == "" && ctx.Chat != nil {
+		group = ctx.Chat.GroupID
+	}
+	if group == "" {
+		return nil, codeErr(CodeBadRequest, fmt.Errorf("group_id required"))
+	}
+	if _, err := h.allowedBinding(ctx.Caller, p.Alias); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if ctx.Action == "group_unlink" {
+		err = h.Svc.GroupSvc.Unlink(group, p.Alias)
+	} else {
+		err = h.Svc.GroupSvc.Link(group, p.Alias, p.Default)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+func actGroupList(h *Handler, ctx *actionCtx, p *GroupListParams) (any, error) {
+	group := p.GroupID
+	if group == "" && ctx.Chat != nil {
+		group = ctx.Chat.GroupID
+	}
+	links, err := h.Svc.GroupSvc.Links(group)
+	if err != nil {
+		return nil, err
+	}
+	out := []*data.GroupLink{}
+	for _, l := range links {
+		if ctx.Caller.Restricted() {
+			if _, err := h.allowedBinding(ctx.Caller, l.Alias); err != nil {
+				continue
+			}
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
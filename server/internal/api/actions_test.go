@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+)
+
+func newTestRepo(t *testing.T) *data.SQLiteRepo {
+	t.Helper()
+	repo, err := data.NewSQLiteRepo(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestDecodeStrict(t *testing.T) {
+	type params struct {
+		Alias string   `json:"alias"`
+		Limit int      `json:"limit"`
+		Tags  []string `json:"tags"`
+	}
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{raw: ``},
+		{raw: `null`},
+		{raw: ` {} `},
+		{raw: `{"alias":"a","limit":2,"tags":["x"]}`},
+		{raw: `{"alias":"a","extra":1}`, wantErr: true},
+		{raw: `{"Alias ":"a"}`, wantErr: true},
+		{raw: `{"alias":1}`, wantErr: true},
+		{raw: `{"limit":"2"}`, wantErr: true},
+		{raw: `{"tags":"x"}`, wantErr: true},
+		{raw: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var p params
+		if err := decodeStrict(json.RawMessage(tt.raw), &p); (err != nil) != tt.wantErr {
+			t.Errorf("decodeStrict(%s) error = %v, want error %v", tt.raw, err, tt.wantErr)
+		}
+	}
+}
+
+// firstField returns the JSON name of the first decodable field of a params struct and
+// a value of the wrong type for it, or "" when every field accepts anything.
+func firstField(typ reflect.Type) (string, string) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if name, bad := firstField(f.Type); name != "" {
+				return name, bad
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" || !f.IsExported() {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Interface, ft == reflect.TypeOf(json.RawMessage{}):
+			continue
+		case ft.Kind() == reflect.Bool:
+			return name, `"yes"`
+		default:
+			return name, `true`
+		}
+	}
+	return "", ""
+}
+
+func TestActionRegistry(t *testing.T) {
+	repo := newTestRepo(t)
+	h := &Handler{Svc: &service.Service{ACLSvc: service.NewACLService(repo, &config.Config{})}}
+	dispatch := func(action string, p *service.Principal, params string) error {
+		_, err := h.Dispatch(&actionCtx{Action: action, Caller: p}, json.RawMessage(params))
+		return err
+	}
+
+	for _, spec := range sortedActions() {
+		t.Run(spec.Name, func(t *testing.T) {
+			if spec.Doc == "" || len(spec.Response) == 0 {
+				t.Error("action has no doc or response type")
+			}
+			if !slices.Contains(service.AllScopes, string(spec.Perm)) {
+				t.Fatalf("permission %q is not a token scope", spec.Perm)
+			}
+
+			superuser := service.Superuser("test")
+			if err := dispatch(spec.Name, superuser, `{"no_such_field":1}`); errorCode(err) != CodeBadRequest {
+				t.Errorf("unknown field: %v (code %d), want %d", err, errorCode(err), CodeBadRequest)
+			}
+			if spec.Params != nil {
+				if name, bad := firstField(reflect.TypeOf(spec.Params)); name != "" {
+					raw := fmt.Sprintf(`{%q:%s}`, name, bad)
+					if err := dispatch(spec.Name, superuser, raw); errorCode(err) != CodeBadRequest {
+						t.Errorf("%s: %v (code %d), want %d", raw, err, errorCode(err), CodeBadRequest)
+					}
+				}
+			}
+
+			// Every scope but the action's is refused before params are read; the
+			// action's scope alone gets past the gate to the decoder.
+			var others []string
+			for _, sc := range service.AllScopes {
+				if sc != string(spec.Perm) {
+					others = append(others, sc)
+				}
+			}
+			lacking := &service.Principal{Name: "lacking", Scopes: others}
+			if err := dispatch(spec.Name, lacking, `{"no_such_field":1}`); errorCode(err) != CodeForbidden {
+				t.Errorf("without scope %s: %v (code %d), want %d", spec.Perm, err, errorCode(err), CodeForbidden)
+			}
+			only := &service.Principal{Name: "only", Scopes: []string{string(spec.Perm)}}
+			if err := dispatch(spec.Name, only, `{"no_such_field":1}`); errorCode(err) != CodeBadRequest {
+				t.Errorf("with scope %s only: %v (code %d), want %d", spec.Perm, err, errorCode(err), CodeBadRequest)
+			}
+		})
+	}
+
+	if err := dispatch("no_such_action", service.Superuser("test"), `{}`); errorCode(err) != CodeUnknownAction {
+		t.Errorf("unknown action: %v", err)
+	}
+}
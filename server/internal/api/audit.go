@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.yaml.in/yaml/v3"
+)
+
+// maxAuditBody caps how much of a REST body is kept in the audit log.
+const maxAuditBody = 64 << 10
+
+// auditTarget picks the binding or selector an action's params point at.
+func auditTarget(params string) string {
+	var m map[string]any
+	if json.Unmarshal([]byte(params), &m) != nil {
+		return ""
+	}
+	for _, k := range []string{"target", "alias", "selector"} {
+		if v, ok := m[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func auditCaller(e *data.AuditEntry, p *service.Principal, chat *service.ChatCaller) {
+	if p != nil {
+		e.Caller, e.TokenID = p.Name, p.TokenID
+	}
+	if chat != nil {
+		e.ChatUser, e.ChatGroup = chat.UserID, chat.GroupID
+	}
+}
+
+// auditWS records a WS action once it has been answered and returns the entry.
+func (h *Handler) auditWS(ctx *actionCtx, raw json.RawMessage, code int, err error, started time.Time) *data.AuditEntry {
+	params := ""
+	if ctx.Params != nil {
+		params = service.RedactParams(ctx.Params)
+	} else if len(raw) > 0 {
+		params = service.RedactParams(raw)
+	}
+	e := &data.AuditEntry{
+		Time:       started,
+		Source:     data.AuditWS,
+		Action:     ctx.Action,
+		Target:     auditTarget(params),
+		Params:     params,
+		Result:     "ok",
+		Code:       code,
+		DurationMS: time.Since(started).Milliseconds(),
+	}
+	auditCaller(e, ctx.Caller, ctx.Chat)
+	if err != nil {
+		e.Result, e.Error = "error", err.Error()
+	}
+	h.Svc.AuditSvc.Record(e)
+	return e
+}
+
+// auditBody returns the redacted params of a REST body: JSON, or YAML when sent
+// as such. Anything else, or a body cut off at maxAuditBody, is recorded by type
+// and size only.
+func auditBody(contentType string, body []byte, size int64) string {
+	if json.Valid(body) {
+		return service.RedactParams(json.RawMessage(body))
+	}
+	switch contentType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		var tree map[string]any
+		if yaml.Unmarshal(body, &tree) == nil && tree != nil {
+			return service.RedactParams(tree)
+		}
+	}
+	if size < 0 {
+		size = int64(len(body))
+	}
+	return service.RedactParams(map[string]any{"content_type": contentType, "body_bytes": size})
+}
+
+// AuditMiddleware records every authenticated REST call. It runs after AuthMiddleware.
+func (h *Handler) AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBody))
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		}
+
+		c.Next()
+
+		params := ""
+		if len(body) > 0 {
+			params = auditBody(c.ContentType(), body, c.Request.ContentLength)
+		}
+		target := c.Param("alias")
+		if target == "" {
+			target = auditTarget(params)
+		}
+		status := c.Writer.Status()
+		e := &data.AuditEntry{
+			Time:       started,
+			Source:     data.AuditREST,
+			Action:     c.Request.Method + " " + strings.TrimPrefix(c.FullPath(), "/api/v1"),
+			Target:     target,
+			Params:     params,
+			Result:     "ok",
+			Code:       status,
+			DurationMS: time.Since(started).Milliseconds(),
+		}
+		if p, ok := c.Get(callerKey); ok {
+			auditCaller(e, p.(*service.Principal), nil)
+		}
+		if status >= http.StatusBadRequest {
+			e.Result = "error"
+			if len(c.Errors) > 0 {
+				e.Error = c.Errors.Last().Error()
+			}
+		}
+		h.Svc.AuditSvc.Record(e)
+	}
+}
+
+func actAuditQuery(h *Handler, ctx *actionCtx, p *AuditQueryParams) (any, error) {
+	// Entries name every binding and caller, not only those the token may see.
+	if err := requireUnrestricted(ctx.Caller, "audit_query"); err != nil {
+		return nil, err
+	}
+	f := data.AuditFilter{Alias: p.Alias, User: p.User, Limit: int(p.Limit)}
+	if p.Since != nil {
+		f.Since = *p.Since
+	}
+	if p.Until != nil {
+		f.Until = *p.Until
+	}
+	entries, err := h.Svc.AuditSvc.Query(f)
+	if entries == nil {
+		entries = []*data.AuditEntry{}
+	}
+	return entries, err
+}
+
+func (h *Handler) queryAudit(c *gin.Context) {
+	p := AuditQueryParams{Alias: c.Query("alias"), User: c.Query("user")}
+	for _, q := range []struct {
+		name string
+		dst  **time.Time
+	}{{"since", &p.Since}, {"until", &p.Until}} {
+		if v := c.Query(q.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				restError(c, http.StatusBadRequest, err)
+				return
+			}
+			*q.dst = &t
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if err := json.Unmarshal([]byte(v), &p.Limit); err != nil {
+			restError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	out, err := actAuditQuery(h, &actionCtx{Caller: caller(c)}, &p)
+	if err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -1,11 +1,17 @@
 package api
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/logging"
+	"sealdice-mcsm/server/internal/metrics"
 	"sealdice-mcsm/server/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -15,35 +21,37 @@ import (
 type Handler struct {
 	Svc *service.Service
 	Cfg *config.Config
+	Hub *Hub
+	Log *slog.Logger
+
+	closing atomic.Bool // set by BeginShutdown, WS actions are refused from then on
 }
 
 func NewHandler(svc *service.Service, cfg *config.Config) *Handler {
-	return &Handler{Svc: svc, Cfg: cfg}
+	hub := NewHub()
+	hub.Groups = svc.GroupSvc.Groups
+	hub.Binding = svc.InstanceSvc.GetByAlias
+	svc.SchedulerSvc.Notifier = hub
+	return &Handler{Svc: svc, Cfg: cfg, Hub: hub, Log: logging.OrDefault(svc.Log)}
 }
 
-func (h *Handler) AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if !h.Cfg.Auth.Enable {
-			c.Next()
-			return
-		}
-		token := c.GetHeader("Authorization")
-		if token != h.Cfg.Auth.Token {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			return
-		}
-		c.Next()
+func (h *Handler) SetupRoutes(r *gin.Engine) {
+	r.Use(h.LogMiddleware())
+
+	r.GET("/public/*filepath", h.servePublic)
+	r.HEAD("/public/*filepath", h.servePublic)
+
+	// Probes for supervisors, unauthenticated; they report no configuration
+	r.GET("/healthz", h.healthz)
+	r.GET("/readyz", h.readyz)
+	if h.Cfg.Metrics.Enable {
+		r.GET("/metrics", gin.WrapH(metrics.Handler()))
 	}
-}
 
-func (h *Handler) SetupRoutes(r *gin.Engine) {
-	r.GET("/public/*filepath", func(c *gin.Context) {
-		c.File("./temp/" + c.Param("filepath"))
-	})
+	// /ws authenticates itself: clients that cannot set headers authenticate in-band.
+	r.GET("/ws", h.HandleWS)
 
-	wsGroup := r.Group("/ws")
-	wsGroup.Use(h.AuthMiddleware())
-	wsGroup.GET("", h.HandleWS)
+	h.setupREST(r)
 }
 
 var upgrader = websocket.Upgrader{
@@ -52,178 +60,171 @@ var upgrader = websocket.Upgrader{
 
 // WSNotifier adapts *websocket.Conn to Notifier interface
 type WSNotifier struct {
-	Conn  *websocket.Conn
+	Conn  *wsConn
 	ReqID string
 }
 
 func (w *WSNotifier) SendEvent(event string, data any) error {
-	return w.Conn.WriteJSON(gin.H{
-		"type":   "event",
-		"event":  event,
-		"data":   data,
-		"req_id": w.ReqID, // Inject ReqID
+	return w.Conn.WriteJSON(WSEvent{
+		Type:  "event",
+		Event: event,
+		Data:  data,
+		ReqID: w.ReqID, // Inject ReqID
 	})
 }
 
 func (h *Handler) HandleWS(c *gin.Context) {
-	// Fallback auth check for query param
-	if h.Cfg.Auth.Enable {
-		token := c.GetHeader("Authorization")
-		if token == "" {
-			token = c.Query("token")
-		}
-		if token != h.Cfg.Auth.Token {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	// Header token, or ?token= when explicitly allowed. Without either the client
+	// must authenticate with its first message (see authFirstMessage).
+	auth := h.Svc.TokenSvc.Auth()
+	token := headerToken(c.GetHeader("Authorization"))
+	if token == "" && auth.AllowQueryToken {
+		token = c.Query("token")
+	}
+	var principal *service.Principal
+	if token != "" || !auth.Enable {
+		p, err := h.authenticate(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
 			return
 		}
+		principal = p
 	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	raw, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Println("WS Upgrade Error:", err)
+		h.Log.Warn("ws upgrade failed", "err", err)
 		return
 	}
-	defer conn.Close()
+	defer raw.Close()
+
+	conn := &wsConn{Conn: raw}
+	if principal == nil {
+		if principal = h.authFirstMessage(conn); principal == nil {
+			return
+		}
+	}
+	conn.principal = principal
+	h.Hub.add(conn)
+	defer h.Hub.remove(conn)
 
 	// Handle connection
 	for {
-		var req struct {
-			Action  string            `json:"action"`
-			Command string            `json:"command"`
-			ReqID   string            `json:"req_id"`
-			Params  map[string]string `json:"params"`
-		}
+		var req WSRequest
 		if err := conn.ReadJSON(&req); err != nil {
 			break
 		}
-
-		notifier := &WSNotifier{Conn: conn, ReqID: req.ReqID}
+		started := time.Now()
 
 		action := req.Action
 		if action == "" {
 			action = req.Command
 		}
+		ctx := &actionCtx{
+			Action:   action,
+			ReqID:    req.ReqID,
+			Caller:   principal,
+			Chat:     req.Caller,
+			Notifier: &WSNotifier{Conn: conn, ReqID: req.ReqID},
+			Log:      h.actionLog(req.ReqID, action, principal, req.Caller),
+		}
 
 		var res any
 		var errOp error
-
-		switch action {
-		case "bind":
-			// Params: alias, protocol_id, core_id
-			errOp = h.Svc.InstanceSvc.Bind(req.Params["alias"], req.Params["protocol_id"], req.Params["core_id"])
-			res = map[string]string{"status": "ok"}
-		case "unbind":
-			errOp = h.Svc.InstanceSvc.Unbind(req.Params["alias"])
-			res = map[string]string{"status": "ok"}
-		case "get_binding":
-			res, errOp = h.Svc.InstanceSvc.GetByAlias(req.Params["alias"])
-
-		case "start", "stop", "restart", "fstop", "kill":
-			// Need to resolve alias to instance ID?
-			// Old logic had "role" param.
-			// New logic: If alias provided, which instance?
-			// Requirement says: "Control instances".
-			// If alias is provided, we probably need to know which one (protocol or core).
-			// Or maybe the params include "instance_id" directly?
-			// Let's support both.
-
-			target := req.Params["target"]
-			if target == "" {
-				target = req.Params["alias"]
+		revoked := false
+		if h.closing.Load() && action != "" {
+			errOp = codeErr(CodeUnavailable, service.ErrShuttingDown)
+		} else if err := h.Svc.TokenSvc.Check(principal); action != "" && err != nil {
+			// The token was revoked, or auth settings changed, after the connection authenticated.
+			errOp = err
+			if revoked = errors.Is(err, service.ErrUnauthorized); revoked {
+				errOp = codeErr(CodeUnauthorized, err)
 			}
-
-			// Simple logic: if target looks like UUID, use it.
-			// If it's an alias, we need 'role' (protocol/core).
-			role := req.Params["role"]
-			instanceID := target
-
-			if len(target) <= 20 { // Likely alias
-				binding, err := h.Svc.InstanceSvc.GetByAlias(target)
-				if err != nil {
-					errOp = err
-				} else {
-					if role == "protocol" {
-						instanceID = binding.ProtocolInstanceID
-					} else if role == "core" {
-						instanceID = binding.CoreInstanceID
-					} else {
-						// Default? or Error?
-						errOp = fmt.Errorf("role required for alias target")
-					}
-				}
-			}
-
-			if errOp == nil {
-				errOp = h.Svc.MCSM.InstanceAction(instanceID, "local", action)
-				res = map[string]string{"status": "ok"}
-			}
-
-		case "status":
-			// Similar resolution logic
-			target := req.Params["target"]
-			if target == "" {
-				res, errOp = h.Svc.MCSM.Dashboard()
-			} else {
-				instanceID := target
-				if len(target) <= 20 {
-					binding, err := h.Svc.InstanceSvc.GetByAlias(target)
-					if err != nil {
-						errOp = err
-					} else {
-						role := req.Params["role"]
-						if role == "protocol" {
-							instanceID = binding.ProtocolInstanceID
-						} else {
-							instanceID = binding.CoreInstanceID
-						}
-					}
-				}
-				if errOp == nil {
-					res, errOp = h.Svc.MCSM.InstanceDetail(instanceID, "local")
-				}
-			}
-
-		case "relogin":
-			// Async workflow
-			alias := req.Params["alias"]
-			if alias == "" {
-				alias = req.Params["target"]
-			}
-			go func() {
-				if err := h.Svc.WorkflowSvc.Relogin(alias, notifier); err != nil {
-					notifier.SendEvent("error", map[string]string{
-						"alias": alias,
-						"msg":   err.Error(),
-					})
-				}
-			}()
-			res = map[string]string{"status": "started"}
-
-		case "continue":
-			alias := req.Params["alias"]
-			if alias == "" {
-				alias = req.Params["target"]
-			}
-			errOp = h.Svc.WorkflowSvc.Continue(alias)
-			res = map[string]string{"status": "signal_sent"}
-
-		default:
-			errOp = fmt.Errorf("unknown command: %s", action)
+		} else {
+			res, errOp = h.Dispatch(ctx, req.Params)
 		}
 
-		resp := gin.H{
-			"req_id": req.ReqID,
-			"type":   "response",
-			"data":   res,
+		resp := WSResponse{
+			ReqID: req.ReqID,
+			Type:  "response",
+			Data:  res,
+			Code:  CodeOK,
 		}
 		if errOp != nil {
-			resp["type"] = "error"
-			resp["message"] = errOp.Error()
-			resp["code"] = 500
-		} else {
-			resp["code"] = 200
+			resp.Type = "error"
+			resp.Message = errOp.Error()
+			resp.Code = errorCode(errOp)
 		}
 
 		conn.WriteJSON(resp)
+
+		if action != "" { // heartbeats carry no action
+			e := h.auditWS(ctx, req.Params, resp.Code, errOp, started)
+			logAction(ctx, e)
+			h.observeAction(action, resp.Code, started)
+		}
+		if revoked {
+			break
+		}
+	}
+}
+
+// observeAction counts a WS action. Unknown actions share one label so
+// clients cannot grow the metric without bound.
+func (h *Handler) observeAction(action string, code int, started time.Time) {
+	if _, ok := actionRegistry[action]; !ok {
+		action = "unknown"
+	}
+	metrics.Actions.WithLabelValues(action, strconv.Itoa(code)).Inc()
+	metrics.ActionDuration.WithLabelValues(action).Observe(time.Since(started).Seconds())
+}
+
+// BeginShutdown refuses further WS actions and tells connected clients the server is going away.
+func (h *Handler) BeginShutdown() {
+	h.closing.Store(true)
+	h.Hub.SendEvent(service.EventShutdown, "Server is shutting down, running relogins resume after restart.")
+}
+
+// DropInvalid closes the WS connections whose principal no longer passes TokenSvc.Check,
+// so they stop receiving events too. Call it after tokens are revoked or removed and
+// after the auth settings are reloaded.
+func (h *Handler) DropInvalid() {
+	n := h.Hub.CloseIf(func(p *service.Principal) bool {
+		return errors.Is(h.Svc.TokenSvc.Check(p), service.ErrUnauthorized)
+	}, "credentials no longer valid")
+	if n > 0 {
+		h.Svc.Log.Info("closed connections with invalid credentials", "count", n)
+	}
+}
+
+// CloseConnections closes all WS connections; call it once workflows no longer need them.
+func (h *Handler) CloseConnections() {
+	h.Hub.CloseAll("server shutting down")
+}
+
+// wsAuthTimeout bounds how long an unauthenticated connection may stay open.
+const wsAuthTimeout = 10 * time.Second
+
+// authFirstMessage reads {"action":"auth","params":{"token":"..."}} from a connection opened
+// without credentials and answers it. It returns nil, after telling the client, on failure.
+func (h *Handler) authFirstMessage(conn *wsConn) *service.Principal {
+	conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var req WSRequest
+	if err := conn.ReadJSON(&req); err != nil {
+		return nil
+	}
+	var p AuthParams
+	var principal *service.Principal
+	err := fmt.Errorf("%w: first message must be the auth action", service.ErrUnauthorized)
+	if req.Action == "auth" && decodeStrict(req.Params, &p) == nil {
+		principal, err = h.authenticate(p.Token)
+	}
+	if err != nil {
+		conn.WriteJSON(WSResponse{ReqID: req.ReqID, Type: "error", Code: CodeUnauthorized, Message: err.Error()})
+		return nil
 	}
+	conn.WriteJSON(WSResponse{ReqID: req.ReqID, Type: "response", Code: CodeOK, Data: StatusResponse{Status: "ok"}})
+	return principal
 }
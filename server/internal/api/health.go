@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sealdice-mcsm/server/pkg/mcsm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyTimeout bounds each readiness check, so a hung panel cannot hang the probe.
+const readyTimeout = 5 * time.Second
+
+// CheckResult is the outcome of one readiness check. Error is a fixed description,
+// never a raw error: those can carry panel URLs or response bodies.
+type CheckResult struct {
+	Status    string `json:"status" doc:"ok or fail"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                  `json:"status" doc:"ok or fail"`
+	Checks map[string]*CheckResult `json:"checks,omitempty"`
+}
+
+// healthz reports that the process is up and serving.
+func (h *Handler) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyz checks what the bridge needs to do its job: the database, the panel, and the API key.
+// It answers 503 when any check fails, and while shutting down.
+func (h *Handler) readyz(c *gin.Context) {
+	checks := map[string]*CheckResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	run := func(fn func() map[string]*CheckResult) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := timed(fn)
+			mu.Lock()
+			for k, v := range res {
+				checks[k] = v
+			}
+			mu.Unlock()
+		}()
+	}
+
+	run(func() map[string]*CheckResult {
+		return map[string]*CheckResult{"db": result(h.Svc.Repo.Ping(), "database query failed")}
+	})
+	run(h.checkPanel)
+	wg.Wait()
+
+	if h.closing.Load() {
+		checks["shutdown"] = &CheckResult{Status: "fail", Error: "server is shutting down"}
+	}
+	resp := HealthResponse{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, r := range checks {
+		if r.Status != "ok" {
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, resp)
+}
+
+// checkPanel calls Dashboard once: any HTTP answer proves the panel reachable,
+// a successful one proves the API key.
+func (h *Handler) checkPanel() map[string]*CheckResult {
+	type dash struct {
+		res *mcsm.DashboardResponse
+		err error
+	}
+	ch := make(chan dash, 1)
+	go func() {
+		res, err := h.Svc.MCSM.Dashboard()
+		ch <- dash{res, err}
+	}()
+
+	var d dash
+	select {
+	case d = <-ch:
+	case <-time.After(readyTimeout):
+		return map[string]*CheckResult{
+			"mcsm":   {Status: "fail", Error: "panel did not answer in time"},
+			"apikey": {Status: "fail", Error: "not checked: panel unreachable"},
+		}
+	}
+
+	var httpErr *mcsm.HTTPError
+	switch {
+	case d.err == nil && d.res.Status == http.StatusOK:
+		return map[string]*CheckResult{"mcsm": result(nil, ""), "apikey": result(nil, "")}
+	case d.err == nil:
+		return map[string]*CheckResult{
+			"mcsm":   result(nil, ""),
+			"apikey": {Status: "fail", Error: fmt.Sprintf("panel refused the request (status %d)", d.res.Status)},
+		}
+	case errors.As(d.err, &httpErr) && (httpErr.Status == http.StatusUnauthorized || httpErr.Status == http.StatusForbidden):
+		return map[string]*CheckResult{
+			"mcsm":   result(nil, ""),
+			"apikey": {Status: "fail", Error: fmt.Sprintf("API key rejected (http %d)", httpErr.Status)},
+		}
+	case errors.As(d.err, &httpErr):
+		return map[string]*CheckResult{
+			"mcsm":   {Status: "fail", Error: fmt.Sprintf("panel answered http %d", httpErr.Status)},
+			"apikey": {Status: "fail", Error: "not checked: panel error"},
+		}
+	default:
+		return map[string]*CheckResult{
+			"mcsm":   {Status: "fail", Error: "panel unreachable"},
+			"apikey": {Status: "fail", Error: "not checked: panel unreachable"},
+		}
+	}
+}
+
+// timed runs fn and stamps its latency on every result it returns.
+func timed(fn func() map[string]*CheckResult) map[string]*CheckResult {
+	start := time.Now()
+	res := fn()
+	ms := time.Since(start).Milliseconds()
+	for _, r := range res {
+		r.LatencyMS = ms
+	}
+	return res
+}
+
+func result(err error, msg string) *CheckResult {
+	if err != nil {
+		return &CheckResult{Status: "fail", Error: msg}
+	}
+	return &CheckResult{Status: "ok"}
+}
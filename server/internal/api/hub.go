@@ -0,0 +1,137 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/metrics"
+	"sealdice-mcsm/server/internal/service"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn serializes writes, gorilla/websocket supports only one concurrent writer
+// and workflows push events from their own goroutines.
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+
+	principal *service.Principal // the authenticated caller, set before the hub sees the connection
+}
+
+func (c *wsConn) WriteJSON(v any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
+// Hub tracks open WS connections and broadcasts events that are not tied to a request,
+// such as scheduled job results. It implements service.Notifier.
+type Hub struct {
+	mu    sync.RWMutex
+	conns map[*wsConn]struct{}
+
+	// Groups, when set, names the chat groups linked to an alias; events about
+	// an alias carry them so the plugin can deliver them there.
+	Groups func(alias string) []string
+	// Binding looks up the binding of an alias event, so connections whose token
+	// is limited to other bindings do not receive it.
+	Binding func(alias string) (*data.Binding, error)
+}
+
+func NewHub() *Hub {
+	return &Hub{conns: make(map[*wsConn]struct{})}
+}
+
+func (h *Hub) add(c *wsConn) {
+	h.mu.Lock()
+	h.conns[c] = struct{}{}
+	h.mu.Unlock()
+	metrics.WSConnections.Inc()
+	metrics.WSConnectionsTotal.Inc()
+}
+
+func (h *Hub) remove(c *wsConn) {
+	h.mu.Lock()
+	delete(h.conns, c)
+	h.mu.Unlock()
+	metrics.WSConnections.Dec()
+}
+
+// snapshot copies the connection set, so writes to a slow client happen
+// outside the lock and do not hold up add and remove.
+func (h *Hub) snapshot() []*wsConn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]*wsConn, 0, len(h.conns))
+	for c := range h.conns {
+		out = append(out, c)
+	}
+	return out
+}
+
+func (h *Hub) SendEvent(event string, payload any) error {
+	msg := WSEvent{
+		Type:  "event",
+		Event: event,
+		Data:  payload,
+	}
+	ae, isAlias := payload.(service.AliasEvent)
+	var binding *data.Binding
+	if isAlias {
+		if h.Groups != nil {
+			msg.Groups = h.Groups(ae.EventAlias())
+		}
+		if h.Binding != nil {
+			binding, _ = h.Binding(ae.EventAlias())
+		}
+	}
+
+	var firstErr error
+	for _, c := range h.snapshot() {
+		if isAlias && !visible(c.principal, binding) {
+			continue
+		}
+		if err := c.WriteJSON(msg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// visible reports whether an event about binding b may be sent to p. Tokens limited
+// to some bindings only receive events about those; a binding that no longer
+// exists is visible to unrestricted tokens only.
+func visible(p *service.Principal, b *data.Binding) bool {
+	if p == nil {
+		return false
+	}
+	return !p.Restricted() || (b != nil && p.AllowsBinding(b))
+}
+
+// CloseIf closes, with a policy-violation frame, the connections whose principal
+// drop reports true.
+func (h *Hub) CloseIf(drop func(*service.Principal) bool, reason string) int {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	n := 0
+	for _, c := range h.snapshot() {
+		if !drop(c.principal) {
+			continue
+		}
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.Close()
+		n++
+	}
+	return n
+}
+
+// CloseAll closes every connection with a going-away frame; clients see the
+// server leave instead of a dropped socket.
+func (h *Hub) CloseAll(reason string) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+	for _, c := range h.snapshot() {
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.Close()
+	}
+}
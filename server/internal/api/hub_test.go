@@ -0,0 +1,31 @@
+package api
+
+import (
+	"testing"
+
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+)
+
+func TestVisible(t *testing.T) {
+	main := &data.Binding{Alias: "bot-main", Tags: []string{"prod"}}
+	tests := []struct {
+		name string
+		p    *service.Principal
+		b    *data.Binding
+		want bool
+	}{
+		{name: "unauthenticated", p: nil, b: main, want: false},
+		{name: "unrestricted", p: service.Superuser("config"), b: main, want: true},
+		{name: "unrestricted, binding gone", p: service.Superuser("config"), b: nil, want: true},
+		{name: "alias allowed", p: &service.Principal{Aliases: []string{"bot-*"}}, b: main, want: true},
+		{name: "alias denied", p: &service.Principal{Aliases: []string{"dev"}}, b: main, want: false},
+		{name: "tag allowed", p: &service.Principal{Tags: []string{"prod"}}, b: main, want: true},
+		{name: "restricted, binding gone", p: &service.Principal{Aliases: []string{"*"}}, b: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := visible(tt.p, tt.b); got != tt.want {
+			t.Errorf("%s: visible = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
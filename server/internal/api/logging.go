@@ -0,0 +1,109 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const logKey = "log"
+
+// reqIDRe bounds client-supplied request IDs so they cannot forge log fields.
+var reqIDRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+func newReqID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// principalAttrs names the caller of a request for log lines.
+func principalAttrs(p *service.Principal, chat *service.ChatCaller) []any {
+	var attrs []any
+	if p != nil {
+		attrs = append(attrs, "caller", p.Name)
+	}
+	if chat != nil {
+		attrs = append(attrs, "chat_user", chat.String())
+	}
+	return attrs
+}
+
+// LogMiddleware replaces gin's request logger: it tags each request with a req_id
+// (X-Request-ID if the client sent a sane one), echoes it back, and logs the outcome.
+func (h *Handler) LogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		id := c.GetHeader("X-Request-ID")
+		if !reqIDRe.MatchString(id) {
+			id = newReqID()
+		}
+		c.Header("X-Request-ID", id)
+		c.Set(logKey, h.Log.With("req_id", id))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path, // no query: it may carry link signatures
+			"status", status,
+			"duration_ms", time.Since(started).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if alias := c.Param("alias"); alias != "" {
+			attrs = append(attrs, "alias", alias)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "err", c.Errors.Last().Error())
+		}
+		lg := h.reqLog(c)
+		switch {
+		case status >= 500:
+			lg.Error("http request", attrs...)
+		case status >= 400:
+			lg.Warn("http request", attrs...)
+		default:
+			lg.Info("http request", attrs...)
+		}
+	}
+}
+
+// reqLog returns the logger of a REST request, with its req_id and, once
+// authenticated, its caller.
+func (h *Handler) reqLog(c *gin.Context) *slog.Logger {
+	lg := h.Log
+	if v, ok := c.Get(logKey); ok {
+		lg = v.(*slog.Logger)
+	}
+	if p, ok := c.Get(callerKey); ok {
+		lg = lg.With(principalAttrs(p.(*service.Principal), nil)...)
+	}
+	return lg
+}
+
+// actionLog returns the logger for one WS action.
+func (h *Handler) actionLog(reqID, action string, p *service.Principal, chat *service.ChatCaller) *slog.Logger {
+	attrs := append([]any{"req_id", reqID, "action", action}, principalAttrs(p, chat)...)
+	return h.Log.With(attrs...)
+}
+
+// logAction writes the summary line of a WS action once it has been answered.
+func logAction(ctx *actionCtx, e *data.AuditEntry) {
+	attrs := []any{"code", e.Code, "duration_ms", e.DurationMS}
+	if e.Target != "" {
+		attrs = append(attrs, "alias", e.Target)
+	}
+	if e.Error != "" {
+		ctx.Log.Warn("action failed", append(attrs, "err", e.Error)...)
+		return
+	}
+	ctx.Log.Info("action", attrs...)
+}
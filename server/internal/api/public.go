@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"sealdice-mcsm/server/internal/service"
+	"sealdice-mcsm/server/pkg/tempstore"
+
+	"github.com/gin-gonic/gin"
+)
+
+// servePublic serves a temp file to holders of a signed link (see service.URLSigner).
+// Only flat names inside the temp store resolve; anything else is a 404.
+func (h *Handler) servePublic(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("filepath"), "/")
+	if tempstore.ValidName(name) != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "not found"})
+		return
+	}
+	exp, sig := c.Query("exp"), c.Query("sig")
+	if err := h.Svc.URLSigner.Check(name, exp, sig); err != nil {
+		linkError(c, err)
+		return
+	}
+
+	f, err := h.Svc.TempStore.Open(name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, tempstore.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: "not found"})
+		return
+	}
+	defer f.Close()
+
+	// Only a full download uses up the link; HEAD and Range requests (previews,
+	// resumed downloads) do not.
+	if c.Request.Method == http.MethodGet && c.GetHeader("Range") == "" {
+		if err := h.Svc.URLSigner.Use(name, exp, sig); err != nil {
+			linkError(c, err)
+			return
+		}
+	}
+
+	// The type comes from our own extension, never from sniffing the content.
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	hdr := c.Writer.Header()
+	hdr.Set("Content-Type", ctype)
+	hdr.Set("X-Content-Type-Options", "nosniff")
+	hdr.Set("Cache-Control", "no-store")
+	hdr.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(c.Writer, c.Request, name, f.ModTime, f)
+}
+
+func linkError(c *gin.Context, err error) {
+	status := http.StatusForbidden
+	if errors.Is(err, service.ErrLinkExpired) {
+		status = http.StatusGone
+	}
+	c.JSON(status, ErrorResponse{Error: err.Error()})
+}
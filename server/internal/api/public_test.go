@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sealdice-mcsm/server/internal/service"
+	"sealdice-mcsm/server/pkg/tempstore"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServePublic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := tempstore.NewDisk(t.TempDir(), time.Minute, tempstore.Limits{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.png")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "b.png", "link.png"} {
+		if err := store.Put(name, []byte("png"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// link.png is tracked by the store but now points out of its root.
+	link := filepath.Join(store.Root(), "link.png")
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	signer := service.NewURLSigner("k", time.Minute, 2)
+	h := &Handler{Svc: &service.Service{URLSigner: signer, TempStore: store}}
+	r := gin.New()
+	r.GET("/public/*filepath", h.servePublic)
+	r.HEAD("/public/*filepath", h.servePublic)
+
+	expired := service.NewURLSigner("k", -time.Minute, 0)
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"signed", "/public/a.png?" + signer.Sign("a.png"), http.StatusOK},
+		{"no signature", "/public/a.png", http.StatusForbidden},
+		{"signature of another file", "/public/a.png?" + signer.Sign("b.png"), http.StatusForbidden},
+		{"other key", "/public/a.png?" + service.NewURLSigner("other", time.Minute, 0).Sign("a.png"), http.StatusForbidden},
+		{"expired", "/public/a.png?" + expired.Sign("a.png"), http.StatusGone},
+		{"dot dot", "/public/../a.png?" + signer.Sign("../a.png"), http.StatusNotFound},
+		{"encoded dot dot", "/public/%2e%2e%2fa.png?" + signer.Sign("../a.png"), http.StatusNotFound},
+		{"encoded slash", "/public/x%2fa.png?" + signer.Sign("x/a.png"), http.StatusNotFound},
+		{"hidden file", "/public/.put-1?" + signer.Sign(".put-1"), http.StatusNotFound},
+		{"symlink out of the root", "/public/link.png?" + signer.Sign("link.png"), http.StatusNotFound},
+		{"signed, not stored", "/public/c.png?" + signer.Sign("c.png"), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.target, w.Code, tt.want)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			if w.Body.String() != "png" || w.Header().Get("X-Content-Type-Options") != "nosniff" ||
+				w.Header().Get("Content-Type") != "image/png" {
+				t.Errorf("%s: body %q, headers %v", tt.name, w.Body, w.Header())
+			}
+		} else if w.Body.String() == "secret" {
+			t.Errorf("%s: leaked the symlink target", tt.name)
+		}
+	}
+
+	// A link works MaxUses times.
+	q := signer.Sign("b.png")
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusGone} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public/b.png?"+q, nil))
+		if w.Code != want {
+			t.Errorf("fetch %d of b.png = %d, want %d", i+1, w.Code, want)
+		}
+	}
+
+	// Previews, partial and failed fetches do not use up a link.
+	once := service.NewURLSigner("k", time.Minute, 1)
+	h.Svc.URLSigner = once
+	q = once.Sign("d.png")
+	steps := []struct {
+		name, method, rng string
+		want              int
+	}{
+		{"not stored yet", http.MethodGet, "", http.StatusNotFound},
+		{"head", http.MethodHead, "", http.StatusOK},
+		{"range", http.MethodGet, "bytes=0-1", http.StatusPartialContent},
+		{"full get", http.MethodGet, "", http.StatusOK},
+		{"used up", http.MethodGet, "", http.StatusGone},
+		{"head after use", http.MethodHead, "", http.StatusGone},
+	}
+	for _, st := range steps {
+		if st.name == "head" {
+			if err := store.Put("d.png", []byte("png"), time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req := httptest.NewRequest(st.method, "/public/d.png?"+q, nil)
+		if st.rng != "" {
+			req.Header.Set("Range", st.rng)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != st.want {
+			t.Errorf("%s: %s = %d, want %d", st.name, st.method, w.Code, st.want)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+	"sealdice-mcsm/server/pkg/mcsm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BindingRequest is the body of POST /api/v1/bindings.
+// Either Instances (in start order) or the legacy ProtocolID/CoreID pair is required.
+type BindingRequest struct {
+	Alias       string                 `json:"alias"`
+	Instances   []data.BindingInstance `json:"instances,omitempty"`
+	ProtocolID  string                 `json:"protocol_id,omitempty"`
+	CoreID      string                 `json:"core_id,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Tags        []string               `json:"tags,omitempty"`
+	Profile     string                 `json:"profile,omitempty"` // protocol profile, omit for the default one
+}
+
+// BindingPatch is the body of PATCH /api/v1/bindings/{alias}; omitted fields are left as is.
+type BindingPatch struct {
+	Description *string  `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// ActionRequest is the body of POST /api/v1/instances/{alias}/{role}/actions.
+type ActionRequest struct {
+	Action string `json:"action"`
+}
+
+// ActionResponse reports the steps run by an instance action.
+type ActionResponse struct {
+	Status string               `json:"status"` // ok, failed
+	Steps  []service.StepResult `json:"steps"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// WorkflowRequest is the body of POST /api/v1/workflows.
+type WorkflowRequest struct {
+	Name  string `json:"name"`
+	Alias string `json:"alias"`
+}
+
+// WorkflowsResponse lists the available workflows and the aliases waiting for "continue".
+type WorkflowsResponse struct {
+	Workflows []string `json:"workflows"`
+	Pending   []string `json:"pending"`
+}
+
+// ErrorResponse is the body of every non-2xx REST response.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// oneOf is a body that takes one of several shapes, depending on the path or query.
+type oneOf []any
+
+// jsonOrYAML is a body sent as JSON or as YAML with the same schema.
+type jsonOrYAML struct{ Body any }
+
+// restRoute describes one REST endpoint; the same table drives routing and the OpenAPI document.
+type restRoute struct {
+	Method   string
+	Path     string // gin syntax, relative to /api/v1
+	Summary  string
+	Query    []string // optional query parameters
+	Request  any      // body type, nil if none; oneOf or jsonOrYAML for alternatives
+	Response any      // success body type, nil if none; oneOf or jsonOrYAML for alternatives
+	Status   int      // success status
+	Perm     Permission
+	Handler  gin.HandlerFunc
+}
+
+func (h *Handler) restRoutes() []restRoute {
+	return []restRoute{
+		{http.MethodGet, "/bindings", "List bindings", []string{"tag"}, nil, []data.Binding{}, http.StatusOK, PermRead, h.listBindings},
+		{http.MethodPost, "/bindings", "Create or replace a binding", nil, BindingRequest{}, data.Binding{}, http.StatusCreated, PermBindAdmin, h.createBinding},
+		{http.MethodGet, "/bindings/:alias", "Get a binding", nil, nil, data.Binding{}, http.StatusOK, PermRead, h.getBinding},
+		{http.MethodPatch, "/bindings/:alias", "Update description and tags", nil, BindingPatch{}, data.Binding{}, http.StatusOK, PermBindAdmin, h.patchBinding},
+		{http.MethodDelete, "/bindings/:alias", "Delete a binding", nil, nil, nil, http.StatusNoContent, PermBindAdmin, h.deleteBinding},
+
+		{http.MethodGet, "/instances/:alias/:role", "Instance detail, or role -> status map for role both", nil, nil, oneOf{mcsm.InstanceDetailResponse{}, map[string]int{}}, http.StatusOK, PermRead, h.instanceStatus},
+		{http.MethodPost, "/instances/:alias/:role/actions", "Run an instance action, role both drives the whole binding", nil, ActionRequest{}, ActionResponse{}, http.StatusOK, PermControl, h.instanceAction},
+
+		{http.MethodGet, "/workflows", "List workflows and pending relogins", nil, nil, WorkflowsResponse{}, http.StatusOK, PermRead, h.listWorkflows},
+		{http.MethodPost, "/workflows", "Start a workflow; events are broadcast over WS", nil, WorkflowRequest{}, StatusResponse{}, http.StatusAccepted, PermWorkflow, h.startWorkflow},
+		{http.MethodPost, "/workflows/:alias/continue", "Confirm the QR scan of a running relogin", nil, nil, StatusResponse{}, http.StatusOK, PermWorkflow, h.continueWorkflow},
+
+		{http.MethodGet, "/audit", "Query the audit log, newest first", []string{"alias", "user", "since", "until", "limit"}, nil, []data.AuditEntry{}, http.StatusOK, PermAdmin, h.queryAudit},
+
+		{http.MethodGet, "/export", "Dump bindings, group links, schedules, tokens (hashed) and ACL rules; format json or yaml", []string{"format"}, nil, jsonOrYAML{service.Export{}}, http.StatusOK, PermAdmin, h.exportAll},
+		{http.MethodPost, "/import", "Apply an export, JSON or YAML, in one transaction; mode merge or replace", []string{"mode", "dry_run"}, jsonOrYAML{service.Export{}}, service.ImportResult{}, http.StatusOK, PermAdmin, h.importAll},
+	}
+}
+
+func (h *Handler) setupREST(r *gin.Engine) {
+	// Protocol documents are public, they hold no data.
+	r.GET("/api/v1/openapi.json", h.openAPI)
+	r.GET("/api/v1/ws-schema.json", h.wsSchema)
+
+	v1 := r.Group("/api/v1")
+	v1.Use(h.AuthMiddleware(), h.AuditMiddleware())
+	for _, rt := range h.restRoutes() {
+		v1.Handle(rt.Method, rt.Path, requireScope(rt.Perm), rt.Handler)
+	}
+}
+
+// restError writes err with a status derived from its kind.
+func restError(c *gin.Context, status int, err error) {
+	if data.IsNotFound(err) {
+		status = http.StatusNotFound
+	}
+	if errors.Is(err, service.ErrForbidden) {
+		status = http.StatusForbidden
+	}
+	c.Error(err)
+	c.JSON(status, ErrorResponse{Error: err.Error()})
+}
+
+func (h *Handler) listBindings(c *gin.Context) {
+	out, err := h.visibleBindings(caller(c), c.Query("tag"))
+	if err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handler) createBinding(c *gin.Context) {
+	var req BindingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	p := caller(c)
+	if _, err := h.allowedBinding(p, req.Alias); err != nil && !data.IsNotFound(err) {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := p.CheckBinding(&data.Binding{Alias: req.Alias, Tags: req.Tags}); err != nil {
+		restError(c, http.StatusForbidden, err)
+		return
+	}
+
+	instances := req.Instances
+	if len(instances) == 0 {
+		if req.ProtocolID == "" || req.CoreID == "" {
+			restError(c, http.StatusBadRequest, fmt.Errorf("instances or protocol_id and core_id required"))
+			return
+		}
+		instances = []data.BindingInstance{
+			{Role: service.RoleProtocol, InstanceID: req.ProtocolID},
+			{Role: service.RoleCore, InstanceID: req.CoreID},
+		}
+	}
+	if _, err := h.Svc.WorkflowSvc.Profile(req.Profile); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.Svc.InstanceSvc.Bind(req.Alias, instances, req.Description, req.Profile, req.Tags); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	b, err := h.Svc.InstanceSvc.GetByAlias(req.Alias)
+	if err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusCreated, b)
+}
+
+func (h *Handler) getBinding(c *gin.Context) {
+	b, err := h.allowedBinding(caller(c), c.Param("alias"))
+	if err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+func (h *Handler) patchBinding(c *gin.Context) {
+	var req BindingPatch
+	if err := c.ShouldBindJSON(&req); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	alias, p := c.Param("alias"), caller(c)
+	if _, err := h.allowedBinding(p, alias); err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if req.Tags != nil {
+		if err := p.CheckBinding(&data.Binding{Alias: alias, Tags: req.Tags}); err != nil {
+			restError(c, http.StatusForbidden, err)
+			return
+		}
+	}
+	b, err := h.Svc.InstanceSvc.UpdateMeta(alias, req.Description, req.Tags)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+func (h *Handler) deleteBinding(c *gin.Context) {
+	alias := c.Param("alias")
+	if _, err := h.allowedBinding(caller(c), alias); err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.Svc.InstanceSvc.Unbind(alias); err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// instanceStatus returns the MCSM detail of one role, or a role -> status map for role "both".
+func (h *Handler) instanceStatus(c *gin.Context) {
+	alias, role := c.Param("alias"), c.Param("role")
+	if _, err := h.allowedBinding(caller(c), alias); err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if role == service.RoleBoth {
+		st, err := h.Svc.ControlSvc.BindingStatus(alias)
+		if err != nil {
+			restError(c, http.StatusBadGateway, err)
+			return
+		}
+		c.JSON(http.StatusOK, st)
+		return
+	}
+
+	instanceID, err := h.Svc.InstanceSvc.ResolveInstance(alias, role)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	detail, err := h.Svc.MCSM.InstanceDetail(instanceID, "local")
+	if err != nil {
+		restError(c, http.StatusBadGateway, err)
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+func (h *Handler) instanceAction(c *gin.Context) {
+	var req ActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := h.allowedBinding(caller(c), c.Param("alias")); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	steps, err := h.Svc.ControlSvc.Act(c.Param("alias"), c.Param("role"), req.Action, h.reqLog(c))
+	if err != nil && steps == nil {
+		// Nothing ran: bad alias, role or action.
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := ActionResponse{Status: "ok", Steps: steps}
+	status := http.StatusOK
+	if err != nil {
+		resp.Status = "failed"
+		resp.Error = err.Error()
+		status = http.StatusBadGateway
+	}
+	c.JSON(status, resp)
+}
+
+func (h *Handler) listWorkflows(c *gin.Context) {
+	c.JSON(http.StatusOK, WorkflowsResponse{
+		Workflows: service.Workflows,
+		Pending:   h.Svc.WorkflowSvc.Pending(),
+	})
+}
+
+// startWorkflow runs a workflow in the background. Its events go to every connected WS client,
+// there is no request to attach them to.
+func (h *Handler) startWorkflow(c *gin.Context) {
+	var req WorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !slices.Contains(service.Workflows, req.Name) {
+		restError(c, http.StatusBadRequest, fmt.Errorf("unknown workflow: %s", req.Name))
+		return
+	}
+	if _, err := h.allowedBinding(caller(c), req.Alias); err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	lg, who := h.reqLog(c), caller(c).Name
+	go func() {
+		if err := h.Svc.WorkflowSvc.Run(req.Name, req.Alias, who, h.Hub, lg); err != nil {
+			h.Hub.SendEvent(service.EventError, service.ErrorEvent{Alias: req.Alias, Msg: err.Error()})
+		}
+	}()
+	c.JSON(http.StatusAccepted, StatusResponse{Status: "started"})
+}
+
+func (h *Handler) continueWorkflow(c *gin.Context) {
+	alias := c.Param("alias")
+	if _, err := h.allowedBinding(caller(c), alias); err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.Svc.WorkflowSvc.Continue(alias); err != nil {
+		restError(c, http.StatusConflict, err)
+		return
+	}
+	c.JSON(http.StatusOK, StatusResponse{Status: "signal_sent"})
+}
+
+func (h *Handler) exportAll(c *gin.Context) {
+	if err := requireUnrestricted(caller(c), "export"); err != nil {
+		restError(c, http.StatusForbidden, err)
+		return
+	}
+	doc, err := service.ExportAll(h.Svc.Repo, h.Svc.WorkflowSvc.Profiles())
+	if err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	out, err := service.MarshalExport(doc, format)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	contentType := "application/json"
+	if format != "json" {
+		contentType = "application/yaml"
+	}
+	c.Data(http.StatusOK, contentType, out)
+}
+
+func (h *Handler) importAll(c *gin.Context) {
+	if err := requireUnrestricted(caller(c), "import"); err != nil {
+		restError(c, http.StatusForbidden, err)
+		return
+	}
+	opts := service.ImportOptions{Mode: c.Query("mode")}
+	if v := c.Query("dry_run"); v != "" {
+		var err error
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			restError(c, http.StatusBadRequest, fmt.Errorf("dry_run: %w", err))
+			return
+		}
+	}
+	raw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	doc, err := service.ParseExport(raw)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	res, err := h.importExport(doc, opts)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sealdice-mcsm/server/internal/service"
+	"sealdice-mcsm/server/pkg/jsonschema"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+// OpenAPIDoc builds the OpenAPI 3.1 document of the REST API from the route table.
+func (h *Handler) OpenAPIDoc() map[string]any {
+	gen := jsonschema.NewGenerator("#/components/schemas/")
+	errSchema := gen.Of(ErrorResponse{})
+
+	paths := map[string]map[string]any{}
+	for _, rt := range h.restRoutes() {
+		p := "/api/v1" + ginParam.ReplaceAllString(rt.Path, "{$1}")
+
+		var params []any
+		for _, m := range ginParam.FindAllStringSubmatch(rt.Path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range rt.Query {
+			params = append(params, map[string]any{
+				"name": q, "in": "query", "required": false, "schema": map[string]any{"type": "string"},
+			})
+		}
+
+		success := map[string]any{"description": http.StatusText(rt.Status)}
+		if rt.Response != nil {
+			success["content"] = content(gen, rt.Response)
+		}
+		op := map[string]any{
+			"summary": rt.Summary,
+			"responses": map[string]any{
+				strconv.Itoa(rt.Status): success,
+				"default": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errSchema}},
+				},
+			},
+			"security": []any{map[string]any{"token": []string{}}},
+			"x-scope":  rt.Perm,
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  content(gen, rt.Request),
+			}
+		}
+
+		if paths[p] == nil {
+			paths[p] = map[string]any{}
+		}
+		paths[p][strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Sealdice-MCSM-Bridge",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": gen.Defs,
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "http", "scheme": "bearer", "description": "A bare token without the Bearer scheme is accepted too"},
+			},
+		},
+	}
+}
+
+// content is the OpenAPI content map of a request or response body.
+func content(gen *jsonschema.Generator, body any) map[string]any {
+	switch b := body.(type) {
+	case jsonOrYAML:
+		media := map[string]any{"schema": gen.Of(b.Body)}
+		return map[string]any{"application/json": media, "application/yaml": media}
+	case oneOf:
+		var alts []any
+		for _, v := range b {
+			alts = append(alts, gen.Of(v))
+		}
+		return map[string]any{"application/json": map[string]any{"schema": map[string]any{"oneOf": alts}}}
+	}
+	return map[string]any{"application/json": map[string]any{"schema": gen.Of(body)}}
+}
+
+// WSSchemaDoc builds a JSON Schema describing the WS envelope, every action's params and
+// response payloads, and every pushed event.
+func WSSchemaDoc() map[string]any {
+	gen := jsonschema.NewGenerator("#/$defs/")
+
+	actions := map[string]any{}
+	for _, a := range sortedActions() {
+		var resp []any
+		for _, r := range a.Response {
+			resp = append(resp, gen.Of(r))
+		}
+		entry := map[string]any{
+			"description": a.Doc,
+			"permission":  a.Perm,
+			"response":    map[string]any{"oneOf": resp},
+		}
+		if a.Params != nil {
+			entry["params"] = gen.Of(a.Params)
+		} else {
+			entry["params"] = map[string]any{"type": "object", "maxProperties": 0}
+		}
+		actions[a.Name] = entry
+	}
+
+	events := map[string]any{}
+	for _, e := range service.Events {
+		events[e.Name] = gen.Of(e.Data)
+	}
+
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Sealdice-MCSM-Bridge WS protocol",
+		"envelope": map[string]any{
+			"auth":     gen.Of(AuthParams{}),
+			"request":  gen.Of(WSRequest{}),
+			"response": gen.Of(WSResponse{}),
+			"event":    gen.Of(WSEvent{}),
+		},
+		"actions": actions,
+		"events":  events,
+		"$defs":   gen.Defs,
+	}
+}
+
+func (h *Handler) openAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, h.OpenAPIDoc())
+}
+
+func (h *Handler) wsSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, WSSchemaDoc())
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOpenAPIBodies(t *testing.T) {
+	// Round trip through JSON so the document reads the way clients see it.
+	raw, err := json.Marshal((&Handler{}).OpenAPIDoc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			RequestBody struct {
+				Content map[string]json.RawMessage `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]struct {
+				Content map[string]struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	status := doc.Paths["/api/v1/instances/{alias}/{role}"]["get"].Responses["200"].Content["application/json"].Schema
+	alts, _ := status["oneOf"].([]any)
+	if len(alts) != 2 {
+		t.Fatalf("instance status schema = %v, want oneOf detail and role map", status)
+	}
+	if roles := alts[1].(map[string]any); roles["type"] != "object" || roles["additionalProperties"] == nil {
+		t.Errorf("role map schema = %v", roles)
+	}
+
+	body := doc.Paths["/api/v1/import"]["post"].RequestBody.Content
+	if !reflect.DeepEqual(body["application/json"], body["application/yaml"]) || body["application/yaml"] == nil {
+		t.Errorf("import body content = %v, want the same schema for JSON and YAML", body)
+	}
+	export := doc.Paths["/api/v1/export"]["get"].Responses["200"].Content
+	if _, ok := export["application/yaml"]; !ok {
+		t.Error("export does not declare application/yaml")
+	}
+}
@@ -0,0 +1,401 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/service"
+	"sealdice-mcsm/server/pkg/jsonschema"
+)
+
+// WS envelope. Clients send WSRequest; the server answers each with a WSResponse carrying the
+// same req_id and may push any number of WSEvent messages (with req_id when tied to a request).
+
+type WSRequest struct {
+	Action  string              `json:"action" doc:"Action name, see the actions list"`
+	Command string              `json:"command,omitempty" doc:"Legacy alias of action"`
+	ReqID   string              `json:"req_id" doc:"Client chosen id echoed in the response and related events"`
+	Params  json.RawMessage     `json:"params,omitempty" doc:"Action parameters object; unknown keys are rejected"`
+	Caller  *service.ChatCaller `json:"caller,omitempty" doc:"Chat user the request is made for, checked against ACL rules"`
+}
+
+type WSResponse struct {
+	ReqID   string `json:"req_id"`
+	Type    string `json:"type" doc:"response on success, error on failure"`
+	Code    int    `json:"code" doc:"200 on success, otherwise one of the Code* error codes"`
+	Data    any    `json:"data"`
+	Message string `json:"message,omitempty" doc:"Error message when type is error"`
+}
+
+type WSEvent struct {
+	Type   string   `json:"type" doc:"Always event"`
+	Event  string   `json:"event"`
+	Data   any      `json:"data"`
+	ReqID  string   `json:"req_id,omitempty" doc:"Request that triggered the event, empty for broadcasts"`
+	Groups []string `json:"groups,omitempty" doc:"Chat groups linked to the event's alias, on broadcasts"`
+}
+
+// AuthParams are the params of the "auth" message a client opening /ws without an
+// Authorization header sends first.
+type AuthParams struct {
+	Token string `json:"token"`
+}
+
+// Param value types. Older clients send every param as a string, so these also
+// accept their string form.
+
+// StringList accepts a JSON array of strings or a comma separated string.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var raw string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("want string list or comma separated string")
+	}
+	*l = service.ParseTags(raw)
+	return nil
+}
+
+func (StringList) JSONSchema() jsonschema.Schema {
+	return jsonschema.Schema{"oneOf": []any{
+		jsonschema.Schema{"type": "array", "items": jsonschema.Schema{"type": "string"}},
+		jsonschema.Schema{"type": "string", "description": "Comma separated"},
+	}}
+}
+
+// InstanceList accepts [{"role":..,"instance_id":..}] or "role=uuid,role=uuid", in start order.
+type InstanceList []data.BindingInstance
+
+func (l *InstanceList) UnmarshalJSON(b []byte) error {
+	var list []data.BindingInstance
+	if err := json.Unmarshal(b, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var raw string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("want instance list or role=uuid string")
+	}
+	list, err := service.ParseInstances(raw)
+	if err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (InstanceList) JSONSchema() jsonschema.Schema {
+	return jsonschema.Schema{"oneOf": []any{
+		jsonschema.Schema{"type": "array", "items": jsonschema.Schema{
+			"type":                 "object",
+			"properties":           jsonschema.Schema{"role": jsonschema.Schema{"type": "string"}, "instance_id": jsonschema.Schema{"type": "string"}},
+			"required":             []string{"role", "instance_id"},
+			"additionalProperties": false,
+		}},
+		jsonschema.Schema{"type": "string", "description": "role=uuid pairs, comma separated"},
+	}}
+}
+
+// FlexInt accepts a JSON number or a numeric string.
+type FlexInt int64
+
+func (n *FlexInt) UnmarshalJSON(b []byte) error {
+	var v int64
+	if err := json.Unmarshal(b, &v); err == nil {
+		*n = FlexInt(v)
+		return nil
+	}
+	var raw string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("want integer")
+	}
+	if raw == "" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return fmt.Errorf("want integer, got %q", raw)
+	}
+	*n = FlexInt(v)
+	return nil
+}
+
+func (FlexInt) JSONSchema() jsonschema.Schema {
+	return jsonschema.Schema{"oneOf": []any{
+		jsonschema.Schema{"type": "integer"},
+		jsonschema.Schema{"type": "string", "pattern": "^-?[0-9]*$"},
+	}}
+}
+
+// Action params. Types implementing Validate are checked after decoding.
+
+type BindParams struct {
+	Alias       string       `json:"alias"`
+	Instances   InstanceList `json:"instances,omitempty" doc:"Roles in start order"`
+	ProtocolID  string       `json:"protocol_id,omitempty" doc:"Legacy pair form, with core_id"`
+	CoreID      string       `json:"core_id,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Tags        StringList   `json:"tags,omitempty"`
+	Profile     string       `json:"profile,omitempty" doc:"Protocol profile from the server config; omit for the default one"`
+}
+
+func (p *BindParams) Validate() error {
+	if p.Alias == "" {
+		return fmt.Errorf("alias required")
+	}
+	if len(p.Instances) == 0 && (p.ProtocolID == "" || p.CoreID == "") {
+		return fmt.Errorf("instances or protocol_id and core_id required")
+	}
+	return nil
+}
+
+type UpdateBindingParams struct {
+	Alias       string      `json:"alias"`
+	Description *string     `json:"description,omitempty" doc:"Omit to keep the current value"`
+	Tags        *StringList `json:"tags,omitempty" doc:"Omit to keep the current value"`
+}
+
+func (p *UpdateBindingParams) Validate() error { return requireAlias(p.Alias) }
+
+type AliasParams struct {
+	Alias string `json:"alias"`
+}
+
+func (p *AliasParams) Validate() error { return requireAlias(p.Alias) }
+
+type ListBindingsParams struct {
+	Tag string `json:"tag,omitempty"`
+}
+
+// TargetParams name what an action works on; see resolveTarget.
+type TargetParams struct {
+	Target string `json:"target,omitempty" doc:"Alias, instance UUID or selector (all, tag:x, glob, a,b)"`
+	Alias  string `json:"alias,omitempty" doc:"Used when target is empty"`
+	Role   string `json:"role,omitempty" doc:"Binding role; empty or both drives the whole binding"`
+}
+
+// MissingAlias and SetAlias let a command sent from a chat group default to the group's alias.
+func (p *TargetParams) MissingAlias() bool    { return p.Target == "" && p.Alias == "" }
+func (p *TargetParams) SetAlias(alias string) { p.Target = alias }
+
+type ControlParams struct {
+	TargetParams
+	Concurrency FlexInt `json:"concurrency,omitempty" doc:"Parallelism for selectors"`
+}
+
+func (p *ControlParams) Validate() error {
+	if p.Target == "" && p.Alias == "" {
+		return fmt.Errorf("target required")
+	}
+	return nil
+}
+
+// StatusParams is ControlParams where an empty target means the panel dashboard.
+type StatusParams struct {
+	TargetParams
+	Concurrency FlexInt `json:"concurrency,omitempty" doc:"Parallelism for selectors"`
+}
+
+type BulkParams struct {
+	Selector    string  `json:"selector" doc:"all, a,b,c, glob or tag:x"`
+	Concurrency FlexInt `json:"concurrency,omitempty"`
+}
+
+func (p *BulkParams) Validate() error {
+	if p.Selector == "" {
+		return fmt.Errorf("selector required")
+	}
+	return nil
+}
+
+type JobCreateParams struct {
+	Name   string `json:"name,omitempty"`
+	Spec   string `json:"spec" doc:"Cron expression, e.g. 0 4 * * * or @daily"`
+	Kind   string `json:"kind" doc:"instance, command or workflow"`
+	Action string `json:"action" doc:"Instance action, console command or workflow name"`
+	Alias  string `json:"alias"`
+	Role   string `json:"role,omitempty"`
+}
+
+func (p *JobCreateParams) Validate() error {
+	if p.Spec == "" || p.Kind == "" || p.Action == "" {
+		return fmt.Errorf("spec, kind and action required")
+	}
+	return requireAlias(p.Alias)
+}
+
+type JobIDParams struct {
+	ID FlexInt `json:"id"`
+}
+
+func (p *JobIDParams) Validate() error {
+	if p.ID <= 0 {
+		return fmt.Errorf("job id required")
+	}
+	return nil
+}
+
+type WorkflowParams struct {
+	Alias  string `json:"alias,omitempty"`
+	Target string `json:"target,omitempty" doc:"Used when alias is empty"`
+}
+
+func (p *WorkflowParams) Validate() error {
+	if p.Alias == "" {
+		p.Alias = p.Target
+	}
+	return requireAlias(p.Alias)
+}
+
+func (p *WorkflowParams) MissingAlias() bool    { return p.Alias == "" && p.Target == "" }
+func (p *WorkflowParams) SetAlias(alias string) { p.Alias = alias }
+
+// ContinueParams may name no alias: the relogin pending in the caller's group is meant.
+type ContinueParams struct {
+	Alias  string `json:"alias,omitempty" doc:"Omit to pick the relogin pending for the caller's group"`
+	Target string `json:"target,omitempty" doc:"Used when alias is empty"`
+}
+
+func (p *ContinueParams) Validate() error {
+	if p.Alias == "" {
+		p.Alias = p.Target
+	}
+	return nil
+}
+
+type GroupLinkParams struct {
+	GroupID string `json:"group_id,omitempty" doc:"Defaults to the caller's group"`
+	Alias   string `json:"alias"`
+	Default bool   `json:"default,omitempty" doc:"Make it the group's default alias"`
+}
+
+func (p *GroupLinkParams) Validate() error { return requireAlias(p.Alias) }
+
+type GroupListParams struct {
+	GroupID string `json:"group_id,omitempty" doc:"Defaults to the caller's group; empty lists every group"`
+}
+
+type TokenCreateParams struct {
+	Name    string     `json:"name"`
+	Scopes  StringList `json:"scopes" doc:"read, control, bind-admin, workflow, admin"`
+	Aliases StringList `json:"aliases,omitempty" doc:"Alias whitelist, globs allowed; empty with tags empty = any binding"`
+	Tags    StringList `json:"tags,omitempty" doc:"Tag whitelist"`
+}
+
+func (p *TokenCreateParams) Validate() error {
+	if p.Name == "" || len(p.Scopes) == 0 {
+		return fmt.Errorf("name and scopes required")
+	}
+	return nil
+}
+
+type TokenIDParams struct {
+	ID string `json:"id"`
+}
+
+func (p *TokenIDParams) Validate() error {
+	if p.ID == "" {
+		return fmt.Errorf("token id required")
+	}
+	return nil
+}
+
+type ACLSetParams struct {
+	ID       FlexInt    `json:"id,omitempty" doc:"Rule to replace; omit to create"`
+	Platform string     `json:"platform,omitempty"`
+	GroupID  string     `json:"group_id,omitempty"`
+	UserID   string     `json:"user_id,omitempty"`
+	Aliases  StringList `json:"aliases,omitempty" doc:"Alias globs; empty = any alias"`
+	Actions  StringList `json:"actions" doc:"Action names, scopes or *"`
+	MinRole  string     `json:"min_role,omitempty" doc:"member, admin, owner or master"`
+}
+
+func (p *ACLSetParams) Validate() error {
+	if len(p.Actions) == 0 {
+		return fmt.Errorf("actions required")
+	}
+	for _, a := range p.Actions {
+		if _, isAction := actionRegistry[a]; !isAction && a != "*" && !slices.Contains(service.AllScopes, a) {
+			return fmt.Errorf("unknown action or scope %q", a)
+		}
+	}
+	return nil
+}
+
+type ACLIDParams struct {
+	ID FlexInt `json:"id"`
+}
+
+func (p *ACLIDParams) Validate() error {
+	if p.ID <= 0 {
+		return fmt.Errorf("acl rule id required")
+	}
+	return nil
+}
+
+type AuditQueryParams struct {
+	Alias string     `json:"alias,omitempty"`
+	User  string     `json:"user,omitempty" doc:"Token name or chat user id"`
+	Since *time.Time `json:"since,omitempty" doc:"RFC 3339"`
+	Until *time.Time `json:"until,omitempty" doc:"RFC 3339, exclusive"`
+	Limit FlexInt    `json:"limit,omitempty" doc:"Default 100, at most 1000"`
+}
+
+type ImportParams struct {
+	Document *service.Export `json:"document" doc:"A document returned by export"`
+	Mode     string          `json:"mode,omitempty" doc:"merge (default) keeps what the document does not list, replace removes it"`
+	DryRun   bool            `json:"dry_run,omitempty" doc:"Validate and count the changes without making them"`
+}
+
+func (p *ImportParams) Validate() error {
+	if p.Document == nil {
+		return fmt.Errorf("document required")
+	}
+	if p.Mode != "" && p.Mode != service.ImportMerge && p.Mode != service.ImportReplace {
+		return fmt.Errorf("unknown mode %q, want merge or replace", p.Mode)
+	}
+	return nil
+}
+
+func requireAlias(alias string) error {
+	if alias == "" {
+		return fmt.Errorf("alias required")
+	}
+	return nil
+}
+
+// Response payloads.
+
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+type ControlResponse struct {
+	Status string               `json:"status" doc:"ok or failed"`
+	Steps  []service.StepResult `json:"steps"`
+}
+
+type BulkResponse struct {
+	Results []service.BulkResult `json:"results"`
+}
+
+type JobView struct {
+	*data.Job
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// TokenIssued is returned once by token_create; Secret is the token to present.
+type TokenIssued struct {
+	*data.Token
+	Secret string `json:"secret" doc:"Send as the Authorization header; not retrievable later"`
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ACLRule grants chat users access to actions on aliases. Empty Platform/GroupID/UserID match
+// any caller; a request is allowed when at least one rule matches it.
+type ACLRule struct {
+	ID        int64     `json:"id"`
+	Platform  string    `json:"platform,omitempty"`
+	GroupID   string    `json:"group_id,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Aliases   []string  `json:"aliases,omitempty"`  // globs, empty = any alias
+	Actions   []string  `json:"actions"`            // action names, scopes or "*"
+	MinRole   string    `json:"min_role,omitempty"` // member, admin, owner, master
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ACLRepo interface {
+	SaveACLRule(r *ACLRule) error
+	GetAllACLRules() ([]*ACLRule, error)
+	DeleteACLRule(id int64) error
+}
+
+func (r *SQLiteRepo) SaveACLRule(rule *ACLRule) error {
+	if len(rule.Actions) == 0 {
+		return errors.New("invalid acl rule: no actions")
+	}
+	if err := errors.Join(CheckList("alias", rule.Aliases), CheckList("action", rule.Actions)); err != nil {
+		return err
+	}
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now()
+	}
+	if rule.ID == 0 {
+		res, err := r.q.Exec(`INSERT INTO acl_rules(platform, group_id, user_id, aliases, actions, min_role, created_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?);`,
+			rule.Platform, rule.GroupID, rule.UserID, joinList(rule.Aliases), joinList(rule.Actions), rule.MinRole, rule.CreatedAt)
+		if err != nil {
+			return err
+		}
+		rule.ID, err = res.LastInsertId()
+		return err
+	}
+	res, err := r.q.Exec(`UPDATE acl_rules SET platform=?, group_id=?, user_id=?, aliases=?, actions=?, min_role=? WHERE id=?;`,
+		rule.Platform, rule.GroupID, rule.UserID, joinList(rule.Aliases), joinList(rule.Actions), rule.MinRole, rule.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Kind: "acl rule", Key: strconv.FormatInt(rule.ID, 10)}
+	}
+	return nil
+}
+
+func (r *SQLiteRepo) GetAllACLRules() ([]*ACLRule, error) {
+	rows, err := r.q.Query(`SELECT id, platform, group_id, user_id, aliases, actions, min_role, created_at
+		FROM acl_rules ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*ACLRule
+	for rows.Next() {
+		var rule ACLRule
+		var aliases, actions string
+		var created sql.NullTime
+		if err := rows.Scan(&rule.ID, &rule.Platform, &rule.GroupID, &rule.UserID, &aliases, &actions,
+			&rule.MinRole, &created); err != nil {
+			return nil, err
+		}
+		rule.Aliases, rule.Actions = splitList(aliases), splitList(actions)
+		rule.CreatedAt = created.Time
+		out = append(out, &rule)
+	}
+	return out, rows.Err()
+}
+
+func (r *SQLiteRepo) DeleteACLRule(id int64) error {
+	res, err := r.q.Exec(`DELETE FROM acl_rules WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Kind: "acl rule", Key: strconv.FormatInt(id, 10)}
+	}
+	return nil
+}
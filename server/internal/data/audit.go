@@ -0,0 +1,120 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// Audit sources.
+const (
+	AuditWS        = "ws"
+	AuditREST      = "rest"
+	AuditWorkflow  = "workflow"
+	AuditScheduler = "scheduler"
+)
+
+// AuditEntry records one action: who ran what on which target, and how it ended.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`
+	Caller     string    `json:"caller" doc:"Token name, job or workflow"`
+	TokenID    string    `json:"token_id,omitempty"`
+	ChatUser   string    `json:"chat_user,omitempty"`
+	ChatGroup  string    `json:"chat_group,omitempty"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target,omitempty"`
+	Params     string    `json:"params,omitempty" doc:"JSON with secrets redacted"`
+	Result     string    `json:"result" doc:"ok or error"`
+	Code       int       `json:"code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// AuditFilter narrows QueryAudit; zero fields match everything.
+type AuditFilter struct {
+	Alias string
+	User  string // token name or chat user
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+type AuditRepo interface {
+	AddAudit(e *AuditEntry) error
+	QueryAudit(f AuditFilter) ([]*AuditEntry, error)
+	PruneAudit(before time.Time) (int64, error)
+}
+
+func (r *SQLiteRepo) AddAudit(e *AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	res, err := r.q.Exec(`INSERT INTO audit_log(time, source, caller, token_id, chat_user, chat_group,
+		action, target, params, result, code, error, duration_ms)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		e.Time, e.Source, e.Caller, e.TokenID, e.ChatUser, e.ChatGroup,
+		e.Action, e.Target, e.Params, e.Result, e.Code, e.Error, e.DurationMS)
+	if err != nil {
+		return err
+	}
+	e.ID, err = res.LastInsertId()
+	return err
+}
+
+// QueryAudit returns matching entries, newest first.
+func (r *SQLiteRepo) QueryAudit(f AuditFilter) ([]*AuditEntry, error) {
+	var where []string
+	var args []any
+	if f.Alias != "" {
+		where = append(where, "target=?")
+		args = append(args, f.Alias)
+	}
+	if f.User != "" {
+		where = append(where, "(caller=? OR chat_user=?)")
+		args = append(args, f.User, f.User)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "time>=?")
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "time<?")
+		args = append(args, f.Until)
+	}
+	q := `SELECT id, time, source, caller, token_id, chat_user, chat_group, action, target, params,
+		result, code, error, duration_ms FROM audit_log`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY time DESC, id DESC"
+	if f.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := r.q.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Time, &e.Source, &e.Caller, &e.TokenID, &e.ChatUser, &e.ChatGroup,
+			&e.Action, &e.Target, &e.Params, &e.Result, &e.Code, &e.Error, &e.DurationMS); err != nil {
+			return nil, err
+		}
+		out = append(out, &e)
+	}
+	return out, rows.Err()
+}
+
+// PruneAudit deletes entries older than before and returns how many went.
+func (r *SQLiteRepo) PruneAudit(before time.Time) (int64, error) {
+	res, err := r.q.Exec(`DELETE FROM audit_log WHERE time<?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GroupLink associates a chat group with a binding. A group may own several bindings;
+// the default one is used when a command in the group names no alias.
+type GroupLink struct {
+	GroupID   string    `json:"group_id"`
+	Alias     string    `json:"alias"`
+	Default   bool      `json:"default"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type GroupRepo interface {
+	LinkGroup(l *GroupLink) error
+	UnlinkGroup(groupID, alias string) error
+	GetGroupLinks(groupID string) ([]*GroupLink, error)
+	GetAliasGroups(alias string) ([]*GroupLink, error)
+	GetAllGroupLinks() ([]*GroupLink, error)
+}
+
+// LinkGroup adds or updates a link. The first link of a group becomes its default,
+// and setting Default moves the default away from the group's other links.
+func (r *SQLiteRepo) LinkGroup(l *GroupLink) error {
+	if l.GroupID == "" || l.Alias == "" {
+		return errors.New("invalid group link data")
+	}
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = time.Now()
+	}
+
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM bindings WHERE alias=?)`, l.Alias).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return &NotFoundError{Kind: "binding", Key: l.Alias}
+	}
+
+	var others int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM group_bindings WHERE group_id=? AND alias<>?`,
+		l.GroupID, l.Alias).Scan(&others); err != nil {
+		return err
+	}
+	if others == 0 {
+		l.Default = true
+	}
+	if l.Default {
+		if _, err := tx.Exec(`UPDATE group_bindings SET is_default=0 WHERE group_id=?`, l.GroupID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO group_bindings(group_id, alias, is_default, created_at)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(group_id, alias) DO UPDATE SET is_default=excluded.is_default OR group_bindings.is_default;`,
+		l.GroupID, l.Alias, l.Default, l.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnlinkGroup removes a link; if it was the default, the group's oldest remaining link takes over.
+func (r *SQLiteRepo) UnlinkGroup(groupID, alias string) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM group_bindings WHERE group_id=? AND alias=?`, groupID, alias)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Kind: "group link", Key: groupID + "/" + alias}
+	}
+	if _, err := tx.Exec(`UPDATE group_bindings SET is_default=1
+		WHERE group_id=?1 AND NOT EXISTS(SELECT 1 FROM group_bindings WHERE group_id=?1 AND is_default)
+		AND alias=(SELECT alias FROM group_bindings WHERE group_id=?1 ORDER BY created_at, alias LIMIT 1)`,
+		groupID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const groupLinkQuery = `SELECT group_id, alias, is_default, created_at FROM group_bindings`
+
+func (r *SQLiteRepo) queryGroupLinks(where string, args ...any) ([]*GroupLink, error) {
+	rows, err := r.q.Query(groupLinkQuery+" "+where+" ORDER BY group_id, is_default DESC, created_at, alias;", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*GroupLink
+	for rows.Next() {
+		var l GroupLink
+		var created sql.NullTime
+		if err := rows.Scan(&l.GroupID, &l.Alias, &l.Default, &created); err != nil {
+			return nil, err
+		}
+		l.CreatedAt = created.Time
+		out = append(out, &l)
+	}
+	return out, rows.Err()
+}
+
+// GetGroupLinks returns a group's links, default first.
+func (r *SQLiteRepo) GetGroupLinks(groupID string) ([]*GroupLink, error) {
+	return r.queryGroupLinks(`WHERE group_id=?`, groupID)
+}
+
+func (r *SQLiteRepo) GetAliasGroups(alias string) ([]*GroupLink, error) {
+	return r.queryGroupLinks(`WHERE alias=?`, alias)
+}
+
+func (r *SQLiteRepo) GetAllGroupLinks() ([]*GroupLink, error) {
+	return r.queryGroupLinks(``)
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Job kinds.
+const (
+	JobInstanceAction = "instance" // Action is start/stop/restart/kill, Role empty = whole binding
+	JobCommand        = "command"  // Action is a console command sent to Role's instance
+	JobWorkflow       = "workflow" // Action is a workflow name, e.g. relogin
+)
+
+// Job is a scheduled task: a cron spec plus what to run against which alias.
+type Job struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Spec      string     `json:"spec"`
+	Kind      string     `json:"kind"`
+	Action    string     `json:"action"`
+	Alias     string     `json:"alias"`
+	Role      string     `json:"role,omitempty"`
+	Paused    bool       `json:"paused"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+type JobRepo interface {
+	SaveJob(j *Job) error
+	GetJob(id int64) (*Job, error)
+	GetAllJobs() ([]*Job, error)
+	DeleteJob(id int64) error
+	SetJobPaused(id int64, paused bool) error
+	RecordJobRun(id int64, at time.Time, runErr error) error
+}
+
+func (r *SQLiteRepo) SaveJob(j *Job) error {
+	if j.Spec == "" || j.Kind == "" || j.Action == "" || j.Alias == "" {
+		return errors.New("invalid job data")
+	}
+	if j.CreatedAt.IsZero() {
+		j.CreatedAt = time.Now()
+	}
+	if j.ID == 0 {
+		res, err := r.q.Exec(`INSERT INTO jobs(name, spec, kind, action, alias, role, paused, created_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?);`,
+			j.Name, j.Spec, j.Kind, j.Action, j.Alias, j.Role, j.Paused, j.CreatedAt)
+		if err != nil {
+			return err
+		}
+		j.ID, err = res.LastInsertId()
+		return err
+	}
+	_, err := r.q.Exec(`UPDATE jobs SET name=?, spec=?, kind=?, action=?, alias=?, role=?, paused=? WHERE id=?;`,
+		j.Name, j.Spec, j.Kind, j.Action, j.Alias, j.Role, j.Paused, j.ID)
+	return err
+}
+
+const jobColumns = `id, name, spec, kind, action, alias, role, paused, created_at, last_run_at, last_error`
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var j Job
+	var lastRun sql.NullTime
+	if err := row.Scan(&j.ID, &j.Name, &j.Spec, &j.Kind, &j.Action, &j.Alias, &j.Role, &j.Paused,
+		&j.CreatedAt, &lastRun, &j.LastError); err != nil {
+		return nil, err
+	}
+	if lastRun.Valid {
+		j.LastRunAt = &lastRun.Time
+	}
+	return &j, nil
+}
+
+func (r *SQLiteRepo) GetJob(id int64) (*Job, error) {
+	j, err := scanJob(r.q.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id=?;`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &NotFoundError{Kind: "job", Key: strconv.FormatInt(id, 10)}
+	}
+	return j, err
+}
+
+func (r *SQLiteRepo) GetAllJobs() ([]*Job, error) {
+	rows, err := r.q.Query(`SELECT ` + jobColumns + ` FROM jobs ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+func (r *SQLiteRepo) DeleteJob(id int64) error {
+	res, err := r.q.Exec(`DELETE FROM jobs WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Kind: "job", Key: strconv.FormatInt(id, 10)}
+	}
+	return nil
+}
+
+func (r *SQLiteRepo) SetJobPaused(id int64, paused bool) error {
+	res, err := r.q.Exec(`UPDATE jobs SET paused=? WHERE id=?`, paused, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Kind: "job", Key: strconv.FormatInt(id, 10)}
+	}
+	return nil
+}
+
+func (r *SQLiteRepo) RecordJobRun(id int64, at time.Time, runErr error) error {
+	msg := ""
+	if runErr != nil {
+		msg = runErr.Error()
+	}
+	_, err := r.q.Exec(`UPDATE jobs SET last_run_at=?, last_error=? WHERE id=?`, at, msg, id)
+	return err
+}
@@ -4,16 +4,45 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// BindingInstance is one role -> instance entry of a binding.
+// Instances are kept in start order; stop runs in reverse.
+type BindingInstance struct {
+	Role       string `json:"role"`
+	InstanceID string `json:"instance_id"`
+}
+
 type Binding struct {
-	Alias              string
-	ProtocolInstanceID string
-	CoreInstanceID     string
-	CreatedAt          time.Time
+	Alias       string            `json:"alias"`
+	Instances   []BindingInstance `json:"instances"`
+	Description string            `json:"description"`
+	Tags        []string          `json:"tags"`
+	Profile     string            `json:"profile,omitempty"` // protocol profile of the relogin workflow, empty for "default"
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// Instance returns the instance registered under role.
+func (b *Binding) Instance(role string) (BindingInstance, bool) {
+	for _, inst := range b.Instances {
+		if inst.Role == role {
+			return inst, true
+		}
+	}
+	return BindingInstance{}, false
+}
+
+func (b *Binding) Roles() []string {
+	roles := make([]string, 0, len(b.Instances))
+	for _, inst := range b.Instances {
+		roles = append(roles, inst.Role)
+	}
+	return roles
 }
 
 type BindingRepo interface {
@@ -21,19 +50,62 @@ type BindingRepo interface {
 	GetBinding(alias string) (*Binding, error)
 	DeleteBinding(alias string) error
 	GetAllBindings() ([]*Binding, error)
+	GetBindingsByTag(tag string) ([]*Binding, error)
 	Close() error
 }
 
+// NotFoundError is returned when a lookup by key finds nothing.
+type NotFoundError struct {
+	Kind string
+	Key  string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found: %s", e.Kind, e.Key)
+}
+
+// IsNotFound reports whether err (or anything it wraps) is a NotFoundError.
+func IsNotFound(err error) bool {
+	var nf *NotFoundError
+	return errors.As(err, &nf)
+}
+
+// Repo is everything the service layer stores.
+type Repo interface {
+	BindingRepo
+	JobRepo
+	TokenRepo
+	ACLRepo
+	GroupRepo
+	AuditRepo
+	WorkflowRepo
+	Ping() error
+	// Transaction runs fn against a repo whose writes all commit together when fn
+	// returns nil, or not at all.
+	Transaction(fn func(Repo) error) error
+}
+
+// querier runs statements: the database, or the transaction a repo is bound to.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 type SQLiteRepo struct {
 	db *sql.DB
+	q  querier
+	tx *sql.Tx // set on the repo Transaction hands out
 }
 
 func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
-	db, err := sql.Open("sqlite", path)
+	// Pragmas in the DSN apply to every connection the pool opens. busy_timeout:
+	// CLI commands may write while the server runs; wait for its lock instead of failing.
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
-	r := &SQLiteRepo{db: db}
+	r := &SQLiteRepo{db: db, q: db}
 	if err := r.init(); err != nil {
 		db.Close()
 		return nil, err
@@ -41,65 +113,251 @@ func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
 	return r, nil
 }
 
-func (r *SQLiteRepo) init() error {
-	// Drop old table if exists (optional, but good for schema change in dev)
-	// For now, I'll just create the new one if not exists.
-	// If the old one exists with different schema, this might fail or ignore.
-	// Assuming dev environment, let's DROP if it has the old schema?
-	// The old schema had (alias, role) PK. The new one has (alias) PK.
-	// Let's force recreation for this task to ensure schema match.
-	
-	// Check if table has 'role' column (old schema)
-	// Or just try to create new table.
-	
-	// Simply: CREATE TABLE IF NOT EXISTS bindings ...
-	// But columns are different. 
-	// I'll drop the table to be safe since this is a refactor task.
-	_, _ = r.db.Exec(`DROP TABLE IF EXISTS bindings`)
-
-	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS bindings(
+// migrations are applied in order; PRAGMA user_version records how many have run.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS bindings(
 		alias TEXT PRIMARY KEY,
 		protocol_instance_id TEXT NOT NULL,
 		core_instance_id TEXT NOT NULL,
 		created_at DATETIME
-	);`)
-	return err
+	);`,
+	`ALTER TABLE bindings ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	CREATE TABLE IF NOT EXISTS binding_tags(
+		alias TEXT NOT NULL REFERENCES bindings(alias) ON DELETE CASCADE,
+		tag TEXT NOT NULL,
+		PRIMARY KEY(alias, tag)
+	);
+	CREATE INDEX IF NOT EXISTS idx_binding_tags_tag ON binding_tags(tag);`,
+	`CREATE TABLE IF NOT EXISTS binding_instances(
+		alias TEXT NOT NULL REFERENCES bindings(alias) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		instance_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY(alias, role)
+	);
+	INSERT INTO binding_instances(alias, role, instance_id, position)
+		SELECT alias, 'protocol', protocol_instance_id, 0 FROM bindings;
+	INSERT INTO binding_instances(alias, role, instance_id, position)
+		SELECT alias, 'core', core_instance_id, 1 FROM bindings;
+	ALTER TABLE bindings DROP COLUMN protocol_instance_id;
+	ALTER TABLE bindings DROP COLUMN core_instance_id;`,
+	`CREATE TABLE IF NOT EXISTS jobs(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL DEFAULT '',
+		spec TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		action TEXT NOT NULL,
+		alias TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT '',
+		paused BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME,
+		last_run_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT ''
+	);`,
+	`CREATE TABLE IF NOT EXISTS tokens(
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		secret_hash TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		aliases TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL DEFAULT '',
+		created_at DATETIME,
+		revoked_at DATETIME
+	);`,
+	`CREATE TABLE IF NOT EXISTS acl_rules(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		platform TEXT NOT NULL DEFAULT '',
+		group_id TEXT NOT NULL DEFAULT '',
+		user_id TEXT NOT NULL DEFAULT '',
+		aliases TEXT NOT NULL DEFAULT '',
+		actions TEXT NOT NULL,
+		min_role TEXT NOT NULL DEFAULT '',
+		created_at DATETIME
+	);`,
+	`CREATE TABLE IF NOT EXISTS group_bindings(
+		group_id TEXT NOT NULL,
+		alias TEXT NOT NULL REFERENCES bindings(alias) ON DELETE CASCADE,
+		is_default BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME,
+		PRIMARY KEY(group_id, alias)
+	);
+	CREATE INDEX IF NOT EXISTS idx_group_bindings_alias ON group_bindings(alias);`,
+	`CREATE TABLE IF NOT EXISTS audit_log(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time DATETIME NOT NULL,
+		source TEXT NOT NULL,
+		caller TEXT NOT NULL DEFAULT '',
+		token_id TEXT NOT NULL DEFAULT '',
+		chat_user TEXT NOT NULL DEFAULT '',
+		chat_group TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		params TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL,
+		code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log(time);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target);`,
+	`CREATE TABLE IF NOT EXISTS workflow_runs(
+		alias TEXT PRIMARY KEY REFERENCES bindings(alias) ON DELETE CASCADE,
+		workflow TEXT NOT NULL,
+		step TEXT NOT NULL,
+		started_at DATETIME,
+		saved_at DATETIME
+	);`,
+	// Jobs of bindings deleted before DeleteBinding removed them too.
+	`DELETE FROM jobs WHERE alias NOT IN (SELECT alias FROM bindings);`,
+	`ALTER TABLE bindings ADD COLUMN profile TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE workflow_runs ADD COLUMN caller TEXT NOT NULL DEFAULT '';`,
+}
+
+// SchemaVersion reports the migration level of the database at path and the level
+// this build migrates to, without creating or migrating anything.
+func SchemaVersion(path string) (current, latest int, err error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, len(migrations), err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, len(migrations), err
+	}
+	defer db.Close()
+	err = db.QueryRow(`PRAGMA user_version`).Scan(&current)
+	return current, len(migrations), err
+}
+
+func (r *SQLiteRepo) init() error {
+	// SQLite has a single writer anyway; one connection also serializes Transaction.
+	r.db.SetMaxOpenConns(1)
+
+	var version int
+	if err := r.q.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := r.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *SQLiteRepo) SaveBinding(b *Binding) error {
-	if b.Alias == "" || b.ProtocolInstanceID == "" || b.CoreInstanceID == "" {
+	if b.Alias == "" || len(b.Instances) == 0 {
 		return errors.New("invalid binding data")
 	}
+	seen := make(map[string]bool, len(b.Instances))
+	for _, inst := range b.Instances {
+		if inst.Role == "" || inst.InstanceID == "" {
+			return errors.New("invalid binding data: empty role or instance id")
+		}
+		if seen[inst.Role] {
+			return fmt.Errorf("invalid binding data: duplicate role %s", inst.Role)
+		}
+		seen[inst.Role] = true
+	}
 	if b.CreatedAt.IsZero() {
 		b.CreatedAt = time.Now()
 	}
-	_, err := r.db.Exec(`INSERT INTO bindings(alias, protocol_instance_id, core_instance_id, created_at) 
+
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Replacing a binding keeps the time it was first created.
+	err = tx.QueryRow(`INSERT INTO bindings(alias, description, profile, created_at)
 		VALUES(?, ?, ?, ?)
-		ON CONFLICT(alias) DO UPDATE SET 
-			protocol_instance_id=excluded.protocol_instance_id,
-			core_instance_id=excluded.core_instance_id,
-			created_at=excluded.created_at;`,
-		b.Alias, b.ProtocolInstanceID, b.CoreInstanceID, b.CreatedAt)
-	return err
+		ON CONFLICT(alias) DO UPDATE SET
+			description=excluded.description,
+			profile=excluded.profile
+		RETURNING created_at;`,
+		b.Alias, b.Description, b.Profile, b.CreatedAt).Scan(&b.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM binding_instances WHERE alias=?`, b.Alias); err != nil {
+		return err
+	}
+	for i, inst := range b.Instances {
+		if _, err := tx.Exec(`INSERT INTO binding_instances(alias, role, instance_id, position) VALUES(?, ?, ?, ?)`,
+			b.Alias, inst.Role, inst.InstanceID, i); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM binding_tags WHERE alias=?`, b.Alias); err != nil {
+		return err
+	}
+	for _, tag := range b.Tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO binding_tags(alias, tag) VALUES(?, ?)`, b.Alias, tag); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
+const bindingColumns = `alias, description, profile, created_at`
+
 func (r *SQLiteRepo) GetBinding(alias string) (*Binding, error) {
 	var b Binding
-	err := r.db.QueryRow(`SELECT alias, protocol_instance_id, core_instance_id, created_at FROM bindings WHERE alias=?;`, alias).
-		Scan(&b.Alias, &b.ProtocolInstanceID, &b.CoreInstanceID, &b.CreatedAt)
+	err := r.q.QueryRow(`SELECT `+bindingColumns+` FROM bindings WHERE alias=?;`, alias).
+		Scan(&b.Alias, &b.Description, &b.Profile, &b.CreatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("binding not found: %s", alias)
+		return nil, &NotFoundError{Kind: "binding", Key: alias}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.loadDetails([]*Binding{&b}); err != nil {
+		return nil, err
 	}
-	return &b, err
+	return &b, nil
 }
 
+// DeleteBinding deletes a binding with its jobs; jobs have no foreign key to cascade from.
 func (r *SQLiteRepo) DeleteBinding(alias string) error {
-	_, err := r.db.Exec(`DELETE FROM bindings WHERE alias=?`, alias)
-	return err
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM jobs WHERE alias=?`, alias); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM bindings WHERE alias=?`, alias); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *SQLiteRepo) GetAllBindings() ([]*Binding, error) {
-	rows, err := r.db.Query(`SELECT alias, protocol_instance_id, core_instance_id, created_at FROM bindings;`)
+	return r.queryBindings(`SELECT ` + bindingColumns + ` FROM bindings;`)
+}
+
+func (r *SQLiteRepo) GetBindingsByTag(tag string) ([]*Binding, error) {
+	return r.queryBindings(`SELECT `+bindingColumns+` FROM bindings
+		WHERE alias IN (SELECT alias FROM binding_tags WHERE tag=?);`, tag)
+}
+
+func (r *SQLiteRepo) queryBindings(query string, args ...any) ([]*Binding, error) {
+	rows, err := r.q.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -107,12 +365,119 @@ func (r *SQLiteRepo) GetAllBindings() ([]*Binding, error) {
 	var out []*Binding
 	for rows.Next() {
 		var b Binding
-		if err := rows.Scan(&b.Alias, &b.ProtocolInstanceID, &b.CoreInstanceID, &b.CreatedAt); err != nil {
+		if err := rows.Scan(&b.Alias, &b.Description, &b.Profile, &b.CreatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &b)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := r.loadDetails(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// loadDetails fills in the instances and tags of the given bindings.
+func (r *SQLiteRepo) loadDetails(bindings []*Binding) error {
+	if len(bindings) == 0 {
+		return nil
+	}
+	byAlias := make(map[string]*Binding, len(bindings))
+	args := make([]any, 0, len(bindings))
+	for _, b := range bindings {
+		byAlias[b.Alias] = b
+		args = append(args, b.Alias)
+	}
+	in := `alias IN (?` + strings.Repeat(`, ?`, len(args)-1) + `)`
+
+	rows, err := r.q.Query(`SELECT alias, role, instance_id FROM binding_instances WHERE `+in+` ORDER BY position;`, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var alias string
+		var inst BindingInstance
+		if err := rows.Scan(&alias, &inst.Role, &inst.InstanceID); err != nil {
+			rows.Close()
+			return err
+		}
+		if b, ok := byAlias[alias]; ok {
+			b.Instances = append(b.Instances, inst)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = r.q.Query(`SELECT alias, tag FROM binding_tags WHERE `+in+` ORDER BY tag;`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias, tag string
+		if err := rows.Scan(&alias, &tag); err != nil {
+			return err
+		}
+		if b, ok := byAlias[alias]; ok {
+			b.Tags = append(b.Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+// Ping runs a trivial query, proving the database is open and answering.
+func (r *SQLiteRepo) Ping() error {
+	var one int
+	return r.q.QueryRow(`SELECT 1`).Scan(&one)
+}
+
+// Transaction runs fn in one transaction. There is a single connection, so fn
+// must use the repo it is given: r itself would wait for the transaction forever.
+func (r *SQLiteRepo) Transaction(fn func(Repo) error) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(&SQLiteRepo{db: r.db, q: tx.Tx, tx: tx.Tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// txn is a transaction of a method that runs several statements. Inside
+// Transaction it joins the enclosing transaction, which then commits or rolls back.
+type txn struct {
+	*sql.Tx
+	joined bool
+}
+
+func (r *SQLiteRepo) begin() (txn, error) {
+	if r.tx != nil {
+		return txn{Tx: r.tx, joined: true}, nil
+	}
+	tx, err := r.db.Begin()
+	return txn{Tx: tx}, err
+}
+
+func (t txn) Commit() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t txn) Rollback() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Rollback()
 }
 
 func (r *SQLiteRepo) Close() error {
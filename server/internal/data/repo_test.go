@@ -0,0 +1,181 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openAt creates a database at path migrated to version only, as an older build left it.
+func openAt(t *testing.T, path string, version int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < version; i++ {
+		if _, err := db.Exec(migrations[i]); err != nil {
+			t.Fatalf("migration %d: %v", i+1, err)
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrateUp(t *testing.T) {
+	tests := []struct {
+		name string
+		from int
+		seed string // run at version from
+		want []*Binding
+		jobs int // left after migrating
+	}{
+		{name: "empty", from: 0},
+		{
+			name: "pair bindings",
+			from: 1,
+			seed: `INSERT INTO bindings(alias, protocol_instance_id, core_instance_id, created_at)
+				VALUES('a', 'p1', 'c1', datetime('now'));`,
+			want: []*Binding{{Alias: "a", Instances: []BindingInstance{
+				{Role: "protocol", InstanceID: "p1"}, {Role: "core", InstanceID: "c1"}}}},
+		},
+		{
+			name: "orphaned jobs",
+			from: 9,
+			seed: `INSERT INTO bindings(alias, created_at) VALUES('a', datetime('now'));
+				INSERT INTO binding_instances(alias, role, instance_id, position) VALUES('a', 'core', 'c1', 0);
+				INSERT INTO jobs(spec, kind, action, alias, created_at) VALUES
+					('@daily', 'instance', 'restart', 'a', datetime('now')),
+					('@daily', 'instance', 'restart', 'gone', datetime('now'));`,
+			jobs: 1,
+			want: []*Binding{{Alias: "a", Instances: []BindingInstance{{Role: "core", InstanceID: "c1"}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data.db")
+			db := openAt(t, path, tt.from)
+			if tt.seed != "" {
+				if _, err := db.Exec(tt.seed); err != nil {
+					t.Fatal(err)
+				}
+			}
+			db.Close()
+
+			cur, latest, err := SchemaVersion(path)
+			if err != nil || cur != tt.from || latest != len(migrations) {
+				t.Fatalf("SchemaVersion before = %d, %d, %v; want %d, %d", cur, latest, err, tt.from, len(migrations))
+			}
+			repo, err := NewSQLiteRepo(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repo.Close()
+			if cur, _, _ := SchemaVersion(path); cur != len(migrations) {
+				t.Fatalf("version after migrating = %d, want %d", cur, len(migrations))
+			}
+
+			got, err := repo.GetAllBindings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range got {
+				b.CreatedAt = time.Time{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bindings = %+v, want %+v", got, tt.want)
+			}
+			jobs, err := repo.GetAllJobs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != tt.jobs {
+				t.Errorf("%d jobs left, want %d", len(jobs), tt.jobs)
+			}
+			for _, j := range jobs {
+				if _, err := repo.GetBinding(j.Alias); err != nil {
+					t.Errorf("job %d kept for %s: %v", j.ID, j.Alias, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	for i := 0; i < 2; i++ {
+		repo, err := NewSQLiteRepo(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i+1, err)
+		}
+		repo.Close()
+	}
+}
+
+func TestSaveBindingKeepsCreatedAt(t *testing.T) {
+	repo, err := NewSQLiteRepo(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first := &Binding{Alias: "a", CreatedAt: created, Instances: []BindingInstance{{Role: "core", InstanceID: "c1"}}}
+	if err := repo.SaveBinding(first); err != nil {
+		t.Fatal(err)
+	}
+	replaced := &Binding{Alias: "a", Description: "new", Instances: []BindingInstance{{Role: "core", InstanceID: "c2"}}}
+	if err := repo.SaveBinding(replaced); err != nil {
+		t.Fatal(err)
+	}
+	if !replaced.CreatedAt.Equal(created) {
+		t.Errorf("SaveBinding set created_at = %v, want %v", replaced.CreatedAt, created)
+	}
+	got, err := repo.GetBinding("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(created) || got.Description != "new" || got.Instances[0].InstanceID != "c2" {
+		t.Errorf("GetBinding = %+v", got)
+	}
+}
+
+func TestDeleteBindingCascades(t *testing.T) {
+	repo, err := NewSQLiteRepo(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	b := &Binding{Alias: "a", Tags: []string{"x"}, Instances: []BindingInstance{{Role: "core", InstanceID: "c1"}}}
+	if err := repo.SaveBinding(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveJob(&Job{Spec: "@daily", Kind: JobInstanceAction, Action: "restart", Alias: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.LinkGroup(&GroupLink{GroupID: "g1", Alias: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveWorkflowRun(&WorkflowRun{Alias: "a", Workflow: "relogin", Step: "qrcode"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteBinding("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"binding_tags", "binding_instances", "jobs", "group_bindings", "workflow_runs"} {
+		var n int
+		if err := repo.q.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%s: %d rows left", table, n)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Token is an API token. Only a salted hash of its secret is stored; the plaintext is shown once at issue time.
+type Token struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Aliases    []string   `json:"aliases,omitempty"` // alias whitelist (globs allowed), empty = any
+	Tags       []string   `json:"tags,omitempty"`    // tag whitelist, empty = any
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type TokenRepo interface {
+	SaveToken(t *Token) error
+	GetToken(id string) (*Token, error)
+	GetAllTokens() ([]*Token, error)
+	RevokeToken(id string, at time.Time) error
+}
+
+// Lists are stored comma separated; CheckList keeps entries that would not
+// split back the same way out of the database.
+func joinList(l []string) string { return strings.Join(l, ",") }
+
+// CheckList rejects empty list entries and entries containing a comma.
+func CheckList(what string, l []string) error {
+	for _, s := range l {
+		if s == "" || strings.Contains(s, ",") {
+			return fmt.Errorf("invalid %s %q: empty or contains a comma", what, s)
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func (r *SQLiteRepo) SaveToken(t *Token) error {
+	if t.ID == "" || t.SecretHash == "" {
+		return errors.New("invalid token data")
+	}
+	if err := errors.Join(CheckList("scope", t.Scopes), CheckList("alias", t.Aliases), CheckList("tag", t.Tags)); err != nil {
+		return err
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	_, err := r.q.Exec(`INSERT INTO tokens(id, name, secret_hash, scopes, aliases, tags, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name=excluded.name,
+			secret_hash=excluded.secret_hash,
+			scopes=excluded.scopes,
+			aliases=excluded.aliases,
+			tags=excluded.tags;`,
+		t.ID, t.Name, t.SecretHash, joinList(t.Scopes), joinList(t.Aliases), joinList(t.Tags), t.CreatedAt)
+	return err
+}
+
+const tokenColumns = `id, name, secret_hash, scopes, aliases, tags, created_at, revoked_at`
+
+func scanToken(row interface{ Scan(...any) error }) (*Token, error) {
+	var t Token
+	var scopes, aliases, tags string
+	var revoked sql.NullTime
+	if err := row.Scan(&t.ID, &t.Name, &t.SecretHash, &scopes, &aliases, &tags, &t.CreatedAt, &revoked); err != nil {
+		return nil, err
+	}
+	t.Scopes, t.Aliases, t.Tags = splitList(scopes), splitList(aliases), splitList(tags)
+	if revoked.Valid {
+		t.RevokedAt = &revoked.Time
+	}
+	return &t, nil
+}
+
+func (r *SQLiteRepo) GetToken(id string) (*Token, error) {
+	t, err := scanToken(r.q.QueryRow(`SELECT `+tokenColumns+` FROM tokens WHERE id=?;`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &NotFoundError{Kind: "token", Key: id}
+	}
+	return t, err
+}
+
+func (r *SQLiteRepo) GetAllTokens() ([]*Token, error) {
+	rows, err := r.q.Query(`SELECT ` + tokenColumns + ` FROM tokens ORDER BY created_at;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Token
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (r *SQLiteRepo) RevokeToken(id string, at time.Time) error {
+	res, err := r.q.Exec(`UPDATE tokens SET revoked_at=? WHERE id=? AND revoked_at IS NULL`, at, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Kind: "token", Key: id}
+	}
+	return nil
+}
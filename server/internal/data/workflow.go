@@ -0,0 +1,58 @@
+package data
+
+import (
+	"errors"
+	"time"
+)
+
+// WorkflowRun is a workflow interrupted by a shutdown, saved so the next start can resume it.
+type WorkflowRun struct {
+	Alias    string    `json:"alias"`
+	Workflow string    `json:"workflow"`
+	Caller   string    `json:"caller" doc:"Who started the run, kept on its audit entries after a resume"`
+	Step     string    `json:"step" doc:"Step to resume from"`
+	Started  time.Time `json:"started_at" doc:"When the protocol instance was restarted"`
+	SavedAt  time.Time `json:"saved_at"`
+}
+
+type WorkflowRepo interface {
+	SaveWorkflowRun(r *WorkflowRun) error
+	GetWorkflowRuns() ([]*WorkflowRun, error)
+	DeleteWorkflowRun(alias string) error
+}
+
+func (r *SQLiteRepo) SaveWorkflowRun(w *WorkflowRun) error {
+	if w.Alias == "" || w.Workflow == "" || w.Step == "" {
+		return errors.New("invalid workflow run data")
+	}
+	if w.SavedAt.IsZero() {
+		w.SavedAt = time.Now()
+	}
+	_, err := r.q.Exec(`INSERT INTO workflow_runs(alias, workflow, caller, step, started_at, saved_at) VALUES(?, ?, ?, ?, ?, ?)
+		ON CONFLICT(alias) DO UPDATE SET workflow=excluded.workflow, caller=excluded.caller, step=excluded.step,
+		started_at=excluded.started_at, saved_at=excluded.saved_at;`,
+		w.Alias, w.Workflow, w.Caller, w.Step, w.Started, w.SavedAt)
+	return err
+}
+
+func (r *SQLiteRepo) GetWorkflowRuns() ([]*WorkflowRun, error) {
+	rows, err := r.q.Query(`SELECT alias, workflow, caller, step, started_at, saved_at FROM workflow_runs ORDER BY saved_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*WorkflowRun
+	for rows.Next() {
+		var w WorkflowRun
+		if err := rows.Scan(&w.Alias, &w.Workflow, &w.Caller, &w.Step, &w.Started, &w.SavedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, &w)
+	}
+	return out, rows.Err()
+}
+
+func (r *SQLiteRepo) DeleteWorkflowRun(alias string) error {
+	_, err := r.q.Exec(`DELETE FROM workflow_runs WHERE alias=?`, alias)
+	return err
+}
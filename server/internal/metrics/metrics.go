@@ -0,0 +1,96 @@
+// Package metrics holds the Prometheus collectors exposed on /metrics.
+// They live in their own registry, so only what is declared here is exported.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "sealdice_mcsm"
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	WSConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Name: "ws_connections",
+		Help: "Open authenticated WS connections.",
+	})
+	WSConnectionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "ws_connections_total",
+		Help: "WS connections authenticated since start.",
+	})
+	Actions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "actions_total",
+		Help: "WS actions handled, by action and result code.",
+	}, []string{"action", "code"})
+	ActionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "action_duration_seconds",
+		Help:    "Time to answer a WS action.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"action"})
+	MCSMRequests = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "mcsm_request_duration_seconds",
+		Help:    "MCSM API call latency by endpoint and HTTP status (\"error\" when no answer).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+	WorkflowRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "workflow_runs_total",
+		Help: "Finished workflow runs by outcome: ok, error or suspended.",
+	}, []string{"workflow", "outcome"})
+	WorkflowStepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "workflow_step_duration_seconds",
+		Help:    "Workflow step duration.",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 180},
+	}, []string{"workflow", "step", "result"})
+	QRWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "qrcode_wait_seconds",
+		Help:    "Time from protocol restart until its QR code was fetched.",
+		Buckets: []float64{2, 5, 10, 15, 20, 30, 45, 60},
+	})
+	InstanceStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Name: "instance_status",
+		Help: "Last polled MCSM status of each bound instance: -1 busy, 0 stopped, 1 stopping, 2 starting, 3 running.",
+	}, []string{"alias", "role", "instance_id"})
+	InstancePollUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Name: "instance_poll_up",
+		Help: "1 if the last status poll of the instance succeeded.",
+	}, []string{"alias", "role", "instance_id"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		WSConnections, WSConnectionsTotal, Actions, ActionDuration, MCSMRequests,
+		WorkflowRuns, WorkflowStepDuration, QRWait, InstanceStatus, InstancePollUp,
+	)
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveMCSM records one MCSM call; status 0 means the request got no answer.
+// It matches mcsm.Client.Observe.
+func ObserveMCSM(method, endpoint string, status int, d time.Duration) {
+	s := "error"
+	if status != 0 {
+		s = strconv.Itoa(status)
+	}
+	MCSMRequests.WithLabelValues(method+" "+endpoint, s).Observe(d.Seconds())
+}
+
+// Result is "ok" or "error" for a step label.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
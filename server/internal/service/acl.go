@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+)
+
+// Chat roles, lowest first. The plugin maps Sealdice privilege levels onto them.
+var ChatRoles = []string{"member", "admin", "owner", "master"}
+
+func roleRank(role string) int {
+	return slices.Index(ChatRoles, role) // unknown roles rank below member
+}
+
+// ChatCaller is the chat user a plugin request was made for. It is asserted by the token
+// holder, so ACLs only narrow what that token may already do.
+type ChatCaller struct {
+	Platform string `json:"platform,omitempty"`
+	GroupID  string `json:"group_id,omitempty" doc:"Empty for private chats"`
+	UserID   string `json:"user_id"`
+	Role     string `json:"role,omitempty" doc:"member, admin, owner or master"`
+}
+
+func (c *ChatCaller) String() string {
+	if c.GroupID == "" {
+		return c.UserID
+	}
+	return c.UserID + "@" + c.GroupID
+}
+
+type ACLService struct {
+	repo data.ACLRepo
+	cfg  *config.Config
+}
+
+func NewACLService(repo data.ACLRepo, cfg *config.Config) *ACLService {
+	return &ACLService{repo: repo, cfg: cfg}
+}
+
+func (s *ACLService) List() ([]*data.ACLRule, error) {
+	return s.repo.GetAllACLRules()
+}
+
+// Save creates (ID 0) or replaces a rule.
+func (s *ACLService) Save(rule *data.ACLRule) error {
+	if err := validateACLRule(rule); err != nil {
+		return err
+	}
+	return s.repo.SaveACLRule(rule)
+}
+
+func validateACLRule(rule *data.ACLRule) error {
+	if rule.MinRole != "" && roleRank(rule.MinRole) < 0 {
+		return fmt.Errorf("unknown role %q (roles: member, admin, owner, master)", rule.MinRole)
+	}
+	if err := errors.Join(data.CheckList("alias", rule.Aliases), data.CheckList("action", rule.Actions)); err != nil {
+		return err
+	}
+	for _, a := range rule.Aliases {
+		if _, err := path.Match(a, ""); err != nil {
+			return fmt.Errorf("invalid alias pattern %q: %v", a, err)
+		}
+	}
+	return nil
+}
+
+func (s *ACLService) Delete(id int64) error {
+	return s.repo.DeleteACLRule(id)
+}
+
+// Apply narrows p for a chat caller running action (which requires scope). Requests without
+// chat context and ACLs being disabled leave p as is. Masters bypass the rules; actions listed
+// in acl.admin_actions need at least the admin role; otherwise a matching rule must exist, and
+// the aliases of the matching rules become an extra whitelist.
+func (s *ACLService) Apply(p *Principal, c *ChatCaller, action, scope string) (*Principal, error) {
+	if c == nil || !s.cfg.ACL.Enable {
+		return p, nil
+	}
+	rank := roleRank(c.Role)
+	if rank >= roleRank("master") {
+		return p.withChat(c, nil), nil
+	}
+	if slices.Contains(s.cfg.ACL.AdminActions, action) && rank < roleRank("admin") {
+		return nil, fmt.Errorf("%w: %s requires a group admin", ErrForbidden, action)
+	}
+
+	rules, err := s.repo.GetAllACLRules()
+	if err != nil {
+		return nil, err
+	}
+	var aliases []string
+	matched := false
+	for _, r := range rules {
+		if !matchesRule(r, c, action, scope, rank) {
+			continue
+		}
+		if len(r.Aliases) == 0 {
+			return p.withChat(c, nil), nil
+		}
+		matched = true
+		aliases = append(aliases, r.Aliases...)
+	}
+	if !matched {
+		return nil, fmt.Errorf("%w: no acl rule allows %s to %s", ErrForbidden, c, action)
+	}
+	return p.withChat(c, aliases), nil
+}
+
+func matchesRule(r *data.ACLRule, c *ChatCaller, action, scope string, rank int) bool {
+	if r.Platform != "" && r.Platform != c.Platform ||
+		r.GroupID != "" && r.GroupID != c.GroupID ||
+		r.UserID != "" && r.UserID != c.UserID {
+		return false
+	}
+	if r.MinRole != "" && rank < roleRank(r.MinRole) {
+		return false
+	}
+	return slices.Contains(r.Actions, "*") || slices.Contains(r.Actions, action) || slices.Contains(r.Actions, scope)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+)
+
+func TestACLApply(t *testing.T) {
+	repo := newTestRepo(t)
+	rules := []*data.ACLRule{
+		{GroupID: "g1", Actions: []string{ScopeRead}},                                               // anyone in g1 may read
+		{GroupID: "g1", Actions: []string{"restart"}, Aliases: []string{"bot-*"}, MinRole: "admin"}, // g1 admins restart bots
+		{UserID: "u9", Platform: "QQ", Actions: []string{"*"}, Aliases: []string{"dev"}},            // u9 does anything to dev
+	}
+	for _, r := range rules {
+		if err := repo.SaveACLRule(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{}
+	cfg.ACL.Enable = true
+	cfg.ACL.AdminActions = []string{"kill"}
+	acl := NewACLService(repo, cfg)
+	token := &Principal{Name: "plugin", Scopes: AllScopes}
+
+	tests := []struct {
+		name        string
+		caller      *ChatCaller
+		action      string
+		scope       string
+		wantErr     bool
+		wantAliases []string // the chat whitelist, nil for none
+	}{
+		{name: "no chat context", caller: nil, action: "kill", scope: ScopeControl},
+		{name: "master bypasses rules", caller: &ChatCaller{GroupID: "g2", UserID: "u1", Role: "master"}, action: "kill", scope: ScopeControl},
+		{name: "admin action needs admin", caller: &ChatCaller{GroupID: "g1", UserID: "u1", Role: "member"}, action: "kill", scope: ScopeControl, wantErr: true},
+		{name: "scope rule, any alias", caller: &ChatCaller{GroupID: "g1", UserID: "u1"}, action: "status", scope: ScopeRead},
+		{name: "min role not met", caller: &ChatCaller{GroupID: "g1", UserID: "u1", Role: "member"}, action: "restart", scope: ScopeControl, wantErr: true},
+		{name: "min role met, aliases limited", caller: &ChatCaller{GroupID: "g1", UserID: "u1", Role: "owner"}, action: "restart", scope: ScopeControl, wantAliases: []string{"bot-*"}},
+		{name: "wildcard action", caller: &ChatCaller{Platform: "QQ", GroupID: "g3", UserID: "u9"}, action: "stop", scope: ScopeControl, wantAliases: []string{"dev"}},
+		{name: "platform mismatch", caller: &ChatCaller{Platform: "TG", GroupID: "g3", UserID: "u9"}, action: "stop", scope: ScopeControl, wantErr: true},
+		{name: "no matching rule", caller: &ChatCaller{GroupID: "g2", UserID: "u1"}, action: "status", scope: ScopeRead, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := acl.Apply(token, tt.caller, tt.action, tt.scope)
+			if tt.wantErr {
+				if !errors.Is(err, ErrForbidden) {
+					t.Fatalf("error = %v, want ErrForbidden", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Chat != tt.caller {
+				t.Errorf("Chat = %v, want %v", p.Chat, tt.caller)
+			}
+			if !reflect.DeepEqual(p.chatAliases, tt.wantAliases) {
+				t.Errorf("chat aliases = %v, want %v", p.chatAliases, tt.wantAliases)
+			}
+			if !reflect.DeepEqual(p.Scopes, token.Scopes) {
+				t.Errorf("scopes changed to %v", p.Scopes)
+			}
+		})
+	}
+
+	cfg.ACL.Enable = false
+	if p, err := acl.Apply(token, &ChatCaller{GroupID: "g2", UserID: "u1"}, "kill", ScopeControl); err != nil || p != token {
+		t.Errorf("ACL disabled: Apply = %v, %v; want the token unchanged", p, err)
+	}
+}
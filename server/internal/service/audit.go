@@ -0,0 +1,149 @@
+package service
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+)
+
+const (
+	auditPruneInterval = time.Hour
+	DefaultAuditLimit  = 100
+	MaxAuditLimit      = 1000
+)
+
+// AuditService records who did what. Recording never fails the audited action;
+// storage errors are only logged.
+type AuditService struct {
+	repo data.AuditRepo
+	cfg  *config.Config
+	Log  *slog.Logger
+	stop chan struct{}
+}
+
+func NewAuditService(repo data.AuditRepo, cfg *config.Config) *AuditService {
+	return &AuditService{repo: repo, cfg: cfg, Log: slog.Default()}
+}
+
+func (s *AuditService) Record(e *data.AuditEntry) {
+	if s == nil {
+		return
+	}
+	if err := s.repo.AddAudit(e); err != nil {
+		s.Log.Error("failed to record audit entry", "source", e.Source, "action", e.Action, "err", err)
+	}
+}
+
+// Step records one workflow step that started at started and ended with err.
+func (s *AuditService) Step(workflow, step, alias, caller string, started time.Time, err error) {
+	e := &data.AuditEntry{
+		Time:       started,
+		Source:     data.AuditWorkflow,
+		Caller:     caller,
+		Action:     workflow + ":" + step,
+		Target:     alias,
+		Result:     "ok",
+		DurationMS: time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		e.Result, e.Error = "error", err.Error()
+	}
+	s.Record(e)
+}
+
+func (s *AuditService) Query(f data.AuditFilter) ([]*data.AuditEntry, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultAuditLimit
+	}
+	if f.Limit > MaxAuditLimit {
+		f.Limit = MaxAuditLimit
+	}
+	return s.repo.QueryAudit(f)
+}
+
+// Prune deletes entries older than audit.retention.
+func (s *AuditService) Prune() {
+	if s.cfg.Audit.Retention <= 0 {
+		return
+	}
+	n, err := s.repo.PruneAudit(time.Now().Add(-s.cfg.Audit.Retention))
+	if err != nil {
+		s.Log.Error("audit prune failed", "err", err)
+	} else if n > 0 {
+		s.Log.Info("pruned audit entries", "count", n)
+	}
+}
+
+// Start prunes now and then every hour until Stop.
+func (s *AuditService) Start() {
+	s.stop = make(chan struct{})
+	go func() {
+		t := time.NewTicker(auditPruneInterval)
+		defer t.Stop()
+		for {
+			s.Prune()
+			select {
+			case <-t.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *AuditService) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+	}
+}
+
+// secretKeys are param names whose values never reach the audit log.
+var secretKeys = []string{"token", "secret", "password", "apikey", "api_key"}
+
+// RedactParams returns v as JSON with the values of secret-looking keys replaced.
+func RedactParams(v any) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	var tree any
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return string(raw)
+	}
+	tree = redact(tree)
+	if m, ok := tree.(map[string]any); ok && len(m) == 0 {
+		return ""
+	}
+	out, _ := json.Marshal(tree)
+	return string(out)
+}
+
+func redact(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			lk := strings.ToLower(k)
+			secret := false
+			for _, sk := range secretKeys {
+				if strings.Contains(lk, sk) {
+					secret = true
+					break
+				}
+			}
+			if secret {
+				t[k] = "***"
+			} else {
+				t[k] = redact(val)
+			}
+		}
+	case []any:
+		for i := range t {
+			t[i] = redact(t[i])
+		}
+	}
+	return v
+}
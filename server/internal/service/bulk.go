@@ -0,0 +1,196 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"sealdice-mcsm/server/internal/data"
+)
+
+const (
+	DefaultBulkConcurrency = 4
+	MaxBulkConcurrency     = 16
+)
+
+// Selector picks a set of bindings: "all", a comma separated alias list, a glob pattern (e.g. "bot-*")
+// or a tag ("tag:test").
+type Selector struct {
+	All     bool
+	Aliases []string
+	Glob    string
+	Tag     string
+
+	// Allow, when set, limits the selection to the bindings it accepts (token whitelists).
+	// Explicitly listed aliases it rejects fail the whole selection.
+	Allow func(*data.Binding) error
+}
+
+// IsSelector reports whether target should be treated as a multi-binding selector
+// rather than a single alias or instance UUID.
+func IsSelector(target string) bool {
+	return target == "all" || strings.HasPrefix(target, "tag:") || strings.ContainsAny(target, "*?[,")
+}
+
+func ParseSelector(s string) (Selector, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return Selector{}, fmt.Errorf("selector required")
+	case s == "all" || s == "*":
+		return Selector{All: true}, nil
+	case strings.HasPrefix(s, "tag:"):
+		tag := strings.TrimSpace(strings.TrimPrefix(s, "tag:"))
+		if tag == "" {
+			return Selector{}, fmt.Errorf("empty tag in selector")
+		}
+		return Selector{Tag: tag}, nil
+	case strings.ContainsAny(s, "*?["):
+		if _, err := path.Match(s, ""); err != nil {
+			return Selector{}, fmt.Errorf("invalid glob %q: %v", s, err)
+		}
+		return Selector{Glob: s}, nil
+	}
+
+	var sel Selector
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			sel.Aliases = append(sel.Aliases, a)
+		}
+	}
+	return sel, nil
+}
+
+// Select resolves a selector against the stored bindings, sorted by alias.
+// Explicit aliases that are not bound are reported as an error; repeated ones count once.
+func (s *InstanceService) Select(sel Selector) ([]*data.Binding, error) {
+	var out []*data.Binding
+	if len(sel.Aliases) > 0 {
+		seen := make(map[string]bool, len(sel.Aliases))
+		for _, alias := range sel.Aliases {
+			if seen[alias] {
+				continue
+			}
+			seen[alias] = true
+			b, err := s.repo.GetBinding(alias)
+			if err != nil {
+				return nil, err
+			}
+			if sel.Allow != nil {
+				if err := sel.Allow(b); err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, b)
+		}
+	} else if sel.Tag != "" {
+		tagged, err := s.repo.GetBindingsByTag(sel.Tag)
+		if err != nil {
+			return nil, err
+		}
+		out = tagged
+	} else {
+		all, err := s.repo.GetAllBindings()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range all {
+			if sel.All {
+				out = append(out, b)
+			} else if ok, _ := path.Match(sel.Glob, b.Alias); ok {
+				out = append(out, b)
+			}
+		}
+	}
+	if sel.Allow != nil && len(sel.Aliases) == 0 {
+		allowed := out[:0]
+		for _, b := range out {
+			if sel.Allow(b) == nil {
+				allowed = append(allowed, b)
+			}
+		}
+		out = allowed
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Alias < out[j].Alias })
+	return out, nil
+}
+
+// BulkResult is one row of the per-alias result table returned by bulk actions.
+type BulkResult struct {
+	Alias  string       `json:"alias"`
+	Status string       `json:"status"` // ok, failed
+	Steps  []StepResult `json:"steps,omitempty"`
+	Data   any          `json:"data,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// Bulk runs action (an instance action or status) on every selected binding,
+// with at most concurrency bindings in flight at once. Results are sorted by alias, as Select returns them.
+func (s *ControlService) Bulk(sel Selector, action string, concurrency int, lg *slog.Logger) ([]BulkResult, error) {
+	if action != "status" && !slices.Contains(InstanceActions, action) {
+		return nil, fmt.Errorf("unsupported bulk action: %s", action)
+	}
+
+	bindings, err := s.InstanceSvc.Select(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	if concurrency <= 0 {
+		concurrency = DefaultBulkConcurrency
+	}
+	if concurrency > MaxBulkConcurrency {
+		concurrency = MaxBulkConcurrency
+	}
+
+	results := make([]BulkResult, len(bindings))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, b := range bindings {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, b *data.Binding) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res := BulkResult{Alias: b.Alias, Status: "ok"}
+			var err error
+			if action == "status" {
+				res.Data, err = s.BindingStatus(b.Alias)
+			} else {
+				res.Steps, err = s.BindingAction(b.Alias, action, lg)
+			}
+			if err != nil {
+				res.Status = "failed"
+				res.Error = err.Error()
+			}
+			results[i] = res
+		}(i, b)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// BindingStatus returns the MCSM status code of each instance in the binding, keyed by role.
+func (s *ControlService) BindingStatus(alias string) (map[string]int, error) {
+	binding, err := s.InstanceSvc.GetByAlias(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]int, len(binding.Instances))
+	for _, inst := range binding.Instances {
+		detail, err := s.MCSM.InstanceDetail(inst.InstanceID, "local")
+		if err != nil {
+			return out, fmt.Errorf("%s status: %v", inst.Role, err)
+		}
+		out[inst.Role] = detail.Data.Status
+	}
+	return out, nil
+}
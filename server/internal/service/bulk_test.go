@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sealdice-mcsm/server/internal/data"
+)
+
+// newTestRepo opens a migrated database in a temporary directory.
+func newTestRepo(t *testing.T) *data.SQLiteRepo {
+	t.Helper()
+	repo, err := data.NewSQLiteRepo(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// bind stores a protocol+core binding for alias with tags.
+func bind(t *testing.T, repo data.BindingRepo, alias string, tags ...string) {
+	t.Helper()
+	err := repo.SaveBinding(&data.Binding{
+		Alias: alias,
+		Instances: []data.BindingInstance{
+			{Role: RoleProtocol, InstanceID: alias + "-p"},
+			{Role: RoleCore, InstanceID: alias + "-c"},
+		},
+		Tags: tags,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Selector
+		wantErr bool
+	}{
+		{in: "all", want: Selector{All: true}},
+		{in: "*", want: Selector{All: true}},
+		{in: " tag:test ", want: Selector{Tag: "test"}},
+		{in: "tag: ", wantErr: true},
+		{in: "bot-*", want: Selector{Glob: "bot-*"}},
+		{in: "bot-[", wantErr: true},
+		{in: "a, b,,c", want: Selector{Aliases: []string{"a", "b", "c"}}},
+		{in: "solo", want: Selector{Aliases: []string{"solo"}}},
+		{in: "  ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSelector(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSelector(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSelector(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func isForbidden(err error) bool { return errors.Is(err, ErrForbidden) }
+
+func TestSelect(t *testing.T) {
+	repo := newTestRepo(t)
+	bind(t, repo, "bot-a", "main")
+	bind(t, repo, "bot-b", "test")
+	bind(t, repo, "dev", "test")
+	inst := NewInstanceService(repo)
+
+	denyDev := func(b *data.Binding) error {
+		if b.Alias == "dev" {
+			return ErrForbidden
+		}
+		return nil
+	}
+	tests := []struct {
+		name    string
+		sel     string
+		allow   func(*data.Binding) error
+		want    []string
+		wantErr func(error) bool
+	}{
+		{name: "all", sel: "all", want: []string{"bot-a", "bot-b", "dev"}},
+		{name: "glob", sel: "bot-*", want: []string{"bot-a", "bot-b"}},
+		{name: "tag", sel: "tag:test", want: []string{"bot-b", "dev"}},
+		{name: "unknown tag", sel: "tag:none", want: nil},
+		{name: "aliases sorted", sel: "dev,bot-a", want: []string{"bot-a", "dev"}},
+		{name: "repeated alias once", sel: "bot-a,dev,bot-a", want: []string{"bot-a", "dev"}},
+		{name: "unbound alias", sel: "bot-a,ghost", wantErr: data.IsNotFound},
+		{name: "allow filters", sel: "all", allow: denyDev, want: []string{"bot-a", "bot-b"}},
+		{name: "allow rejects listed", sel: "bot-a,dev", allow: denyDev, wantErr: isForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseSelector(tt.sel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sel.Allow = tt.allow
+			got, err := inst.Select(sel)
+			if tt.wantErr != nil {
+				if err == nil || !tt.wantErr(err) {
+					t.Fatalf("Select(%q) error = %v", tt.sel, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var aliases []string
+			for _, b := range got {
+				aliases = append(aliases, b.Alias)
+			}
+			if !reflect.DeepEqual(aliases, tt.want) {
+				t.Errorf("Select(%q) = %v, want %v", tt.sel, aliases, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"sealdice-mcsm/server/pkg/mcsm"
+)
+
+const (
+	RoleProtocol = "protocol"
+	RoleCore     = "core"
+	RoleBoth     = "both"
+)
+
+// InstanceActions are the lifecycle actions accepted for instances and bindings.
+var InstanceActions = []string{"start", "stop", "restart", "fstop", "kill"}
+
+// StepResult records the outcome of a single instance action within a coordinated operation.
+type StepResult struct {
+	Role       string `json:"role"`
+	InstanceID string `json:"instance_id"`
+	Action     string `json:"action"`
+	Status     string `json:"status"` // ok, error, skipped
+	Error      string `json:"error,omitempty"`
+}
+
+// ControlService drives instance actions for a whole binding in role order.
+type ControlService struct {
+	InstanceSvc *InstanceService
+	MCSM        *mcsm.Client
+	Log         *slog.Logger
+
+	// How long to wait for an instance to reach running/stopped before moving on to the next role.
+	StartTimeout time.Duration
+}
+
+func NewControlService(instSvc *InstanceService, mcsm *mcsm.Client) *ControlService {
+	return &ControlService{
+		InstanceSvc:  instSvc,
+		MCSM:         mcsm,
+		StartTimeout: 60 * time.Second,
+		Log:          slog.Default(),
+	}
+}
+
+type bindingStep struct {
+	role       string
+	instanceID string
+	action     string
+	waitFor    int // mcsm status to wait for after the action, noWait = don't wait
+}
+
+const noWait = -2
+
+// BindingAction runs action against every instance of the binding, following role order.
+// start: each role in order, waiting until it is running before starting the next.
+// stop/fstop/kill: roles in reverse order.
+// restart: stop sequence followed by start sequence.
+// Once a step fails the remaining steps are reported as skipped.
+// lg carries the caller's request attributes; nil logs without them.
+func (s *ControlService) BindingAction(alias, action string, lg *slog.Logger) ([]StepResult, error) {
+	if lg == nil {
+		lg = s.Log
+	}
+	binding, err := s.InstanceSvc.GetByAlias(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	startSeq := func() []bindingStep {
+		var out []bindingStep
+		for i, inst := range binding.Instances {
+			wait := mcsm.StatusRunning
+			if i == len(binding.Instances)-1 {
+				wait = noWait
+			}
+			out = append(out, bindingStep{inst.Role, inst.InstanceID, "start", wait})
+		}
+		return out
+	}
+	stopSeq := func(action string, wait int) []bindingStep {
+		var out []bindingStep
+		for i := len(binding.Instances) - 1; i >= 0; i-- {
+			inst := binding.Instances[i]
+			out = append(out, bindingStep{inst.Role, inst.InstanceID, action, wait})
+		}
+		return out
+	}
+
+	var steps []bindingStep
+	switch action {
+	case "start":
+		steps = startSeq()
+	case "stop", "fstop", "kill":
+		steps = stopSeq(action, noWait)
+	case "restart":
+		steps = append(stopSeq("stop", mcsm.StatusStopped), startSeq()...)
+	default:
+		return nil, fmt.Errorf("unsupported binding action: %s", action)
+	}
+
+	// TODO: DaemonID "local" assumption, same as the relogin workflow.
+	daemonID := "local"
+
+	results := make([]StepResult, 0, len(steps))
+	var failed error
+	for _, st := range steps {
+		res := StepResult{Role: st.role, InstanceID: st.instanceID, Action: st.action}
+		if failed != nil {
+			res.Status = "skipped"
+			results = append(results, res)
+			continue
+		}
+
+		lg.Info("instance action", "alias", alias, "role", st.role, "instance_id", st.instanceID, "op", st.action)
+		err := s.MCSM.InstanceAction(st.instanceID, daemonID, st.action)
+		if err == nil && st.waitFor != noWait {
+			err = s.MCSM.WaitForStatus(st.instanceID, daemonID, st.waitFor, s.StartTimeout)
+		}
+		if err != nil {
+			res.Status = "error"
+			res.Error = err.Error()
+			failed = fmt.Errorf("%s %s failed: %v", st.role, st.action, err)
+		} else {
+			res.Status = "ok"
+		}
+		results = append(results, res)
+	}
+
+	return results, failed
+}
+
+// Act runs action on a single role of alias, or on the whole binding when role is empty or "both".
+func (s *ControlService) Act(alias, role, action string, lg *slog.Logger) ([]StepResult, error) {
+	if !slices.Contains(InstanceActions, action) {
+		return nil, fmt.Errorf("unsupported action: %s", action)
+	}
+	if role == "" || role == RoleBoth {
+		return s.BindingAction(alias, action, lg)
+	}
+
+	instanceID, err := s.InstanceSvc.ResolveInstance(alias, role)
+	if err != nil {
+		return nil, err
+	}
+	if lg == nil {
+		lg = s.Log
+	}
+	lg.Info("instance action", "alias", alias, "role", role, "instance_id", instanceID, "op", action)
+	res := StepResult{Role: role, InstanceID: instanceID, Action: action, Status: "ok"}
+	if err := s.MCSM.InstanceAction(instanceID, "local", action); err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+		return []StepResult{res}, err
+	}
+	return []StepResult{res}, nil
+}
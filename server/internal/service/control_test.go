@@ -0,0 +1,240 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sealdice-mcsm/server/pkg/mcsm"
+)
+
+// fakeMCSM answers the instance endpoints of the panel. Instances pass through
+// starting or stopping for one status poll before they settle, so callers have
+// to wait. It logs "<endpoint> <uuid>" for each call.
+type fakeMCSM struct {
+	URL string
+
+	mu     sync.Mutex
+	status map[string]int
+	fail   map[string]bool // uuids whose actions fail
+	stuck  map[string]bool // uuids that never finish starting
+	calls  []string
+}
+
+func newFakeMCSM(t *testing.T) (*fakeMCSM, *mcsm.Client) {
+	f := &fakeMCSM{status: map[string]int{}, fail: map[string]bool{}, stuck: map[string]bool{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.URL = srv.URL
+	c := mcsm.NewClient(srv.URL, "key")
+	c.PollInterval = time.Millisecond
+	return f, c
+}
+
+func (f *fakeMCSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	uuid := r.URL.Query().Get("uuid")
+	if r.URL.Path == "/api/instance" {
+		st := f.status[uuid]
+		switch {
+		case st == mcsm.StatusStarting && !f.stuck[uuid]:
+			f.status[uuid] = mcsm.StatusRunning
+		case st == mcsm.StatusStopping:
+			f.status[uuid] = mcsm.StatusStopped
+		}
+		var resp mcsm.InstanceDetailResponse
+		resp.Status = http.StatusOK
+		resp.Data.Status = st
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	endpoint := path.Base(r.URL.Path)
+	f.calls = append(f.calls, endpoint+" "+uuid)
+	if f.fail[uuid] {
+		http.Error(w, `{"status":500}`, http.StatusInternalServerError)
+		return
+	}
+	switch endpoint {
+	case "open":
+		f.status[uuid] = mcsm.StatusStarting
+	case "stop":
+		f.status[uuid] = mcsm.StatusStopping
+	case "kill":
+		f.status[uuid] = mcsm.StatusStopped
+	}
+	w.Write([]byte(`{"status":200}`))
+}
+
+func (f *fakeMCSM) reset() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func TestBindingAction(t *testing.T) {
+	repo := newTestRepo(t)
+	bind(t, repo, "bot") // protocol bot-p, then core bot-c
+	fake, client := newFakeMCSM(t)
+	ctl := NewControlService(NewInstanceService(repo), client)
+	ctl.StartTimeout = time.Second
+
+	step := func(role, action, status string) string { return role + " " + action + " " + status }
+	tests := []struct {
+		name      string
+		action    string
+		fail      string // instance whose actions fail
+		stuck     string // instance that never reaches running
+		wantCalls []string
+		wantSteps []string
+		wantErr   bool
+	}{
+		{
+			name:   "start in role order",
+			action: "start",
+			// bot-c starts only once bot-p reported running.
+			wantCalls: []string{"open bot-p", "open bot-c"},
+			wantSteps: []string{step(RoleProtocol, "start", "ok"), step(RoleCore, "start", "ok")},
+		},
+		{
+			name:      "stop in reverse order",
+			action:    "stop",
+			wantCalls: []string{"stop bot-c", "stop bot-p"},
+			wantSteps: []string{step(RoleCore, "stop", "ok"), step(RoleProtocol, "stop", "ok")},
+		},
+		{
+			name:      "kill in reverse order",
+			action:    "kill",
+			wantCalls: []string{"kill bot-c", "kill bot-p"},
+			wantSteps: []string{step(RoleCore, "kill", "ok"), step(RoleProtocol, "kill", "ok")},
+		},
+		{
+			name:      "restart stops then starts",
+			action:    "restart",
+			wantCalls: []string{"stop bot-c", "stop bot-p", "open bot-p", "open bot-c"},
+			wantSteps: []string{step(RoleCore, "stop", "ok"), step(RoleProtocol, "stop", "ok"),
+				step(RoleProtocol, "start", "ok"), step(RoleCore, "start", "ok")},
+		},
+		{
+			name:      "failed start skips the rest",
+			action:    "start",
+			fail:      "bot-p",
+			wantCalls: []string{"open bot-p"},
+			wantSteps: []string{step(RoleProtocol, "start", "error"), step(RoleCore, "start", "skipped")},
+			wantErr:   true,
+		},
+		{
+			name:      "failed stop during restart skips the rest",
+			action:    "restart",
+			fail:      "bot-c",
+			wantCalls: []string{"stop bot-c"},
+			wantSteps: []string{step(RoleCore, "stop", "error"), step(RoleProtocol, "stop", "skipped"),
+				step(RoleProtocol, "start", "skipped"), step(RoleCore, "start", "skipped")},
+			wantErr: true,
+		},
+		{
+			name:      "not running in time",
+			action:    "start",
+			stuck:     "bot-p",
+			wantCalls: []string{"open bot-p"},
+			wantSteps: []string{step(RoleProtocol, "start", "error"), step(RoleCore, "start", "skipped")},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.mu.Lock()
+			fake.fail = map[string]bool{tt.fail: true}
+			fake.stuck = map[string]bool{tt.stuck: true}
+			fake.status = map[string]int{}
+			fake.mu.Unlock()
+			fake.reset()
+			if tt.stuck != "" {
+				ctl.StartTimeout = 50 * time.Millisecond
+				defer func() { ctl.StartTimeout = time.Second }()
+			}
+
+			steps, err := ctl.BindingAction("bot", tt.action, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BindingAction error = %v, want error %v", err, tt.wantErr)
+			}
+			if calls := fake.reset(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			var got []string
+			for _, s := range steps {
+				got = append(got, step(s.Role, s.Action, s.Status))
+				if (s.Status == "error") != (s.Error != "") {
+					t.Errorf("step %+v: error text does not match status", s)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantSteps) {
+				t.Errorf("steps:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.wantSteps, "\n"))
+			}
+		})
+	}
+}
+
+func TestBindingActionWaits(t *testing.T) {
+	repo := newTestRepo(t)
+	bind(t, repo, "bot")
+	fake, client := newFakeMCSM(t)
+	ctl := NewControlService(NewInstanceService(repo), client)
+
+	// Record the protocol status at the moment the core is started.
+	var protoAtCoreStart int
+	inner := http.Handler(fake)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/open") && r.URL.Query().Get("uuid") == "bot-c" {
+			fake.mu.Lock()
+			protoAtCoreStart = fake.status["bot-p"]
+			fake.mu.Unlock()
+		}
+		inner.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	client.SetCredentials(srv.URL, "key")
+
+	if _, err := ctl.BindingAction("bot", "start", nil); err != nil {
+		t.Fatal(err)
+	}
+	if protoAtCoreStart != mcsm.StatusRunning {
+		t.Errorf("core started while protocol status was %d, want running (%d)", protoAtCoreStart, mcsm.StatusRunning)
+	}
+}
+
+func TestBulkRepeatedAlias(t *testing.T) {
+	repo := newTestRepo(t)
+	bind(t, repo, "a")
+	bind(t, repo, "b")
+	fake, client := newFakeMCSM(t)
+	ctl := NewControlService(NewInstanceService(repo), client)
+
+	sel, err := ParseSelector("b,a,a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctl.Bulk(sel, "stop", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var aliases []string
+	for _, r := range res {
+		aliases = append(aliases, r.Alias)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(aliases, want) {
+		t.Errorf("results for %v, want %v", aliases, want)
+	}
+	if calls := fake.reset(); len(calls) != 4 {
+		t.Errorf("calls = %v, want each instance stopped once", calls)
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+// Event names pushed through a Notifier, with their payload types.
+const (
+	EventLog       = "log"        // string
+	EventSuccess   = "success"    // string
+	EventError     = "error"      // ErrorEvent
+	EventQRCode    = "qrcode"     // QRCodeEvent
+	EventJobResult = "job_result" // JobResult
+	EventShutdown  = "shutdown"   // string, sent before the server closes connections
+
+	EventConfigReloaded = "config_reloaded" // ConfigReloaded
+)
+
+// Events pairs every event name with its payload type, for the protocol schema.
+// Add new events here along with their constant.
+var Events = []struct {
+	Name string
+	Data any
+}{
+	{EventLog, ""},
+	{EventSuccess, ""},
+	{EventError, ErrorEvent{}},
+	{EventQRCode, QRCodeEvent{}},
+	{EventJobResult, JobResult{}},
+	{EventShutdown, ""},
+	{EventConfigReloaded, ConfigReloaded{}},
+}
+
+// AliasEvent is implemented by payloads about one binding, so broadcasts can be
+// routed to the chat groups linked to it.
+type AliasEvent interface {
+	EventAlias() string
+}
+
+func (e QRCodeEvent) EventAlias() string { return e.Alias }
+func (e ErrorEvent) EventAlias() string  { return e.Alias }
+func (e JobResult) EventAlias() string   { return e.Alias }
+
+type QRCodeEvent struct {
+	Alias string `json:"alias"`
+	URL   string `json:"url"`
+}
+
+type ErrorEvent struct {
+	Alias string `json:"alias"`
+	Msg   string `json:"msg"`
+}
+
+// JobResult reports one run of a scheduled job.
+type JobResult struct {
+	JobID      int64        `json:"job_id"`
+	Name       string       `json:"name"`
+	Alias      string       `json:"alias"`
+	Kind       string       `json:"kind"`
+	Action     string       `json:"action"`
+	Status     string       `json:"status"` // ok, failed
+	Error      string       `json:"error,omitempty"`
+	Steps      []StepResult `json:"steps,omitempty"`
+	DurationMS int64        `json:"duration_ms"`
+}
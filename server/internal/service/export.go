@@ -0,0 +1,602 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/pkg/tokenhash"
+)
+
+// ExportVersion is the version of the document Export writes and Import reads.
+const ExportVersion = 1
+
+// Export is a portable dump of the bridge's settings, for moving it between hosts.
+// Export writes every section; a section missing from an imported document is
+// left alone, even in replace mode.
+type Export struct {
+	Version    int                         `json:"version"`
+	ExportedAt time.Time                   `json:"exported_at"`
+	Bindings   []*data.Binding             `json:"bindings"`
+	GroupLinks []*data.GroupLink           `json:"group_links"`
+	Jobs       []*data.Job                 `json:"jobs" doc:"Scheduled jobs; ids and run history are not imported"`
+	Tokens     []*ExportedToken            `json:"tokens" doc:"Active API tokens"`
+	ACLRules   []*data.ACLRule             `json:"acl_rules" doc:"Chat ACL rules; ids are not imported"`
+	Profiles   map[string]*ExportedProfile `json:"profiles" doc:"Protocol profiles of the exporting host's config; import compares them with its own but does not change config.yaml"`
+}
+
+// ExportedProfile is a config.Profile with durations written as in config.yaml.
+type ExportedProfile struct {
+	QRCodePath     string `json:"qrcode_path"`
+	QRCodeTimeout  string `json:"qrcode_timeout"`
+	ConfirmTimeout string `json:"confirm_timeout"`
+}
+
+func exportProfile(p config.Profile) *ExportedProfile {
+	return &ExportedProfile{QRCodePath: p.QRCodePath, QRCodeTimeout: p.QRCodeTimeout.String(), ConfirmTimeout: p.ConfirmTimeout.String()}
+}
+
+func (e *ExportedProfile) profile() (config.Profile, error) {
+	p := config.Profile{QRCodePath: e.QRCodePath}
+	var err error
+	if p.QRCodeTimeout, err = time.ParseDuration(e.QRCodeTimeout); err != nil {
+		return p, fmt.Errorf("qrcode_timeout: %v", err)
+	}
+	if p.ConfirmTimeout, err = time.ParseDuration(e.ConfirmTimeout); err != nil {
+		return p, fmt.Errorf("confirm_timeout: %v", err)
+	}
+	return p, nil
+}
+
+// ExportedToken carries the hash the API never shows. Plaintexts are not stored,
+// so tokens keep working after a move without being reissued.
+type ExportedToken struct {
+	data.Token
+	SecretHash string `json:"secret_hash"`
+}
+
+// Import modes.
+const (
+	ImportMerge   = "merge"   // add and update, keep what the document does not mention
+	ImportReplace = "replace" // also remove what a section of the document does not list
+)
+
+type ImportOptions struct {
+	Mode     string          // ImportMerge (default) or ImportReplace
+	DryRun   bool            // validate and count, then roll back
+	Profiles config.Profiles // this host's; imported bindings must name one of them
+}
+
+// ImportCounts tallies what an import did, or would do, to one section.
+type ImportCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed" doc:"Deleted in replace mode; tokens are revoked"`
+}
+
+type ImportResult struct {
+	Mode       string       `json:"mode"`
+	DryRun     bool         `json:"dry_run"`
+	Bindings   ImportCounts `json:"bindings"`
+	GroupLinks ImportCounts `json:"group_links"`
+	Jobs       ImportCounts `json:"jobs"`
+	Tokens     ImportCounts `json:"tokens"`
+	ACLRules   ImportCounts `json:"acl_rules"`
+	// Profiles are not imported, config.yaml stays as it is.
+	ProfilesDiffer []string `json:"profiles_differ,omitempty" doc:"Profiles of the document that this host's config lacks or defines differently"`
+}
+
+// errDryRun rolls back a dry run's transaction.
+var errDryRun = errors.New("dry run")
+
+// ExportAll dumps bindings, group links, jobs, active tokens and ACL rules, with
+// the protocol profiles in effect.
+func ExportAll(repo data.Repo, profiles config.Profiles) (*Export, error) {
+	doc := &Export{Version: ExportVersion, ExportedAt: time.Now().UTC(), Profiles: map[string]*ExportedProfile{}}
+	for name, p := range profiles {
+		doc.Profiles[name] = exportProfile(p)
+	}
+	var err error
+	if doc.Bindings, err = repo.GetAllBindings(); err != nil {
+		return nil, err
+	}
+	if doc.GroupLinks, err = repo.GetAllGroupLinks(); err != nil {
+		return nil, err
+	}
+	if doc.Jobs, err = repo.GetAllJobs(); err != nil {
+		return nil, err
+	}
+	tokens, err := repo.GetAllTokens()
+	if err != nil {
+		return nil, err
+	}
+	doc.Tokens = []*ExportedToken{}
+	for _, t := range tokens {
+		if t.RevokedAt == nil {
+			doc.Tokens = append(doc.Tokens, &ExportedToken{Token: *t, SecretHash: t.SecretHash})
+		}
+	}
+	if doc.ACLRules, err = repo.GetAllACLRules(); err != nil {
+		return nil, err
+	}
+	// Empty sections are written as [], so a replace import empties them on the target.
+	if doc.Bindings == nil {
+		doc.Bindings = []*data.Binding{}
+	}
+	if doc.GroupLinks == nil {
+		doc.GroupLinks = []*data.GroupLink{}
+	}
+	if doc.Jobs == nil {
+		doc.Jobs = []*data.Job{}
+	}
+	if doc.ACLRules == nil {
+		doc.ACLRules = []*data.ACLRule{}
+	}
+	return doc, nil
+}
+
+// MarshalExport encodes doc as "json" (the default) or "yaml".
+func MarshalExport(doc *Export, format string) ([]byte, error) {
+	raw, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "", "json":
+		return append(raw, '\n'), nil
+	case "yaml", "yml":
+		// Through a node tree, so keys keep their JSON names and order.
+		var node yaml.Node
+		if err := yaml.Unmarshal(raw, &node); err != nil {
+			return nil, err
+		}
+		blockStyle(&node)
+		return yaml.Marshal(&node)
+	}
+	return nil, fmt.Errorf("unknown export format %q, want json or yaml", format)
+}
+
+// blockStyle drops the flow and quoting styles JSON input leaves on a node tree;
+// the encoder still quotes strings that would otherwise read as another type.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// ParseExport decodes a JSON or YAML document. Unknown fields are rejected, so
+// a misspelt section is not silently skipped.
+func ParseExport(raw []byte) (*Export, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		var tree any
+		if err := yaml.Unmarshal(trimmed, &tree); err != nil {
+			return nil, fmt.Errorf("parse yaml: %w", err)
+		}
+		var err error
+		if trimmed, err = json.Marshal(tree); err != nil {
+			return nil, fmt.Errorf("parse yaml: %w", err)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.DisallowUnknownFields()
+	var doc Export
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Import validates doc and applies it in one transaction: either every change
+// is made or none. Bindings match by alias, group links by group and alias,
+// tokens by id; jobs and ACL rules have no portable id and match by content.
+// Tokens revoked on this host stay revoked.
+func Import(repo data.Repo, doc *Export, opts ImportOptions) (*ImportResult, error) {
+	if doc.Version != ExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d (this build reads %d)", doc.Version, ExportVersion)
+	}
+	if opts.Mode == "" {
+		opts.Mode = ImportMerge
+	}
+	if opts.Mode != ImportMerge && opts.Mode != ImportReplace {
+		return nil, fmt.Errorf("unknown import mode %q, want merge or replace", opts.Mode)
+	}
+
+	res := &ImportResult{Mode: opts.Mode, DryRun: opts.DryRun}
+	for _, name := range slices.Sorted(maps.Keys(doc.Profiles)) {
+		ep := doc.Profiles[name]
+		if ep == nil {
+			return nil, fmt.Errorf("profiles.%s: empty entry", name)
+		}
+		p, err := ep.profile()
+		if err != nil {
+			return nil, fmt.Errorf("profiles.%s: %w", name, err)
+		}
+		if own, ok := opts.Profiles[name]; !ok || own != p {
+			res.ProfilesDiffer = append(res.ProfilesDiffer, name)
+		}
+	}
+
+	err := repo.Transaction(func(tx data.Repo) error {
+		im := &importer{repo: tx, inst: NewInstanceService(tx), replace: opts.Mode == ImportReplace, profiles: opts.Profiles}
+		if im.replace && doc.Bindings != nil {
+			// Everything else is removed at the end; links and jobs must not refer to it.
+			im.aliases = map[string]bool{}
+			for _, b := range doc.Bindings {
+				if b != nil {
+					im.aliases[b.Alias] = true
+				}
+			}
+		}
+		// Bindings come first as the other sections refer to them, and go last,
+		// so what their removal takes along is counted in the sections above.
+		if err := im.bindings(doc.Bindings, &res.Bindings); err != nil {
+			return err
+		}
+		if err := im.groupLinks(doc.GroupLinks, &res.GroupLinks); err != nil {
+			return err
+		}
+		if err := im.jobs(doc.Jobs, &res.Jobs); err != nil {
+			return err
+		}
+		if err := im.tokens(doc.Tokens, &res.Tokens); err != nil {
+			return err
+		}
+		if err := im.aclRules(doc.ACLRules, &res.ACLRules); err != nil {
+			return err
+		}
+		if err := im.removeBindings(res); err != nil {
+			return err
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return res, nil
+}
+
+type importer struct {
+	repo     data.Repo
+	inst     *InstanceService
+	replace  bool
+	profiles config.Profiles
+	aliases  map[string]bool // in replace mode, the bindings that remain; nil for all
+}
+
+// checkAlias rejects an entry of a replace import that refers to a binding the
+// import removes.
+func (im *importer) checkAlias(alias string) error {
+	if im.aliases != nil && !im.aliases[alias] {
+		return fmt.Errorf("binding %s is not in the document, a replace import removes it", alias)
+	}
+	return nil
+}
+
+func (im *importer) bindings(in []*data.Binding, n *ImportCounts) error {
+	existing, err := im.repo.GetAllBindings()
+	if err != nil {
+		return err
+	}
+	byAlias := map[string]*data.Binding{}
+	for _, b := range existing {
+		byAlias[b.Alias] = b
+	}
+	for i, b := range in {
+		if b == nil || b.Alias == "" || len(b.Instances) == 0 {
+			return fmt.Errorf("bindings[%d]: alias and instances required", i)
+		}
+		if err := validateBinding(b); err != nil {
+			return fmt.Errorf("bindings[%d]: %v", i, err)
+		}
+		if _, err := im.profiles.Get(b.Profile); err != nil {
+			return fmt.Errorf("bindings[%d] (%s): %v, add it to profiles in config.yaml first", i, b.Alias, err)
+		}
+		old := byAlias[b.Alias]
+		if old != nil && sameBinding(old, b) {
+			n.Unchanged++
+			continue
+		}
+		if err := im.repo.SaveBinding(b); err != nil {
+			return fmt.Errorf("bindings[%d] (%s): %w", i, b.Alias, err)
+		}
+		if old == nil {
+			n.Created++
+		} else {
+			n.Updated++
+		}
+		byAlias[b.Alias] = b
+	}
+	return nil
+}
+
+// removeBindings deletes, in replace mode, the bindings the document does not
+// list. Their group links and jobs go with them and are counted as removed, which
+// only happens when the document leaves those sections out.
+func (im *importer) removeBindings(res *ImportResult) error {
+	if im.aliases == nil {
+		return nil
+	}
+	existing, err := im.repo.GetAllBindings()
+	if err != nil {
+		return err
+	}
+	links, err := im.repo.GetAllGroupLinks()
+	if err != nil {
+		return err
+	}
+	jobs, err := im.repo.GetAllJobs()
+	if err != nil {
+		return err
+	}
+	for _, b := range existing {
+		if im.aliases[b.Alias] {
+			continue
+		}
+		if err := im.repo.DeleteBinding(b.Alias); err != nil {
+			return err
+		}
+		res.Bindings.Removed++
+		for _, l := range links {
+			if l.Alias == b.Alias {
+				res.GroupLinks.Removed++
+			}
+		}
+		for _, j := range jobs {
+			if j.Alias == b.Alias {
+				res.Jobs.Removed++
+			}
+		}
+	}
+	return nil
+}
+
+func sameBinding(a, b *data.Binding) bool {
+	return a.Description == b.Description && a.Profile == b.Profile && slices.Equal(a.Instances, b.Instances) &&
+		slices.Equal(sortedCopy(a.Tags), sortedCopy(b.Tags))
+}
+
+func (im *importer) groupLinks(in []*data.GroupLink, n *ImportCounts) error {
+	if in == nil {
+		return nil
+	}
+	existing, err := im.repo.GetAllGroupLinks()
+	if err != nil {
+		return err
+	}
+	key := func(l *data.GroupLink) string { return l.GroupID + "\x00" + l.Alias }
+	byKey := map[string]*data.GroupLink{}
+	for _, l := range existing {
+		byKey[key(l)] = l
+	}
+	// Defaults last: linking a default moves it away from the group's other links.
+	links := slices.Clone(in)
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i] != nil && links[j] != nil && !links[i].Default && links[j].Default
+	})
+	keep := map[string]bool{}
+	for i, l := range links {
+		if l == nil {
+			return fmt.Errorf("group_links[%d]: empty entry", i)
+		}
+		if err := im.checkAlias(l.Alias); err != nil {
+			return fmt.Errorf("group link %s -> %s: %w", l.GroupID, l.Alias, err)
+		}
+		keep[key(l)] = true
+		old := byKey[key(l)]
+		if old != nil && old.Default == l.Default {
+			n.Unchanged++
+			continue
+		}
+		nl := *l // LinkGroup marks a group's first link as its default
+		if err := im.repo.LinkGroup(&nl); err != nil {
+			return fmt.Errorf("group link %s -> %s: %w", l.GroupID, l.Alias, err)
+		}
+		if old == nil {
+			n.Created++
+		} else {
+			n.Updated++
+		}
+	}
+	if im.replace {
+		for k, l := range byKey {
+			if !keep[k] {
+				if err := im.repo.UnlinkGroup(l.GroupID, l.Alias); err != nil {
+					return err
+				}
+				n.Removed++
+			}
+		}
+	}
+	return nil
+}
+
+func (im *importer) jobs(in []*data.Job, n *ImportCounts) error {
+	if in == nil {
+		return nil
+	}
+	existing, err := im.repo.GetAllJobs()
+	if err != nil {
+		return err
+	}
+	key := func(j *data.Job) string {
+		return strings.Join([]string{j.Name, j.Spec, j.Kind, j.Action, j.Alias, j.Role}, "\x00")
+	}
+	byKey := map[string]*data.Job{}
+	for _, j := range existing {
+		byKey[key(j)] = j
+	}
+	keep := map[int64]bool{}
+	for i, j := range in {
+		if j == nil {
+			return fmt.Errorf("jobs[%d]: empty entry", i)
+		}
+		err := validateJob(im.inst, j)
+		if err == nil {
+			err = im.checkAlias(j.Alias)
+		}
+		if err != nil {
+			return fmt.Errorf("jobs[%d] (%s %s on %s): %w", i, j.Kind, j.Action, j.Alias, err)
+		}
+		if old := byKey[key(j)]; old != nil {
+			keep[old.ID] = true
+			if old.Paused == j.Paused {
+				n.Unchanged++
+				continue
+			}
+			if err := im.repo.SetJobPaused(old.ID, j.Paused); err != nil {
+				return err
+			}
+			old.Paused = j.Paused
+			n.Updated++
+			continue
+		}
+		nj := &data.Job{Name: j.Name, Spec: j.Spec, Kind: j.Kind, Action: j.Action, Alias: j.Alias, Role: j.Role,
+			Paused: j.Paused, CreatedAt: j.CreatedAt}
+		if err := im.repo.SaveJob(nj); err != nil {
+			return fmt.Errorf("jobs[%d]: %w", i, err)
+		}
+		keep[nj.ID] = true
+		byKey[key(nj)] = nj
+		n.Created++
+	}
+	if im.replace {
+		for _, j := range existing {
+			if !keep[j.ID] {
+				if err := im.repo.DeleteJob(j.ID); err != nil {
+					return err
+				}
+				n.Removed++
+			}
+		}
+	}
+	return nil
+}
+
+func (im *importer) tokens(in []*ExportedToken, n *ImportCounts) error {
+	if in == nil {
+		return nil
+	}
+	existing, err := im.repo.GetAllTokens()
+	if err != nil {
+		return err
+	}
+	byID := map[string]*data.Token{}
+	for _, t := range existing {
+		byID[t.ID] = t
+	}
+	keep := map[string]bool{}
+	for i, et := range in {
+		if et == nil || et.ID == "" {
+			return fmt.Errorf("tokens[%d]: id required", i)
+		}
+		if !tokenhash.Valid(et.SecretHash) {
+			return fmt.Errorf("tokens[%d] (%s): secret_hash missing or malformed", i, et.ID)
+		}
+		if err := validateTokenLimits(et.Scopes, et.Aliases, et.Tags); err != nil {
+			return fmt.Errorf("tokens[%d] (%s): %w", i, et.ID, err)
+		}
+		keep[et.ID] = true
+		t := et.Token
+		t.SecretHash = et.SecretHash
+		t.RevokedAt = nil
+		old := byID[t.ID]
+		if old != nil && old.Name == t.Name && old.SecretHash == t.SecretHash && slices.Equal(old.Scopes, t.Scopes) &&
+			slices.Equal(old.Aliases, t.Aliases) && slices.Equal(old.Tags, t.Tags) {
+			n.Unchanged++
+			continue
+		}
+		if err := im.repo.SaveToken(&t); err != nil {
+			return fmt.Errorf("tokens[%d] (%s): %w", i, t.ID, err)
+		}
+		if old == nil {
+			n.Created++
+		} else {
+			n.Updated++
+		}
+	}
+	if im.replace {
+		now := time.Now()
+		for _, t := range existing {
+			if !keep[t.ID] && t.RevokedAt == nil {
+				if err := im.repo.RevokeToken(t.ID, now); err != nil {
+					return err
+				}
+				n.Removed++
+			}
+		}
+	}
+	return nil
+}
+
+func (im *importer) aclRules(in []*data.ACLRule, n *ImportCounts) error {
+	if in == nil {
+		return nil
+	}
+	existing, err := im.repo.GetAllACLRules()
+	if err != nil {
+		return err
+	}
+	key := func(r *data.ACLRule) string {
+		return strings.Join([]string{r.Platform, r.GroupID, r.UserID, strings.Join(r.Aliases, ","),
+			strings.Join(r.Actions, ","), r.MinRole}, "\x00")
+	}
+	byKey := map[string]*data.ACLRule{}
+	for _, r := range existing {
+		byKey[key(r)] = r
+	}
+	keep := map[int64]bool{}
+	for i, r := range in {
+		if r == nil || len(r.Actions) == 0 {
+			return fmt.Errorf("acl_rules[%d]: actions required", i)
+		}
+		if err := validateACLRule(r); err != nil {
+			return fmt.Errorf("acl_rules[%d]: %w", i, err)
+		}
+		if old := byKey[key(r)]; old != nil {
+			keep[old.ID] = true
+			n.Unchanged++
+			continue
+		}
+		nr := *r
+		nr.ID = 0
+		if err := im.repo.SaveACLRule(&nr); err != nil {
+			return fmt.Errorf("acl_rules[%d]: %w", i, err)
+		}
+		keep[nr.ID] = true
+		byKey[key(&nr)] = &nr
+		n.Created++
+	}
+	if im.replace {
+		for _, r := range existing {
+			if !keep[r.ID] {
+				if err := im.repo.DeleteACLRule(r.ID); err != nil {
+					return err
+				}
+				n.Removed++
+			}
+		}
+	}
+	return nil
+}
+
+func sortedCopy(s []string) []string {
+	c := slices.Clone(s)
+	slices.Sort(c)
+	return c
+}
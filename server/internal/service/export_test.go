@@ -0,0 +1,264 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+)
+
+var testProfiles = config.Profiles{
+	config.DefaultProfile: {QRCodePath: "qrcode.png", QRCodeTimeout: time.Minute, ConfirmTimeout: 3 * time.Minute},
+	"napcat":              {QRCodePath: "cache/qrcode.png", QRCodeTimeout: 2 * time.Minute, ConfirmTimeout: 3 * time.Minute},
+}
+
+// seedExport fills repo with one of everything an export holds.
+func seedExport(t *testing.T, repo *data.SQLiteRepo) {
+	t.Helper()
+	bind(t, repo, "bot-a", "main")
+	bind(t, repo, "bot-b")
+	b, err := repo.GetBinding("bot-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Profile, b.Description = "napcat", "second"
+	must(t, repo.SaveBinding(b))
+	must(t, repo.LinkGroup(&data.GroupLink{GroupID: "g1", Alias: "bot-a", Default: true}))
+	must(t, repo.LinkGroup(&data.GroupLink{GroupID: "g1", Alias: "bot-b"}))
+	must(t, repo.SaveJob(&data.Job{Name: "nightly", Spec: "0 4 * * *", Kind: data.JobInstanceAction, Action: "restart", Alias: "bot-a"}))
+	must(t, repo.SaveJob(&data.Job{Spec: "@hourly", Kind: data.JobCommand, Action: "save", Alias: "bot-b", Role: RoleCore, Paused: true}))
+	if _, _, err := NewTokenService(repo, &config.Config{}).Issue("ops", []string{ScopeRead}, []string{"bot-*"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	must(t, repo.SaveACLRule(&data.ACLRule{GroupID: "g1", Actions: []string{ScopeRead}, Aliases: []string{"bot-a"}}))
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// contents describes what a repo holds, without ids and timestamps, for comparing two of them.
+func contents(t *testing.T, repo data.Repo) []string {
+	t.Helper()
+	doc, err := ExportAll(repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, b := range doc.Bindings {
+		out = append(out, fmt.Sprintf("binding %s %v %q %q %v", b.Alias, b.Instances, b.Description, b.Profile, b.Tags))
+	}
+	for _, l := range doc.GroupLinks {
+		out = append(out, fmt.Sprintf("link %s %s %v", l.GroupID, l.Alias, l.Default))
+	}
+	for _, j := range doc.Jobs {
+		out = append(out, fmt.Sprintf("job %q %s %s %s %s %s %v", j.Name, j.Spec, j.Kind, j.Action, j.Alias, j.Role, j.Paused))
+	}
+	for _, tk := range doc.Tokens {
+		out = append(out, fmt.Sprintf("token %s %s %s %v %v", tk.ID, tk.Name, tk.SecretHash, tk.Scopes, tk.Aliases))
+	}
+	for _, r := range doc.ACLRules {
+		out = append(out, fmt.Sprintf("acl %s %s %s %v %v %s", r.Platform, r.GroupID, r.UserID, r.Aliases, r.Actions, r.MinRole))
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newTestRepo(t)
+	seedExport(t, src)
+	doc, err := ExportAll(src, testProfiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		for _, mode := range []string{ImportMerge, ImportReplace} {
+			t.Run(format+"/"+mode, func(t *testing.T) {
+				raw, err := MarshalExport(doc, format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				parsed, err := ParseExport(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				dst := newTestRepo(t)
+				opts := ImportOptions{Mode: mode, Profiles: testProfiles}
+				res, err := Import(dst, parsed, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := ImportCounts{Created: 2}
+				if res.Bindings != want || res.GroupLinks != want || res.Jobs != want {
+					t.Errorf("first import = %+v", res)
+				}
+				if len(res.ProfilesDiffer) != 0 {
+					t.Errorf("profiles differ: %v", res.ProfilesDiffer)
+				}
+				if got, want := contents(t, dst), contents(t, src); !reflect.DeepEqual(got, want) {
+					t.Errorf("imported:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+				}
+
+				res, err = Import(dst, parsed, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for name, n := range map[string]ImportCounts{"bindings": res.Bindings, "group_links": res.GroupLinks,
+					"jobs": res.Jobs, "tokens": res.Tokens, "acl_rules": res.ACLRules} {
+					if n.Created+n.Updated+n.Removed != 0 {
+						t.Errorf("second import changed %s: %+v", name, n)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestImportModes(t *testing.T) {
+	src := newTestRepo(t)
+	seedExport(t, src)
+	doc, err := ExportAll(src, testProfiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sections left out of a document are not touched, except by binding removals.
+	bindingsOnly := &Export{Version: ExportVersion, Bindings: doc.Bindings}
+	orphanJob := *doc
+	orphanJob.Jobs = append(slices.Clone(doc.Jobs), &data.Job{Spec: "@daily", Kind: data.JobInstanceAction, Action: "stop", Alias: "extra"})
+	unknownProfile := *doc
+	unknownProfile.Bindings = []*data.Binding{{Alias: "bot-c", Profile: "gone",
+		Instances: []data.BindingInstance{{Role: RoleCore, InstanceID: "c"}}}}
+	otherVersion := *doc
+	otherVersion.Version = ExportVersion + 1
+
+	tests := []struct {
+		name    string
+		doc     *Export
+		opts    ImportOptions
+		wantErr string
+		check   func(t *testing.T, res *ImportResult, repo *data.SQLiteRepo)
+	}{
+		{
+			name: "merge keeps extras",
+			doc:  doc,
+			opts: ImportOptions{Mode: ImportMerge},
+			check: func(t *testing.T, res *ImportResult, repo *data.SQLiteRepo) {
+				if res.Bindings.Removed+res.Jobs.Removed+res.GroupLinks.Removed != 0 {
+					t.Errorf("merge removed: %+v", res)
+				}
+				if _, err := repo.GetBinding("extra"); err != nil {
+					t.Error(err)
+				}
+			},
+		},
+		{
+			name: "replace removes extras",
+			doc:  doc,
+			opts: ImportOptions{Mode: ImportReplace},
+			check: func(t *testing.T, res *ImportResult, repo *data.SQLiteRepo) {
+				if res.Bindings.Removed != 1 || res.Jobs.Removed != 1 || res.GroupLinks.Removed != 1 || res.Tokens.Removed != 1 {
+					t.Errorf("replace result = %+v", res)
+				}
+				if got, want := contents(t, repo), contents(t, src); !reflect.DeepEqual(got, want) {
+					t.Errorf("after replace:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+				}
+			},
+		},
+		{
+			name: "replace counts what removed bindings take along",
+			doc:  bindingsOnly,
+			opts: ImportOptions{Mode: ImportReplace},
+			check: func(t *testing.T, res *ImportResult, repo *data.SQLiteRepo) {
+				if res.Bindings.Removed != 1 || res.Jobs.Removed != 1 || res.GroupLinks.Removed != 1 || res.Tokens.Removed != 0 {
+					t.Errorf("replace result = %+v", res)
+				}
+				jobs, err := repo.GetAllJobs()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, j := range jobs {
+					if j.Alias == "extra" {
+						t.Errorf("job %d of removed binding kept", j.ID)
+					}
+				}
+			},
+		},
+		{
+			name: "dry run changes nothing",
+			doc:  doc,
+			opts: ImportOptions{Mode: ImportReplace, DryRun: true},
+			check: func(t *testing.T, res *ImportResult, repo *data.SQLiteRepo) {
+				if res.Bindings.Removed != 1 {
+					t.Errorf("dry run result = %+v", res)
+				}
+				if _, err := repo.GetBinding("extra"); err != nil {
+					t.Error(err)
+				}
+			},
+		},
+		{name: "replace rejects jobs of removed bindings", doc: &orphanJob, opts: ImportOptions{Mode: ImportReplace},
+			wantErr: "binding extra is not in the document"},
+		{name: "unknown profile", doc: &unknownProfile, opts: ImportOptions{Mode: ImportMerge}, wantErr: `unknown profile "gone"`},
+		{name: "other version", doc: &otherVersion, opts: ImportOptions{Mode: ImportMerge}, wantErr: "unsupported export version"},
+		{name: "unknown mode", doc: doc, opts: ImportOptions{Mode: "upsert"}, wantErr: "unknown import mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := newTestRepo(t)
+			// Something the document does not hold, with a link, a job and a token.
+			bind(t, dst, "extra")
+			must(t, dst.LinkGroup(&data.GroupLink{GroupID: "g9", Alias: "extra"}))
+			must(t, dst.SaveJob(&data.Job{Spec: "@daily", Kind: data.JobInstanceAction, Action: "start", Alias: "extra"}))
+			if _, _, err := NewTokenService(dst, &config.Config{}).Issue("local", []string{ScopeRead}, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			before := contents(t, dst)
+
+			tt.opts.Profiles = testProfiles
+			res, err := Import(dst, tt.doc, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Import error = %v, want %q", err, tt.wantErr)
+				}
+				if got := contents(t, dst); !reflect.DeepEqual(got, before) {
+					t.Errorf("failed import changed the repo:\n%s", strings.Join(got, "\n"))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, res, dst)
+		})
+	}
+}
+
+func TestImportProfilesDiffer(t *testing.T) {
+	doc := &Export{Version: ExportVersion, Profiles: map[string]*ExportedProfile{
+		"default":  exportProfile(testProfiles["default"]),
+		"napcat":   {QRCodePath: "other.png", QRCodeTimeout: "2m", ConfirmTimeout: "3m"},
+		"llonebot": {QRCodePath: "qr.png", QRCodeTimeout: "1m", ConfirmTimeout: "1m"},
+	}}
+	res, err := Import(newTestRepo(t), doc, ImportOptions{Profiles: testProfiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"llonebot", "napcat"}; !reflect.DeepEqual(res.ProfilesDiffer, want) {
+		t.Errorf("ProfilesDiffer = %v, want %v", res.ProfilesDiffer, want)
+	}
+
+	doc.Profiles["napcat"].QRCodeTimeout = "soon"
+	if _, err := Import(newTestRepo(t), doc, ImportOptions{Profiles: testProfiles}); err == nil {
+		t.Error("malformed profile duration accepted")
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"sealdice-mcsm/server/internal/data"
+)
+
+// GroupService keeps which chat groups own which bindings.
+type GroupService struct {
+	repo data.GroupRepo
+}
+
+func NewGroupService(repo data.GroupRepo) *GroupService {
+	return &GroupService{repo: repo}
+}
+
+func (s *GroupService) Link(groupID, alias string, makeDefault bool) error {
+	return s.repo.LinkGroup(&data.GroupLink{GroupID: groupID, Alias: alias, Default: makeDefault})
+}
+
+func (s *GroupService) Unlink(groupID, alias string) error {
+	return s.repo.UnlinkGroup(groupID, alias)
+}
+
+// Links returns the links of groupID, or of every group when it is empty.
+func (s *GroupService) Links(groupID string) ([]*data.GroupLink, error) {
+	if groupID == "" {
+		return s.repo.GetAllGroupLinks()
+	}
+	return s.repo.GetGroupLinks(groupID)
+}
+
+// DefaultAlias returns the alias commands in groupID fall back to, "" if the group owns none.
+func (s *GroupService) DefaultAlias(groupID string) (string, error) {
+	links, err := s.repo.GetGroupLinks(groupID)
+	if err != nil || len(links) == 0 {
+		return "", err
+	}
+	return links[0].Alias, nil // default first
+}
+
+// Groups returns the groups linked to alias, where its events are delivered.
+func (s *GroupService) Groups(alias string) []string {
+	links, err := s.repo.GetAliasGroups(alias)
+	if err != nil {
+		return nil
+	}
+	out := make([]string, 0, len(links))
+	for _, l := range links {
+		out = append(out, l.GroupID)
+	}
+	return out
+}
@@ -1,6 +1,9 @@
 package service
 
 import (
+	"fmt"
+	"strings"
+
 	"sealdice-mcsm/server/internal/data"
 )
 
@@ -12,14 +15,83 @@ func NewInstanceService(repo data.BindingRepo) *InstanceService {
 	return &InstanceService{repo: repo}
 }
 
-func (s *InstanceService) Bind(alias, protocolID, coreID string) error {
-	return s.repo.SaveBinding(&data.Binding{
-		Alias:              alias,
-		ProtocolInstanceID: protocolID,
-		CoreInstanceID:     coreID,
-	})
+// Bind stores a binding. instances are in start order (e.g. protocol before core);
+// profile names its protocol profile, empty for the default one.
+func (s *InstanceService) Bind(alias string, instances []data.BindingInstance, description, profile string, tags []string) error {
+	b := &data.Binding{
+		Alias:       alias,
+		Instances:   instances,
+		Description: description,
+		Profile:     profile,
+		Tags:        tags,
+	}
+	if err := validateBinding(b); err != nil {
+		return err
+	}
+	return s.repo.SaveBinding(b)
+}
+
+// validateBinding checks what the repo cannot know about: an alias must not read as
+// a selector, or bulk actions could never address it alone, and roles must be unique
+// and not the reserved "both".
+func validateBinding(b *data.Binding) error {
+	if IsSelector(b.Alias) {
+		return fmt.Errorf("alias %q reads as a selector, avoid \"all\", \"tag:\" and * ? [ ,", b.Alias)
+	}
+	seen := make(map[string]bool, len(b.Instances))
+	for _, inst := range b.Instances {
+		if inst.Role == RoleBoth {
+			return fmt.Errorf("role name %q is reserved", inst.Role)
+		}
+		if seen[inst.Role] {
+			return fmt.Errorf("duplicate role %s", inst.Role)
+		}
+		seen[inst.Role] = true
+	}
+	return nil
+}
+
+// ResolveInstance returns the instance ID bound to alias under role.
+func (s *InstanceService) ResolveInstance(alias, role string) (string, error) {
+	b, err := s.repo.GetBinding(alias)
+	if err != nil {
+		return "", err
+	}
+	inst, ok := b.Instance(role)
+	if !ok {
+		return "", fmt.Errorf("role %q not in binding %s (roles: %s)", role, alias, strings.Join(b.Roles(), ", "))
+	}
+	return inst.InstanceID, nil
+}
+
+// RoleOrDefault returns role, or when it is empty defaultRole, falling back to the
+// binding's first role when it has no defaultRole.
+func RoleOrDefault(b *data.Binding, role, defaultRole string) string {
+	if role != "" {
+		return role
+	}
+	if _, ok := b.Instance(defaultRole); !ok && defaultRole != "" && defaultRole != RoleBoth && len(b.Instances) > 0 {
+		return b.Instances[0].Role
+	}
+	return defaultRole
 }
 
+// UpdateMeta changes the description and/or tags of an existing binding; nil leaves a field untouched.
+func (s *InstanceService) UpdateMeta(alias string, description *string, tags []string) (*data.Binding, error) {
+	b, err := s.repo.GetBinding(alias)
+	if err != nil {
+		return nil, err
+	}
+	if description != nil {
+		b.Description = *description
+	}
+	if tags != nil {
+		b.Tags = tags
+	}
+	return b, s.repo.SaveBinding(b)
+}
+
+// Unbind deletes a binding with its group links and scheduled jobs.
 func (s *InstanceService) Unbind(alias string) error {
 	return s.repo.DeleteBinding(alias)
 }
@@ -31,3 +103,40 @@ func (s *InstanceService) GetByAlias(alias string) (*data.Binding, error) {
 func (s *InstanceService) GetAll() ([]*data.Binding, error) {
 	return s.repo.GetAllBindings()
 }
+
+func (s *InstanceService) GetByTag(tag string) ([]*data.Binding, error) {
+	return s.repo.GetBindingsByTag(tag)
+}
+
+// ParseTags splits a comma separated tag list, dropping blanks and duplicates.
+func ParseTags(raw string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// ParseInstances parses an ordered "role=uuid,role=uuid" list.
+func ParseInstances(raw string) ([]data.BindingInstance, error) {
+	var out []data.BindingInstance
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		role, id, ok := strings.Cut(part, "=")
+		role, id = strings.TrimSpace(role), strings.TrimSpace(id)
+		if !ok || role == "" || id == "" {
+			return nil, fmt.Errorf("invalid instance entry %q, want role=uuid", part)
+		}
+		out = append(out, data.BindingInstance{Role: role, InstanceID: id})
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"sealdice-mcsm/server/internal/data"
+)
+
+func TestBindValidates(t *testing.T) {
+	two := []data.BindingInstance{{Role: RoleProtocol, InstanceID: "p"}, {Role: RoleCore, InstanceID: "c"}}
+	tests := []struct {
+		name      string
+		alias     string
+		instances []data.BindingInstance
+		wantErr   bool
+	}{
+		{name: "plain alias", alias: "bot-a", instances: two},
+		{name: "all", alias: "all", instances: two, wantErr: true},
+		{name: "tag selector", alias: "tag:x", instances: two, wantErr: true},
+		{name: "glob", alias: "bot-*", instances: two, wantErr: true},
+		{name: "list", alias: "a,b", instances: two, wantErr: true},
+		{name: "single-char glob", alias: "x?", instances: two, wantErr: true},
+		{name: "single role", alias: "solo", instances: two[1:]},
+		{name: "reserved role", alias: "bot-b", instances: []data.BindingInstance{{Role: RoleBoth, InstanceID: "x"}}, wantErr: true},
+		{name: "duplicate role", alias: "bot-c", instances: append([]data.BindingInstance{{Role: RoleCore, InstanceID: "x"}}, two...), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepo(t)
+			err := NewInstanceService(repo).Bind(tt.alias, tt.instances, "", "", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Bind(%q) error = %v, want error %v", tt.alias, err, tt.wantErr)
+			}
+			_, err = repo.GetBinding(tt.alias)
+			if stored := err == nil; stored == tt.wantErr {
+				t.Errorf("binding stored = %v after Bind error %v", stored, tt.wantErr)
+			}
+
+			// Imports go through the same check.
+			doc := &Export{Version: ExportVersion, Bindings: []*data.Binding{{Alias: tt.alias, Instances: tt.instances}}}
+			if _, err := Import(newTestRepo(t), doc, ImportOptions{Profiles: testProfiles}); (err != nil) != tt.wantErr {
+				t.Errorf("Import(%q) error = %v, want error %v", tt.alias, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/metrics"
+	"sealdice-mcsm/server/pkg/mcsm"
+)
+
+// MonitorService polls the status of every bound instance and feeds the
+// instance status gauges on /metrics.
+type MonitorService struct {
+	InstanceSvc *InstanceService
+	MCSM        *mcsm.Client
+	Log         *slog.Logger
+
+	mu       sync.Mutex // guards interval, stopped, stop and done
+	interval time.Duration
+	stopped  bool // Stop was called, a reload must not restart polling
+	stop     chan struct{}
+	done     chan struct{}
+	seen     map[[3]string]bool // label sets set by the last poll, owned by the poll loop
+}
+
+func NewMonitorService(instSvc *InstanceService, mcsm *mcsm.Client, cfg *config.Config) *MonitorService {
+	return &MonitorService{InstanceSvc: instSvc, MCSM: mcsm, interval: cfg.Monitor.Interval, Log: slog.Default(), seen: map[[3]string]bool{}}
+}
+
+// Poll queries each bound instance once. Gauges of instances no longer bound are dropped.
+func (s *MonitorService) Poll() {
+	bindings, err := s.InstanceSvc.GetAll()
+	if err != nil {
+		s.Log.Error("monitor: list bindings", "err", err)
+		return
+	}
+	seen := map[[3]string]bool{}
+	for _, b := range bindings {
+		for _, inst := range b.Instances {
+			labels := [3]string{b.Alias, inst.Role, inst.InstanceID}
+			seen[labels] = true
+			detail, err := s.MCSM.InstanceDetail(inst.InstanceID, "local")
+			if err != nil {
+				s.Log.Debug("monitor: status poll failed", "alias", b.Alias, "role", inst.Role, "instance_id", inst.InstanceID, "err", err)
+				metrics.InstancePollUp.WithLabelValues(labels[:]...).Set(0)
+				continue
+			}
+			metrics.InstancePollUp.WithLabelValues(labels[:]...).Set(1)
+			metrics.InstanceStatus.WithLabelValues(labels[:]...).Set(float64(detail.Data.Status))
+		}
+	}
+	for labels := range s.seen {
+		if !seen[labels] {
+			metrics.InstancePollUp.DeleteLabelValues(labels[:]...)
+			metrics.InstanceStatus.DeleteLabelValues(labels[:]...)
+		}
+	}
+	s.seen = seen
+}
+
+// Start polls every monitor.interval until Stop; a zero interval disables polling.
+func (s *MonitorService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+}
+
+func (s *MonitorService) start() {
+	if s.interval <= 0 || s.stop != nil || s.stopped {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.stop, s.done = stop, done
+	interval := s.interval
+	go func() {
+		defer close(done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			s.Poll()
+			select {
+			case <-t.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts polling and waits for a poll in progress to finish.
+func (s *MonitorService) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	s.halt()
+}
+
+func (s *MonitorService) halt() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop, s.done = nil, nil
+	}
+}
+
+// SetInterval changes the polling interval, restarting the loop if it was running
+// or starting it if it was disabled. Zero stops polling. It does nothing after Stop.
+func (s *MonitorService) SetInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.halt()
+	s.interval = d
+	s.start()
+}
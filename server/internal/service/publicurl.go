@@ -0,0 +1,97 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLinkInvalid = errors.New("invalid link signature")
+	ErrLinkExpired = errors.New("link expired or used up")
+)
+
+// URLSigner signs /public links with an HMAC over the file name and expiry, and counts uses
+// so a leaked link stops working after MaxUses fetches or TTL, whichever comes first.
+type URLSigner struct {
+	key     []byte
+	TTL     time.Duration
+	MaxUses int // 0 = unlimited until expiry
+
+	mu   sync.Mutex
+	uses map[string]int // signature -> fetches so far
+	exps map[string]time.Time
+}
+
+// NewURLSigner uses secret as the HMAC key; an empty secret gets a random per-process key,
+// so links do not survive a restart (the files they point to are temporary anyway).
+func NewURLSigner(secret string, ttl time.Duration, maxUses int) *URLSigner {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &URLSigner{key: key, TTL: ttl, MaxUses: maxUses, uses: map[string]int{}, exps: map[string]time.Time{}}
+}
+
+func (s *URLSigner) mac(name string, exp int64) string {
+	m := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(m, "%s|%d", name, exp)
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// Sign returns the query string ("exp=...&sig=...") authorizing fetches of name.
+func (s *URLSigner) Sign(name string) string {
+	exp := time.Now().Add(s.TTL).Unix()
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", s.mac(name, exp))
+	return q.Encode()
+}
+
+// Check verifies a fetch of name with the given exp and sig params without counting it.
+func (s *URLSigner) Check(name, exp, sig string) error {
+	return s.verify(name, exp, sig, false)
+}
+
+// Use verifies a fetch of name with the given exp and sig params and counts it.
+// Call it once the file is known to be served, so failed fetches do not use up the link.
+func (s *URLSigner) Use(name, exp, sig string) error {
+	return s.verify(name, exp, sig, true)
+}
+
+func (s *URLSigner) verify(name, exp, sig string, count bool) error {
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(s.mac(name, expUnix))) {
+		return ErrLinkInvalid
+	}
+	now := time.Now()
+	expiry := time.Unix(expUnix, 0)
+	if now.After(expiry) {
+		return ErrLinkExpired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.exps { // forget links that expired on their own
+		if now.After(e) {
+			delete(s.exps, k)
+			delete(s.uses, k)
+		}
+	}
+	if s.MaxUses > 0 && s.uses[sig] >= s.MaxUses {
+		return ErrLinkExpired
+	}
+	if count {
+		s.uses[sig]++
+		s.exps[sig] = expiry
+	}
+	return nil
+}
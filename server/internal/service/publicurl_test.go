@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	s := NewURLSigner("secret", time.Minute, 2)
+	q, err := url.ParseQuery(s.Sign("a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, sig := q.Get("exp"), q.Get("sig")
+	if e, _ := strconv.ParseInt(exp, 10, 64); time.Until(time.Unix(e, 0)) > time.Minute {
+		t.Errorf("exp %s is more than the TTL away", exp)
+	}
+	past := time.Now().Add(-time.Second).Unix()
+	tampered := sig[:len(sig)-1] + "A"
+	if tampered == sig {
+		tampered = sig[:len(sig)-1] + "B"
+	}
+
+	tests := []struct {
+		name           string
+		signer         *URLSigner
+		file, exp, sig string
+		want           error
+	}{
+		{name: "valid", signer: s, file: "a.png", exp: exp, sig: sig},
+		{name: "other file", signer: s, file: "b.png", exp: exp, sig: sig, want: ErrLinkInvalid},
+		{name: "exp moved", signer: s, file: "a.png", exp: exp + "0", sig: sig, want: ErrLinkInvalid},
+		{name: "exp not a number", signer: s, file: "a.png", exp: "soon", sig: sig, want: ErrLinkInvalid},
+		{name: "tampered sig", signer: s, file: "a.png", exp: exp, sig: tampered, want: ErrLinkInvalid},
+		{name: "other key", signer: NewURLSigner("other", time.Minute, 0), file: "a.png", exp: exp, sig: sig, want: ErrLinkInvalid},
+		{name: "expired", signer: s, file: "a.png", exp: strconv.FormatInt(past, 10), sig: s.mac("a.png", past), want: ErrLinkExpired},
+		{name: "second use", signer: s, file: "a.png", exp: exp, sig: sig},
+		{name: "used up", signer: s, file: "a.png", exp: exp, sig: sig, want: ErrLinkExpired},
+	}
+	for _, tt := range tests {
+		// Check alone never uses up a link.
+		if err := tt.signer.Check(tt.file, tt.exp, tt.sig); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s: Check = %v, want %v", tt.name, err, tt.want)
+		}
+		err := tt.signer.Use(tt.file, tt.exp, tt.sig)
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s: Use = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Without MaxUses a link works until it expires.
+	u := NewURLSigner("", time.Minute, 0)
+	q, _ = url.ParseQuery(u.Sign("c.png"))
+	for i := 0; i < 5; i++ {
+		if err := u.Use("c.png", q.Get("exp"), q.Get("sig")); err != nil {
+			t.Fatalf("use %d: %v", i+1, err)
+		}
+	}
+}
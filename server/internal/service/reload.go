@@ -0,0 +1,93 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/logging"
+)
+
+// ConfigReloaded is the payload of the config_reloaded event. It names the
+// changed keys, never their values: those include credentials.
+type ConfigReloaded struct {
+	Applied         []string `json:"applied" doc:"Changed keys now in effect"`
+	RestartRequired []string `json:"restart_required,omitempty" doc:"Changed keys that only take effect after a restart"`
+}
+
+// reloadable are the keys Reload applies to the running server.
+var reloadable = map[string]bool{
+	"mcsm.url":               true,
+	"mcsm.apikey":            true,
+	"auth.enable":            true,
+	"auth.token":             true,
+	"auth.allow_query_token": true,
+	"monitor.interval":       true,
+	"profiles":               true,
+	"log.level":              true,
+}
+
+// Reload validates next and applies its reloadable settings: the MCSM URL and
+// API key, the auth section, monitor.interval, the protocol profiles and log.level. Other changed keys
+// are reported but keep their running values. An invalid config is rejected as a
+// whole. It returns nil when nothing changed since the last load.
+func (s *Service) Reload(next *config.Config) (*ConfigReloaded, error) {
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	prev := s.loaded
+	if prev == nil {
+		prev = s.Cfg
+	}
+	changed := diffConfig(prev, next)
+	s.loaded = next
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	ev := &ConfigReloaded{Applied: []string{}}
+	for _, key := range changed {
+		if reloadable[key] {
+			ev.Applied = append(ev.Applied, key)
+		} else {
+			ev.RestartRequired = append(ev.RestartRequired, key)
+		}
+	}
+	s.MCSM.SetCredentials(next.MCSM.URL, next.MCSM.APIKey)
+	s.TokenSvc.SetAuth(next.Auth)
+	if next.Monitor.Interval != prev.Monitor.Interval {
+		s.MonitorSvc.SetInterval(next.Monitor.Interval)
+	}
+	s.WorkflowSvc.SetProfiles(next.Profiles)
+	if s.LogLevel != nil {
+		level, _ := logging.ParseLevel(next.Log.Level) // checked by Validate
+		s.LogLevel.Set(level)
+	}
+	s.Log.Info("config reloaded", "applied", ev.Applied, "restart_required", ev.RestartRequired)
+	return ev, nil
+}
+
+// diffConfig lists the config keys whose values differ, in field order.
+func diffConfig(a, b *config.Config) []string {
+	var keys []string
+	var walk func(prefix string, x, y reflect.Value)
+	walk = func(prefix string, x, y reflect.Value) {
+		if x.Kind() != reflect.Struct {
+			if !reflect.DeepEqual(x.Interface(), y.Interface()) {
+				keys = append(keys, prefix)
+			}
+			return
+		}
+		for i := 0; i < x.NumField(); i++ {
+			name, _, _ := strings.Cut(x.Type().Field(i).Tag.Get("mapstructure"), ",")
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			walk(name, x.Field(i), y.Field(i))
+		}
+	}
+	walk("", reflect.ValueOf(*a), reflect.ValueOf(*b))
+	return keys
+}
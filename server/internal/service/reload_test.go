@@ -0,0 +1,130 @@
+package service
+
+import (
+	"io"
+	"log/slog"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/pkg/mcsm"
+)
+
+// testConfig returns a config that passes Validate, with temp files kept in memory.
+func testConfig() *config.Config {
+	c := &config.Config{}
+	c.Server.Port, c.Server.ShutdownTimeout = ":8088", 30*time.Second
+	c.MCSM.URL, c.MCSM.APIKey = "http://127.0.0.1:1", "key"
+	c.Public.URLTTL, c.Public.FileTTL, c.Public.Store = time.Minute, time.Minute, "memory"
+	c.Log.Level, c.Log.Format = "info", "text"
+	c.Profiles = maps.Clone(testProfiles)
+	c.DBPath = "data.db"
+	return c
+}
+
+func TestDiffConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *config.Config)
+		want   []string
+	}{
+		{"nothing", func(c *config.Config) {}, nil},
+		{"one field", func(c *config.Config) { c.MCSM.URL = "http://127.0.0.1:2" }, []string{"mcsm.url"}},
+		{"nested field", func(c *config.Config) { c.Public.S3.Bucket = "b" }, []string{"public.s3.bucket"}},
+		{"slice", func(c *config.Config) { c.ACL.AdminActions = []string{"stop"} }, []string{"acl.admin_actions"}},
+		{"profiles as a whole", func(c *config.Config) {
+			p := c.Profiles["napcat"]
+			p.QRCodeTimeout = time.Hour
+			c.Profiles["napcat"] = p
+		}, []string{"profiles"}},
+		{"field order", func(c *config.Config) {
+			c.DBPath = "other.db"
+			c.Log.Level = "debug"
+			c.Auth.Token = "t"
+			c.Server.Port = ":9000"
+		}, []string{"server.port", "auth.token", "log.level", "db_path"}},
+	}
+	for _, tt := range tests {
+		prev, next := testConfig(), testConfig()
+		tt.change(next)
+		if got := diffConfig(prev, next); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: diffConfig = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	cfg := testConfig()
+	client := mcsm.NewClient(cfg.MCSM.URL, cfg.MCSM.APIKey)
+	s, err := NewService(cfg, newTestRepo(t), client, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.LogLevel = new(slog.LevelVar)
+	t.Cleanup(s.MonitorSvc.Stop)
+	fake, _ := newFakeMCSM(t)
+
+	// An invalid file changes nothing.
+	bad := testConfig()
+	bad.MCSM.URL, bad.Server.Port = fake.URL, "nope"
+	if ev, err := s.Reload(bad); err == nil {
+		t.Fatalf("invalid config reloaded: %+v", ev)
+	}
+	if ev, err := s.Reload(testConfig()); ev != nil || err != nil {
+		t.Fatalf("unchanged config: %+v, %v", ev, err)
+	}
+
+	next := testConfig()
+	next.Server.Port = ":9000"
+	next.MCSM.URL = fake.URL
+	next.Auth = config.Auth{Enable: true, Token: "rotated"}
+	next.Public.MaxUses = 7
+	next.Log.Level = "debug"
+	next.Monitor.Interval = time.Hour
+	next.Profiles["llonebot"] = config.Profile{QRCodePath: "qr.png", QRCodeTimeout: time.Minute, ConfirmTimeout: time.Minute}
+
+	ev, err := s.Reload(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ConfigReloaded{
+		Applied:         []string{"auth.enable", "auth.token", "mcsm.url", "log.level", "monitor.interval", "profiles"},
+		RestartRequired: []string{"server.port", "public.max_uses"},
+	}
+	if !reflect.DeepEqual(ev, want) {
+		t.Errorf("Reload = %+v, want %+v", ev, want)
+	}
+
+	// Each applied key reached its subsystem; the others kept their running values.
+	if _, err := client.Dashboard(); err != nil {
+		t.Errorf("MCSM client not pointed at the new URL: %v", err)
+	}
+	if calls := fake.reset(); len(calls) != 1 {
+		t.Errorf("panel calls = %v", calls)
+	}
+	if a := s.TokenSvc.Auth(); !a.Enable || a.Token != "rotated" {
+		t.Errorf("auth = %+v", a)
+	}
+	if _, err := s.WorkflowSvc.Profile("llonebot"); err != nil {
+		t.Errorf("profiles not reloaded: %v", err)
+	}
+	if s.LogLevel.Level() != slog.LevelDebug {
+		t.Errorf("log level = %v", s.LogLevel.Level())
+	}
+	s.MonitorSvc.mu.Lock()
+	interval := s.MonitorSvc.interval
+	s.MonitorSvc.mu.Unlock()
+	if interval != time.Hour {
+		t.Errorf("monitor interval = %v", interval)
+	}
+	if s.URLSigner.MaxUses != cfg.Public.MaxUses || s.Cfg.Server.Port != ":8088" {
+		t.Error("a restart-only key took effect")
+	}
+
+	// The next reload compares against what was loaded last.
+	if ev, err := s.Reload(next); ev != nil || err != nil {
+		t.Errorf("second Reload = %+v, %v", ev, err)
+	}
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"sealdice-mcsm/server/internal/data"
+)
+
+// SchedulerService runs stored jobs on their cron specs.
+// Results are pushed to Notifier as "job_result" events.
+type SchedulerService struct {
+	Repo        data.JobRepo
+	InstanceSvc *InstanceService
+	ControlSvc  *ControlService
+	WorkflowSvc *WorkflowService
+
+	Notifier Notifier
+	Audit    *AuditService // records each run, may be nil
+	Log      *slog.Logger
+
+	cron    *cron.Cron
+	mu      sync.Mutex
+	entries map[int64]cron.EntryID
+}
+
+func NewSchedulerService(repo data.JobRepo, instSvc *InstanceService, ctrlSvc *ControlService, wfSvc *WorkflowService) *SchedulerService {
+	return &SchedulerService{
+		Repo:        repo,
+		InstanceSvc: instSvc,
+		ControlSvc:  ctrlSvc,
+		WorkflowSvc: wfSvc,
+		Notifier:    nopNotifier{},
+		Log:         slog.Default(),
+		cron:        cron.New(),
+		entries:     make(map[int64]cron.EntryID),
+	}
+}
+
+// Start registers every non-paused job and starts the cron loop.
+func (s *SchedulerService) Start() error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	s.cron.Start()
+	return nil
+}
+
+// Reload re-reads the jobs and schedules every non-paused one, dropping the
+// rest; for changes made to the repo directly, such as an import.
+func (s *SchedulerService) Reload() error {
+	jobs, err := s.Repo.GetAllJobs()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for id, eid := range s.entries {
+		s.cron.Remove(eid)
+		delete(s.entries, id)
+	}
+	s.mu.Unlock()
+	for _, j := range jobs {
+		if j.Paused {
+			continue
+		}
+		if err := s.register(j); err != nil {
+			s.Log.Warn("job not scheduled", "job_id", j.ID, "spec", j.Spec, "err", err)
+		}
+	}
+	return nil
+}
+
+// Stop halts the cron loop and waits for running jobs to return.
+func (s *SchedulerService) Stop() {
+	<-s.cron.Stop().Done()
+}
+
+// Create validates and stores j and schedules it unless it is paused. The spec is
+// parsed before the job is stored, so a job that cannot be scheduled is never saved.
+func (s *SchedulerService) Create(j *data.Job) (*data.Job, error) {
+	if err := s.validate(j); err != nil {
+		return nil, err
+	}
+	sched, err := cron.ParseStandard(j.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %v", j.Spec, err)
+	}
+	if err := s.Repo.SaveJob(j); err != nil {
+		return nil, err
+	}
+	if !j.Paused {
+		s.schedule(j.ID, sched)
+	}
+	return j, nil
+}
+
+func (s *SchedulerService) List() ([]*data.Job, error) {
+	jobs, err := s.Repo.GetAllJobs()
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (s *SchedulerService) Get(id int64) (*data.Job, error) {
+	return s.Repo.GetJob(id)
+}
+
+// NextRun returns when a scheduled job fires next, zero if it is paused or unknown.
+func (s *SchedulerService) NextRun(id int64) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if eid, ok := s.entries[id]; ok {
+		return s.cron.Entry(eid).Next
+	}
+	return time.Time{}
+}
+
+func (s *SchedulerService) Pause(id int64) error {
+	if err := s.Repo.SetJobPaused(id, true); err != nil {
+		return err
+	}
+	s.unregister(id)
+	return nil
+}
+
+func (s *SchedulerService) Resume(id int64) error {
+	j, err := s.Repo.GetJob(id)
+	if err != nil {
+		return err
+	}
+	if err := s.Repo.SetJobPaused(id, false); err != nil {
+		return err
+	}
+	s.unregister(id)
+	return s.register(j)
+}
+
+func (s *SchedulerService) Delete(id int64) error {
+	if err := s.Repo.DeleteJob(id); err != nil {
+		return err
+	}
+	s.unregister(id)
+	return nil
+}
+
+func (s *SchedulerService) validate(j *data.Job) error {
+	return validateJob(s.InstanceSvc, j)
+}
+
+// validateJob checks a job's spec, kind and action, and that inst knows its alias and role.
+func validateJob(inst *InstanceService, j *data.Job) error {
+	if _, err := cron.ParseStandard(j.Spec); err != nil {
+		return fmt.Errorf("invalid cron spec %q: %v", j.Spec, err)
+	}
+	b, err := inst.GetByAlias(j.Alias)
+	if err != nil {
+		return err
+	}
+	role := j.Role
+	if j.Kind == data.JobCommand {
+		// Commands go to one instance, the one run picks.
+		if role = RoleOrDefault(b, j.Role, RoleCore); role == RoleBoth {
+			return fmt.Errorf("command jobs need a single role, not %q", role)
+		}
+	}
+	if role != "" && role != RoleBoth {
+		if _, err := inst.ResolveInstance(j.Alias, role); err != nil {
+			return err
+		}
+	}
+
+	switch j.Kind {
+	case data.JobInstanceAction:
+		if !slices.Contains(InstanceActions, j.Action) {
+			return fmt.Errorf("unsupported instance action: %s", j.Action)
+		}
+	case data.JobCommand:
+		if j.Action == "" {
+			return fmt.Errorf("command required")
+		}
+	case data.JobWorkflow:
+		if !slices.Contains(Workflows, j.Action) {
+			return fmt.Errorf("unknown workflow: %s", j.Action)
+		}
+	default:
+		return fmt.Errorf("unknown job kind: %s", j.Kind)
+	}
+	return nil
+}
+
+func (s *SchedulerService) register(j *data.Job) error {
+	sched, err := cron.ParseStandard(j.Spec)
+	if err != nil {
+		return err
+	}
+	s.schedule(j.ID, sched)
+	return nil
+}
+
+func (s *SchedulerService) schedule(id int64, sched cron.Schedule) {
+	eid := s.cron.Schedule(sched, cron.FuncJob(func() { s.run(id) }))
+	s.mu.Lock()
+	s.entries[id] = eid
+	s.mu.Unlock()
+}
+
+func (s *SchedulerService) unregister(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if eid, ok := s.entries[id]; ok {
+		s.cron.Remove(eid)
+		delete(s.entries, id)
+	}
+}
+
+// run executes a job. The job is re-read so edits and deletions since registration are honoured.
+func (s *SchedulerService) run(id int64) {
+	j, err := s.Repo.GetJob(id)
+	if data.IsNotFound(err) {
+		// Deleted with its binding, possibly by another process.
+		s.unregister(id)
+		return
+	}
+	if err != nil || j.Paused {
+		return
+	}
+
+	caller := fmt.Sprintf("job:%d", j.ID)
+	lg := s.Log.With("job_id", j.ID, "alias", j.Alias, "caller", caller)
+	lg.Info("running job", "kind", j.Kind, "op", j.Action)
+	started := time.Now()
+	result := JobResult{
+		JobID:  j.ID,
+		Name:   j.Name,
+		Alias:  j.Alias,
+		Kind:   j.Kind,
+		Action: j.Action,
+	}
+
+	switch j.Kind {
+	case data.JobInstanceAction:
+		result.Steps, err = s.ControlSvc.Act(j.Alias, j.Role, j.Action, lg)
+	case data.JobCommand:
+		var b *data.Binding
+		var instanceID string
+		if b, err = s.InstanceSvc.GetByAlias(j.Alias); err == nil {
+			role := RoleOrDefault(b, j.Role, RoleCore)
+			if instanceID, err = s.InstanceSvc.ResolveInstance(j.Alias, role); err == nil {
+				err = s.ControlSvc.MCSM.SendCommand(instanceID, "local", j.Action)
+			}
+		}
+	case data.JobWorkflow:
+		err = s.WorkflowSvc.Run(j.Action, j.Alias, caller, s.Notifier, lg)
+	default:
+		err = fmt.Errorf("unknown job kind: %s", j.Kind)
+	}
+
+	if recErr := s.Repo.RecordJobRun(j.ID, started, err); recErr != nil {
+		lg.Error("failed to record job run", "err", recErr)
+	}
+
+	result.DurationMS = time.Since(started).Milliseconds()
+	entry := &data.AuditEntry{
+		Time:       started,
+		Source:     data.AuditScheduler,
+		Caller:     caller,
+		Action:     j.Kind + ":" + j.Action,
+		Target:     j.Alias,
+		Result:     "ok",
+		DurationMS: result.DurationMS,
+	}
+	if err != nil {
+		lg.Warn("job failed", "err", err)
+		result.Status = "failed"
+		result.Error = err.Error()
+		entry.Result, entry.Error = "error", err.Error()
+	} else {
+		result.Status = "ok"
+	}
+	s.Audit.Record(entry)
+	s.Notifier.SendEvent(EventJobResult, result)
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+
+	"sealdice-mcsm/server/internal/data"
+)
+
+func TestValidateJob(t *testing.T) {
+	repo := newTestRepo(t)
+	bind(t, repo, "bot-a")
+	must(t, repo.SaveBinding(&data.Binding{Alias: "proto-only",
+		Instances: []data.BindingInstance{{Role: RoleProtocol, InstanceID: "p"}}}))
+	inst := NewInstanceService(repo)
+
+	tests := []struct {
+		name    string
+		job     data.Job
+		wantErr bool
+	}{
+		{name: "command, default role", job: data.Job{Kind: data.JobCommand, Action: "save", Alias: "bot-a"}},
+		{name: "command, first role fallback", job: data.Job{Kind: data.JobCommand, Action: "save", Alias: "proto-only"}},
+		{name: "command, both", job: data.Job{Kind: data.JobCommand, Action: "save", Alias: "bot-a", Role: RoleBoth}, wantErr: true},
+		{name: "command, unknown role", job: data.Job{Kind: data.JobCommand, Action: "save", Alias: "bot-a", Role: "web"}, wantErr: true},
+		{name: "instance action, whole binding", job: data.Job{Kind: data.JobInstanceAction, Action: "restart", Alias: "bot-a"}},
+		{name: "instance action, unknown role", job: data.Job{Kind: data.JobInstanceAction, Action: "restart", Alias: "proto-only", Role: RoleCore}, wantErr: true},
+		{name: "unknown alias", job: data.Job{Kind: data.JobCommand, Action: "save", Alias: "nope"}, wantErr: true},
+		{name: "bad spec", job: data.Job{Spec: "every day", Kind: data.JobCommand, Action: "save", Alias: "bot-a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if tt.job.Spec == "" {
+			tt.job.Spec = "@daily"
+		}
+		if err := validateJob(inst, &tt.job); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateJob error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -1,66 +1,124 @@
 package service
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
-	"os"
-	"path/filepath"
-	"time"
+	"log/slog"
+	"sync"
 
 	"sealdice-mcsm/server/config"
 	"sealdice-mcsm/server/internal/data"
 	"sealdice-mcsm/server/pkg/mcsm"
+	"sealdice-mcsm/server/pkg/s3"
+	"sealdice-mcsm/server/pkg/tempstore"
 )
 
 type Service struct {
+	// Cfg is the config the server started with. Reload swaps the settings it
+	// can apply into the services that use them, Cfg itself is never changed.
 	Cfg  *config.Config
-	Repo data.BindingRepo
+	Repo data.Repo
 	MCSM *mcsm.Client
+	Log  *slog.Logger
+	// LogLevel, when set, is the level Reload updates from log.level.
+	LogLevel *slog.LevelVar
 
-	InstanceSvc *InstanceService
-	WorkflowSvc *WorkflowService
-}
+	InstanceSvc  *InstanceService
+	WorkflowSvc  *WorkflowService
+	ControlSvc   *ControlService
+	SchedulerSvc *SchedulerService
+	TokenSvc     *TokenService
+	ACLSvc       *ACLService
+	GroupSvc     *GroupService
+	AuditSvc     *AuditService
+	URLSigner    *URLSigner
+	TempStore    tempstore.Store
+	MonitorSvc   *MonitorService
 
-func NewService(cfg *config.Config, repo data.BindingRepo, mcsm *mcsm.Client) *Service {
-	// Ensure temp directory exists
-	_ = os.MkdirAll("./temp", 0755)
+	reloadMu sync.Mutex
+	loaded   *config.Config // last config passed to Reload
+}
 
+func NewService(cfg *config.Config, repo data.Repo, mcsm *mcsm.Client, lg *slog.Logger) (*Service, error) {
+	store, err := NewTempStore(cfg, lg.With("component", "tempstore"))
+	if err != nil {
+		return nil, err
+	}
 	instSvc := NewInstanceService(repo)
 	// Base service for common tasks
 	base := &Service{
-		Cfg:  cfg,
-		Repo: repo,
-		MCSM: mcsm,
+		Cfg:       cfg,
+		Repo:      repo,
+		MCSM:      mcsm,
+		Log:       lg,
+		URLSigner: NewURLSigner(cfg.Public.Secret, cfg.Public.URLTTL, cfg.Public.MaxUses),
+		TempStore: store,
 	}
 
-	wfSvc := NewWorkflowService(instSvc, base, mcsm)
+	wfSvc := NewWorkflowService(instSvc, base, mcsm, cfg.Profiles)
+	base.AuditSvc = NewAuditService(repo, cfg)
+	base.AuditSvc.Log = lg
+	wfSvc.Audit = base.AuditSvc
+	wfSvc.Repo = repo
+	wfSvc.Log = lg
 
 	base.InstanceSvc = instSvc
 	base.WorkflowSvc = wfSvc
+	base.ControlSvc = NewControlService(instSvc, mcsm)
+	base.ControlSvc.Log = lg
+	base.SchedulerSvc = NewSchedulerService(repo, instSvc, base.ControlSvc, wfSvc)
+	base.SchedulerSvc.Audit = base.AuditSvc
+	base.SchedulerSvc.Log = lg
+	base.TokenSvc = NewTokenService(repo, cfg)
+	base.ACLSvc = NewACLService(repo, cfg)
+	base.GroupSvc = NewGroupService(repo)
+	base.MonitorSvc = NewMonitorService(instSvc, mcsm, cfg)
+	base.MonitorSvc.Log = lg
 
-	return base
+	return base, nil
 }
 
-func (s *Service) SaveTempFile(data []byte, ext string) (string, error) {
-	// Generate filename
-	hash := md5.Sum(data)
-	filename := hex.EncodeToString(hash[:]) + ext
-	path := filepath.Join("./temp", filename)
+// NewTempStore opens where temp files go: public.dir on disk by default, process
+// memory with public.store: memory, or an S3 bucket with public.store: s3. A store
+// that cannot be opened is an error, temp files never silently go elsewhere.
+func NewTempStore(cfg *config.Config, lg *slog.Logger) (tempstore.Store, error) {
+	limits := tempstore.Limits{MaxFileSize: cfg.Public.MaxFileSize, MaxTotalSize: cfg.Public.MaxTotalSize}
+	switch cfg.Public.Store {
+	case "memory":
+		return tempstore.NewMemory(limits), nil
+	case "s3":
+		c := cfg.Public.S3
+		client, err := s3.NewClient(c.Endpoint, c.Region, c.Bucket, c.AccessKey, c.SecretKey, c.PathStyle)
+		if err != nil {
+			return nil, fmt.Errorf("s3 temp store: %w", err)
+		}
+		return tempstore.NewS3(client, c.Prefix, limits), nil
+	}
+	disk, err := tempstore.NewDisk(cfg.Public.Dir, cfg.Public.FileTTL, limits, lg)
+	if err != nil {
+		return nil, fmt.Errorf("temp dir %s: %w", cfg.Public.Dir, err)
+	}
+	return disk, nil
+}
 
-	// Write file
-	if err := os.WriteFile(path, data, 0644); err != nil {
+// SaveTempFile stores data for a short while and returns a signed, expiring URL to it:
+// a /public link, or a presigned bucket URL when the store links to files itself.
+func (s *Service) SaveTempFile(data []byte, ext string) (string, error) {
+	// Random names: content hashes would let anyone who has seen a file guess its URL
+	name, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	filename := name + ext
+	// The janitor started in main removes it once public.file_ttl has passed
+	if err := s.TempStore.Put(filename, data, s.Cfg.Public.FileTTL); err != nil {
 		return "", err
 	}
 
-	// Schedule deletion
-	go func() {
-		time.Sleep(5 * time.Minute)
-		os.Remove(path)
-	}()
+	if l, ok := s.TempStore.(tempstore.Linker); ok {
+		return l.URL(filename, s.Cfg.Public.URLTTL)
+	}
 
 	// Construct URL
-	// Assuming /public/ mapped to ./temp/
 	baseURL := s.Cfg.App.ExternalURL
 	if baseURL == "" {
 		baseURL = fmt.Sprintf("http://localhost%s", s.Cfg.Server.Port)
@@ -70,5 +128,5 @@ func (s *Service) SaveTempFile(data []byte, ext string) (string, error) {
 		baseURL = baseURL[:len(baseURL)-1]
 	}
 
-	return fmt.Sprintf("%s/public/%s", baseURL, filename), nil
+	return fmt.Sprintf("%s/public/%s?%s", baseURL, filename, s.URLSigner.Sign(filename)), nil
 }
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/pkg/tokenhash"
+)
+
+// Token scopes. Every action requires exactly one of them.
+const (
+	ScopeRead      = "read"
+	ScopeControl   = "control"
+	ScopeBindAdmin = "bind-admin"
+	ScopeWorkflow  = "workflow"
+	ScopeAdmin     = "admin" // issue and revoke tokens
+)
+
+var AllScopes = []string{ScopeRead, ScopeControl, ScopeBindAdmin, ScopeWorkflow, ScopeAdmin}
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// Principal is the authenticated caller of an action.
+type Principal struct {
+	Name    string
+	TokenID string // empty for the config token
+	Scopes  []string
+	Aliases []string // alias whitelist (path.Match globs), empty with Tags empty = any binding
+	Tags    []string // tag whitelist
+
+	// Chat is the chat user the request was made for, if any. When chatAliases is set
+	// (from ACL rules) bindings must also match one of them.
+	Chat        *ChatCaller
+	chatAliases []string
+
+	authGen uint64 // TokenService auth generation a config or anonymous principal came from
+}
+
+// Superuser is the principal of the config token, and of every caller when auth is off.
+func Superuser(name string) *Principal {
+	return &Principal{Name: name, Scopes: AllScopes}
+}
+
+func (p *Principal) Can(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Restricted reports whether the principal is limited to some bindings.
+func (p *Principal) Restricted() bool {
+	return len(p.Aliases) > 0 || len(p.Tags) > 0 || len(p.chatAliases) > 0
+}
+
+// AllowsBinding reports whether b is inside the principal's whitelist: its alias matches
+// one of the alias patterns or it carries one of the tags, and it matches the chat ACL.
+func (p *Principal) AllowsBinding(b *data.Binding) bool {
+	if len(p.chatAliases) > 0 && !matchAny(p.chatAliases, b.Alias) {
+		return false
+	}
+	if len(p.Aliases) == 0 && len(p.Tags) == 0 {
+		return true
+	}
+	if matchAny(p.Aliases, b.Alias) {
+		return true
+	}
+	for _, t := range b.Tags {
+		if slices.Contains(p.Tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// withChat returns a copy of p acting for chat caller c, limited to aliases when not empty.
+func (p *Principal) withChat(c *ChatCaller, aliases []string) *Principal {
+	cp := *p
+	cp.Chat = c
+	cp.chatAliases = aliases
+	return &cp
+}
+
+func matchAny(patterns []string, alias string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, alias); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckBinding returns ErrForbidden when b is outside the whitelist.
+func (p *Principal) CheckBinding(b *data.Binding) error {
+	if !p.AllowsBinding(b) {
+		if p.Chat != nil && len(p.chatAliases) > 0 {
+			return fmt.Errorf("%w: %s is not allowed for %s", ErrForbidden, b.Alias, p.Chat)
+		}
+		return fmt.Errorf("%w: %s is not allowed for token %s", ErrForbidden, b.Alias, p.Name)
+	}
+	return nil
+}
+
+type TokenService struct {
+	repo data.TokenRepo
+	auth atomic.Pointer[config.Auth] // swapped on config reload
+	gen  atomic.Uint64               // bumped when auth.enable or auth.token changes
+}
+
+func NewTokenService(repo data.TokenRepo, cfg *config.Config) *TokenService {
+	s := &TokenService{repo: repo}
+	s.SetAuth(cfg.Auth)
+	return s
+}
+
+// Auth returns the auth settings in effect.
+func (s *TokenService) Auth() config.Auth { return *s.auth.Load() }
+
+// SetAuth replaces the auth settings. It does not touch principals already resolved;
+// long-lived connections call Check to find out whether theirs is still valid.
+func (s *TokenService) SetAuth(a config.Auth) {
+	prev := s.auth.Swap(&a)
+	if prev != nil && (prev.Enable != a.Enable || prev.Token != a.Token) {
+		// After the swap, so a principal stamped with the new generation saw the new settings.
+		s.gen.Add(1)
+	}
+}
+
+// Anonymous is the principal of every caller while auth is off.
+func (s *TokenService) Anonymous() *Principal {
+	p := Superuser("anonymous")
+	p.authGen = s.gen.Load()
+	return p
+}
+
+// Issue creates a token and returns it with its plaintext, which is not stored and cannot be shown again.
+// Tokens have the form "<id>.<secret>".
+func (s *TokenService) Issue(name string, scopes, aliases, tags []string) (*data.Token, string, error) {
+	if err := validateTokenLimits(scopes, aliases, tags); err != nil {
+		return nil, "", err
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+	hash, err := HashToken(secret)
+	if err != nil {
+		return nil, "", err
+	}
+	t := &data.Token{
+		ID:         id,
+		Name:       name,
+		SecretHash: hash,
+		Scopes:     scopes,
+		Aliases:    aliases,
+		Tags:       tags,
+	}
+	if err := s.repo.SaveToken(t); err != nil {
+		return nil, "", err
+	}
+	return t, id + "." + secret, nil
+}
+
+func (s *TokenService) List() ([]*data.Token, error) {
+	return s.repo.GetAllTokens()
+}
+
+func (s *TokenService) Revoke(id string) error {
+	return s.repo.RevokeToken(id, time.Now())
+}
+
+// Check reports whether p, resolved earlier by Authenticate or Anonymous, may still act:
+// ErrUnauthorized once its issued token is revoked or deleted, or, for the config token
+// and anonymous callers, once auth.enable or auth.token changed. WS connections call it
+// before every action.
+func (s *TokenService) Check(p *Principal) error {
+	if p.TokenID == "" {
+		if p.authGen != s.gen.Load() {
+			return fmt.Errorf("%w: auth settings changed, authenticate again", ErrUnauthorized)
+		}
+		return nil
+	}
+	t, err := s.repo.GetToken(p.TokenID)
+	if err != nil {
+		if data.IsNotFound(err) {
+			return fmt.Errorf("%w: token %s no longer exists", ErrUnauthorized, p.TokenID)
+		}
+		return err
+	}
+	if t.RevokedAt != nil {
+		return fmt.Errorf("%w: token %s was revoked", ErrUnauthorized, p.TokenID)
+	}
+	return nil
+}
+
+// Authenticate resolves a presented token to its principal. The config token (auth.token,
+// plaintext or a HashToken hash) has every scope. All comparisons are constant time.
+func (s *TokenService) Authenticate(raw string) (*Principal, error) {
+	if raw == "" {
+		return nil, ErrUnauthorized
+	}
+	gen := s.gen.Load() // before the settings, see SetAuth
+	if cfgToken := s.Auth().Token; cfgToken != "" {
+		ok := false
+		if strings.HasPrefix(cfgToken, tokenhash.Prefix) {
+			ok = VerifyToken(raw, cfgToken)
+		} else {
+			ok = equalDigest(raw, cfgToken)
+		}
+		if ok {
+			p := Superuser("config")
+			p.authGen = gen
+			return p, nil
+		}
+	}
+
+	id, secret, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	t, err := s.repo.GetToken(id)
+	if err != nil {
+		if data.IsNotFound(err) {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+	if !VerifyToken(secret, t.SecretHash) || t.RevokedAt != nil {
+		return nil, ErrUnauthorized
+	}
+	name := t.Name
+	if name == "" {
+		name = t.ID
+	}
+	return &Principal{Name: name, TokenID: t.ID, Scopes: t.Scopes, Aliases: t.Aliases, Tags: t.Tags}, nil
+}
+
+func validateTokenLimits(scopes, aliases, tags []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope required")
+	}
+	if err := errors.Join(data.CheckList("alias", aliases), data.CheckList("tag", tags)); err != nil {
+		return err
+	}
+	for _, sc := range scopes {
+		if !slices.Contains(AllScopes, sc) {
+			return fmt.Errorf("unknown scope %q (scopes: %s)", sc, strings.Join(AllScopes, ", "))
+		}
+	}
+	for _, a := range aliases {
+		if _, err := path.Match(a, ""); err != nil {
+			return fmt.Errorf("invalid alias pattern %q: %v", a, err)
+		}
+	}
+	return nil
+}
+
+// HashToken returns a salted hash of a token, "sha256$<salt>$<digest>" in hex.
+// It is what the DB stores and what auth.token may hold instead of the plaintext.
+func HashToken(token string) (string, error) {
+	return tokenhash.Hash(token)
+}
+
+// VerifyToken checks token against a HashToken hash in constant time.
+func VerifyToken(token, stored string) bool {
+	return tokenhash.Verify(token, stored)
+}
+
+// equalDigest compares two plaintexts in constant time, without leaking their lengths.
+func equalDigest(a, b string) bool {
+	da, db := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(da[:], db[:]) == 1
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+)
+
+func TestHashToken(t *testing.T) {
+	h, err := HashToken("tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		token, stored string
+		want          bool
+	}{
+		{"tok", h, true},
+		{"tok ", h, false},
+		{"tok", "tok", false},
+		{"tok", "", false},
+	}
+	for _, tt := range tests {
+		if got := VerifyToken(tt.token, tt.stored); got != tt.want {
+			t.Errorf("VerifyToken(%q, %q) = %v, want %v", tt.token, tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	repo := newTestRepo(t)
+	cfgHash, err := HashToken("cfg-hashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewTokenService(repo, &config.Config{Auth: config.Auth{Enable: true, Token: cfgHash}})
+	_, issued, err := svc.Issue("ops", []string{ScopeRead}, []string{"bot-*"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, revoked, err := svc.Issue("old", []string{ScopeRead}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Revoke(strings.SplitN(revoked, ".", 2)[0]); err != nil {
+		t.Fatal(err)
+	}
+	id, _, _ := strings.Cut(issued, ".")
+
+	tests := []struct {
+		name      string
+		auth      config.Auth
+		raw       string
+		wantName  string // "" for ErrUnauthorized
+		wantLimit bool
+	}{
+		{name: "hashed config token", raw: "cfg-hashed", wantName: "config"},
+		{name: "hashed config token, wrong", raw: "cfg-hashed!"},
+		{name: "plaintext config token", auth: config.Auth{Token: "plain"}, raw: "plain", wantName: "config"},
+		{name: "plaintext config token, wrong", auth: config.Auth{Token: "plain"}, raw: "plai"},
+		{name: "issued token", raw: issued, wantName: "ops", wantLimit: true},
+		{name: "issued id, wrong secret", raw: id + ".nope"},
+		{name: "unknown id", raw: "ffffff.nope"},
+		{name: "revoked token", raw: revoked},
+		{name: "empty", raw: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.auth.Token != "" {
+				svc.SetAuth(tt.auth)
+				defer svc.SetAuth(config.Auth{Enable: true, Token: cfgHash})
+			}
+			p, err := svc.Authenticate(tt.raw)
+			if tt.wantName == "" {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("Authenticate = %v, %v; want ErrUnauthorized", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != tt.wantName || p.Restricted() != tt.wantLimit {
+				t.Errorf("principal = %s (restricted %v), want %s (restricted %v)", p.Name, p.Restricted(), tt.wantName, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestCheckRevoked(t *testing.T) {
+	svc := NewTokenService(newTestRepo(t), &config.Config{Auth: config.Auth{Enable: true, Token: "cfg"}})
+	_, raw, err := svc.Issue("ops", []string{ScopeRead}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := svc.Authenticate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Check(p); err != nil {
+		t.Fatalf("Check before revoke = %v", err)
+	}
+	must(t, svc.Revoke(p.TokenID))
+	if err := svc.Check(p); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Check after revoke = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestCheckAuthChanged(t *testing.T) {
+	auth := config.Auth{Enable: true, Token: "cfg"}
+	svc := NewTokenService(newTestRepo(t), &config.Config{Auth: auth})
+	p, err := svc.Authenticate("cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anon := svc.Anonymous()
+
+	auth.AllowQueryToken = true
+	svc.SetAuth(auth)
+	if err := svc.Check(p); err != nil {
+		t.Errorf("Check after unrelated change = %v", err)
+	}
+
+	auth.Token = "rotated"
+	svc.SetAuth(auth)
+	for _, p := range []*Principal{p, anon} {
+		if err := svc.Check(p); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("Check(%s) after rotation = %v, want ErrUnauthorized", p.Name, err)
+		}
+	}
+	if p, err = svc.Authenticate("rotated"); err != nil || svc.Check(p) != nil {
+		t.Errorf("new config token: %v, %v", p, err)
+	}
+}
+
+func TestListEntriesStoredIntact(t *testing.T) {
+	repo := newTestRepo(t)
+	svc := NewTokenService(repo, &config.Config{})
+	acl := NewACLService(repo, &config.Config{})
+
+	// The lists are stored comma separated: an entry with a comma would come back split.
+	bad := [][]string{{"a,b"}, {""}, {"bot-1", "main,test"}}
+	for _, l := range bad {
+		if _, _, err := svc.Issue("ops", []string{ScopeRead}, l, nil); err == nil {
+			t.Errorf("Issue with aliases %q accepted", l)
+		}
+		if _, _, err := svc.Issue("ops", []string{ScopeRead}, nil, l); err == nil {
+			t.Errorf("Issue with tags %q accepted", l)
+		}
+		if err := acl.Save(&data.ACLRule{Actions: []string{ScopeRead}, Aliases: l}); err == nil {
+			t.Errorf("ACL rule with aliases %q accepted", l)
+		}
+		if err := acl.Save(&data.ACLRule{Actions: l}); err == nil {
+			t.Errorf("ACL rule with actions %q accepted", l)
+		}
+	}
+
+	tok, _, err := svc.Issue("ops", []string{ScopeRead, ScopeControl}, []string{"bot-*"}, []string{"main", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetToken(tok.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.Scopes, tok.Scopes) || !slices.Equal(got.Aliases, tok.Aliases) || !slices.Equal(got.Tags, tok.Tags) {
+		t.Errorf("stored token = %+v, want %+v", got, tok)
+	}
+	if err := repo.SaveToken(&data.Token{ID: "x", SecretHash: "h", Scopes: []string{"read,admin"}}); err == nil {
+		t.Error("repo stored a scope with a comma")
+	}
+}
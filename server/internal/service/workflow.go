@@ -1,11 +1,19 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"sealdice-mcsm/server/config"
+	"sealdice-mcsm/server/internal/data"
+	"sealdice-mcsm/server/internal/metrics"
 	"sealdice-mcsm/server/pkg/mcsm"
 )
 
@@ -13,103 +21,378 @@ type Notifier interface {
 	SendEvent(event string, data any) error
 }
 
+// nopNotifier drops events; used until the API layer installs a real one.
+type nopNotifier struct{}
+
+func (nopNotifier) SendEvent(string, any) error { return nil }
+
+// ErrShuttingDown is returned for workflows refused or suspended because the server is stopping.
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Relogin steps, in order. A relogin can be resumed from any of them.
+const (
+	StepRestartProtocol = "restart_protocol"
+	StepQRCode          = "qrcode"
+	StepConfirm         = "confirm"
+	StepRestart         = "restart" // restarting the roles after the protocol
+)
+
+var reloginSteps = []string{StepRestartProtocol, StepQRCode, StepConfirm, StepRestart}
+
 type WorkflowService struct {
 	InstanceSvc *InstanceService
 	CommonSvc   *Service // For SaveTempFile
 	MCSM        *mcsm.Client
-	
+	Audit       *AuditService     // records each step, may be nil
+	Repo        data.WorkflowRepo // keeps runs suspended by Shutdown, may be nil
+	Log         *slog.Logger
+
+	profiles atomic.Pointer[config.Profiles] // swapped on config reload
+
 	// Map alias -> channel for signaling "continue"
 	pendingLogins sync.Map // map[string]chan struct{}
+
+	mu       sync.Mutex
+	draining bool
+	stop     chan struct{} // closed by Shutdown
+	wg       sync.WaitGroup
+	running  map[string]*data.WorkflowRun
 }
 
-func NewWorkflowService(instSvc *InstanceService, commonSvc *Service, mcsm *mcsm.Client) *WorkflowService {
-	return &WorkflowService{
+func NewWorkflowService(instSvc *InstanceService, commonSvc *Service, mcsm *mcsm.Client, profiles config.Profiles) *WorkflowService {
+	s := &WorkflowService{
 		InstanceSvc: instSvc,
 		CommonSvc:   commonSvc,
 		MCSM:        mcsm,
+		Log:         slog.Default(),
+		stop:        make(chan struct{}),
+		running:     map[string]*data.WorkflowRun{},
 	}
+	s.SetProfiles(profiles)
+	return s
 }
 
-func (s *WorkflowService) Relogin(alias string, notifier Notifier) error {
+// SetProfiles replaces the protocol profiles. A running relogin keeps the
+// profile it started with.
+func (s *WorkflowService) SetProfiles(p config.Profiles) { s.profiles.Store(&p) }
+
+// Profiles returns the protocol profiles in effect.
+func (s *WorkflowService) Profiles() config.Profiles { return *s.profiles.Load() }
+
+// Profile returns the named protocol profile, the default one for an empty name.
+func (s *WorkflowService) Profile(name string) (config.Profile, error) {
+	return s.profiles.Load().Get(name)
+}
+
+// begin registers a run with Shutdown, unless the server is already stopping.
+func (s *WorkflowService) begin(alias, workflow, caller string, started time.Time) (*data.WorkflowRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return nil, ErrShuttingDown
+	}
+	run := &data.WorkflowRun{Alias: alias, Workflow: workflow, Caller: caller, Started: started}
+	s.running[alias] = run
+	s.wg.Add(1)
+	return run, nil
+}
+
+// end unregisters a run that returned err. Unless it suspended itself, its saved
+// copy is deleted: Shutdown may have saved it at its deadline before it finished.
+func (s *WorkflowService) end(alias string, err error) {
+	s.mu.Lock()
+	delete(s.running, alias)
+	if s.Repo != nil && !errors.Is(err, ErrShuttingDown) {
+		if derr := s.Repo.DeleteWorkflowRun(alias); derr != nil {
+			s.Log.Error("failed to delete saved relogin", "alias", alias, "err", derr)
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+func (s *WorkflowService) setStep(run *data.WorkflowRun, step string) {
+	s.mu.Lock()
+	run.Step = step
+	s.mu.Unlock()
+}
+
+// suspend saves run at its current step, a safe point to resume from, and
+// returns the error that ends the workflow.
+func (s *WorkflowService) suspend(run *data.WorkflowRun, lg *slog.Logger) error {
+	s.mu.Lock()
+	saved := *run
+	s.mu.Unlock()
+	if s.Repo == nil {
+		return fmt.Errorf("%w: relogin for %s abandoned at step %s", ErrShuttingDown, saved.Alias, saved.Step)
+	}
+	if err := s.Repo.SaveWorkflowRun(&saved); err != nil {
+		return fmt.Errorf("%w: relogin for %s could not be saved: %v", ErrShuttingDown, saved.Alias, err)
+	}
+	lg.Info("relogin suspended, it resumes on next start", "step", saved.Step)
+	return fmt.Errorf("%w: relogin for %s saved at step %s, it resumes on next start", ErrShuttingDown, saved.Alias, saved.Step)
+}
+
+// Relogin runs the QR relogin workflow for alias. caller names who started it on the
+// audit entries of its steps; lg carries the caller's request attributes, nil logs without them.
+func (s *WorkflowService) Relogin(alias, caller string, notifier Notifier, lg *slog.Logger) error {
+	return s.relogin(alias, caller, notifier, StepRestartProtocol, time.Time{}, lg)
+}
+
+// relogin runs the relogin workflow from step on. startTime is when the protocol
+// instance was restarted; QR codes older than that are ignored.
+func (s *WorkflowService) relogin(alias, caller string, notifier Notifier, from string, startTime time.Time, lg *slog.Logger) (err error) {
+	if lg == nil {
+		lg = s.Log
+	}
+	lg = lg.With("workflow", "relogin", "alias", alias)
+
+	fromIdx := slices.Index(reloginSteps, from)
+	if fromIdx < 0 {
+		return fmt.Errorf("unknown relogin step: %s", from)
+	}
+
 	// 1. Check Binding
 	binding, err := s.InstanceSvc.GetByAlias(alias)
 	if err != nil {
 		return fmt.Errorf("alias %s not bound", alias)
 	}
+	protocol, ok := binding.Instance(RoleProtocol)
+	if !ok {
+		return fmt.Errorf("binding %s has no %s instance", alias, RoleProtocol)
+	}
+	profile, err := s.Profile(binding.Profile)
+	if err != nil {
+		return fmt.Errorf("binding %s: %v", alias, err)
+	}
 
 	// Prevent concurrent relogins for same alias
 	if _, loaded := s.pendingLogins.LoadOrStore(alias, make(chan struct{})); loaded {
 		return fmt.Errorf("relogin already in progress for %s", alias)
 	}
-	
+
 	// Ensure cleanup
 	defer s.pendingLogins.Delete(alias)
 
-	// 2. Restart Protocol Instance
-	log.Printf("[%s] Restarting Protocol Instance: %s", alias, binding.ProtocolInstanceID)
-	// TODO: DaemonID "local" assumption? 
+	run, err := s.begin(alias, "relogin", caller, startTime)
+	if err != nil {
+		return err
+	}
+	defer func() { s.end(alias, err) }()
+	defer func() {
+		outcome := metrics.Result(err)
+		if errors.Is(err, ErrShuttingDown) {
+			outcome = "suspended"
+		}
+		metrics.WorkflowRuns.WithLabelValues("relogin", outcome).Inc()
+	}()
+
+	step := func(name string, started time.Time, err error) error {
+		s.Audit.Step("relogin", name, alias, caller, started, err)
+		metrics.WorkflowStepDuration.WithLabelValues("relogin", name, metrics.Result(err)).Observe(time.Since(started).Seconds())
+		return err
+	}
+
+	// TODO: DaemonID "local" assumption?
 	// If stored in binding, better. But for now assume "local" or fetch from binding if we added it.
 	// Schema didn't have DaemonID. Assume "local" or fixed.
-	daemonID := "local" 
-	
-	startTime := time.Now()
-	if err := s.MCSM.StartInstance(binding.ProtocolInstanceID, daemonID); err != nil {
-		// Try restart if start fails? Or just RestartInstance?
-		// My client has RestartInstance fallback.
-		// Let's use Restart.
-		if err := s.MCSM.InstanceAction(binding.ProtocolInstanceID, daemonID, "restart"); err != nil {
-			return fmt.Errorf("failed to restart protocol: %v", err)
+	daemonID := "local"
+
+	// 2. Restart Protocol Instance
+	if fromIdx <= 0 {
+		s.setStep(run, StepRestartProtocol)
+		lg.Info("restarting protocol instance", "instance_id", protocol.InstanceID)
+		startTime = time.Now()
+		s.mu.Lock()
+		run.Started = startTime
+		s.mu.Unlock()
+		if err := s.MCSM.StartInstance(protocol.InstanceID, daemonID); err != nil {
+			// Try restart if start fails? Or just RestartInstance?
+			// My client has RestartInstance fallback.
+			// Let's use Restart.
+			if err := s.MCSM.InstanceAction(protocol.InstanceID, daemonID, "restart"); err != nil {
+				return step(StepRestartProtocol, startTime, fmt.Errorf("failed to restart protocol: %v", err))
+			}
 		}
+		step(StepRestartProtocol, startTime, nil)
+
+		notifier.SendEvent(EventLog, fmt.Sprintf("Protocol instance restarted. Waiting for QR code..."))
 	}
-	
-	notifier.SendEvent("log", fmt.Sprintf("Protocol instance restarted. Waiting for QR code..."))
 
 	// 3. Wait for QRCode
-	// Assuming QR code file path is fixed or configurable?
-	// Usually "qrcode.png" in the instance root.
-	qrPath := "qrcode.png" 
-	
-	log.Printf("[%s] Waiting for QR Code...", alias)
-	qrData, err := s.MCSM.WaitForQRCode(binding.ProtocolInstanceID, daemonID, qrPath, startTime)
-	if err != nil {
-		return fmt.Errorf("failed to get QR code: %v", err)
+	if fromIdx <= 1 {
+		s.setStep(run, StepQRCode)
+		lg.Info("waiting for QR code", "path", profile.QRCodePath)
+		qrStart := time.Now()
+		type qrResult struct {
+			data []byte
+			err  error
+		}
+		qrCh := make(chan qrResult, 1)
+		// Cancelled when the run returns, so a suspended or failed run stops polling.
+		qrCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			data, err := s.MCSM.WaitForQRCode(qrCtx, protocol.InstanceID, daemonID, profile.QRCodePath, startTime, profile.QRCodeTimeout)
+			qrCh <- qrResult{data, err}
+		}()
+		var qrData []byte
+		select {
+		case r := <-qrCh:
+			if r.err != nil {
+				return step(StepQRCode, qrStart, fmt.Errorf("failed to get QR code: %v", r.err))
+			}
+			qrData = r.data
+			metrics.QRWait.Observe(time.Since(startTime).Seconds())
+		case <-s.stop:
+			return step(StepQRCode, qrStart, s.suspend(run, lg))
+		}
+
+		// 4. Save to Static Storage & Push
+		url, err := s.CommonSvc.SaveTempFile(qrData, ".png")
+		if err != nil {
+			return step(StepQRCode, qrStart, fmt.Errorf("failed to save QR image: %v", err))
+		}
+		step(StepQRCode, qrStart, nil)
+
+		lg.Info("QR code ready") // the URL is a usable link, keep it out of logs
+		notifier.SendEvent(EventQRCode, QRCodeEvent{Alias: alias, URL: url})
+		notifier.SendEvent(EventLog, "Please scan the QR code to login.")
 	}
 
-	// 4. Save to Static Storage & Push
-	url, err := s.CommonSvc.SaveTempFile(qrData, ".png")
+	// 5. Wait for "continue" signal
+	if fromIdx <= 2 {
+		s.setStep(run, StepConfirm)
+		lg.Info("waiting for user confirmation")
+		signalCh, _ := s.pendingLogins.Load(alias)
+		ch := signalCh.(chan struct{})
+
+		confirmStart := time.Now()
+		select {
+		case <-ch:
+			lg.Info("received continue signal")
+			step(StepConfirm, confirmStart, nil)
+			notifier.SendEvent(EventLog, "Login confirmed. Restarting Core...")
+		case <-time.After(profile.ConfirmTimeout):
+			return step(StepConfirm, confirmStart, fmt.Errorf("timeout waiting for user confirmation"))
+		case <-s.stop:
+			return step(StepConfirm, confirmStart, s.suspend(run, lg))
+		}
+	}
+
+	// 6. Restart Core Instance (and any other roles bound after the protocol, in order).
+	// Not interruptible: Shutdown waits for it, or saves it when its deadline passes.
+	s.setStep(run, StepRestart)
+	for _, inst := range binding.Instances {
+		if inst.Role == RoleProtocol {
+			continue
+		}
+		restartStart := time.Now()
+		if err := s.MCSM.InstanceAction(inst.InstanceID, daemonID, "restart"); err != nil {
+			return step("restart_"+inst.Role, restartStart, fmt.Errorf("failed to restart %s: %v", inst.Role, err))
+		}
+		step("restart_"+inst.Role, restartStart, nil)
+	}
+
+	lg.Info("relogin completed")
+	notifier.SendEvent(EventSuccess, "Relogin completed successfully.")
+
+	return nil
+}
+
+// Resume restarts the workflows a previous Shutdown saved, reporting to notifier
+// since the clients that started them are gone. It returns how many were resumed.
+func (s *WorkflowService) Resume(notifier Notifier) (int, error) {
+	if s.Repo == nil {
+		return 0, nil
+	}
+	runs, err := s.Repo.GetWorkflowRuns()
 	if err != nil {
-		return fmt.Errorf("failed to save QR image: %v", err)
+		return 0, err
 	}
-	
-	log.Printf("[%s] QR Code ready: %s", alias, url)
-	notifier.SendEvent("qrcode", map[string]string{
-		"alias": alias,
-		"url":   url,
-	})
-	notifier.SendEvent("log", "Please scan the QR code to login.")
+	for _, r := range runs {
+		if err := s.Repo.DeleteWorkflowRun(r.Alias); err != nil {
+			return 0, err
+		}
+		if r.Workflow != "relogin" {
+			s.Log.Warn("dropping saved workflow: cannot be resumed", "workflow", r.Workflow, "alias", r.Alias)
+			continue
+		}
+		// The QR code sent before the restart may have expired, or its link been signed
+		// with a secret generated at the previous boot; send a fresh one.
+		if r.Step == StepConfirm {
+			r.Step = StepQRCode
+		}
+		lg := s.Log.With("caller", "resume")
+		lg.Info("resuming relogin", "alias", r.Alias, "step", r.Step)
+		go func(r *data.WorkflowRun) {
+			notifier.SendEvent(EventLog, fmt.Sprintf("Relogin for %s resumed after a server restart (step %s).", r.Alias, r.Step))
+			if err := s.relogin(r.Alias, r.Caller, notifier, r.Step, r.Started, lg); err != nil {
+				notifier.SendEvent(EventError, ErrorEvent{Alias: r.Alias, Msg: err.Error()})
+			}
+		}(r)
+	}
+	return len(runs), nil
+}
 
-	// 5. Wait for "continue" signal
-	log.Printf("[%s] Waiting for user confirmation...", alias)
-	signalCh, _ := s.pendingLogins.Load(alias)
-	ch := signalCh.(chan struct{})
-	
+// Shutdown refuses new workflows and asks running ones to stop at their next safe
+// point (waiting for the QR code or for "continue"), where they save themselves.
+// Runs still busy when ctx ends are saved at their current step.
+func (s *WorkflowService) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.draining {
+		s.draining = true
+		close(s.stop)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
 	select {
-	case <-ch:
-		log.Printf("[%s] Received continue signal.", alias)
-		notifier.SendEvent("log", "Login confirmed. Restarting Core...")
-	case <-time.After(3 * time.Minute):
-		return fmt.Errorf("timeout waiting for user confirmation")
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Saved under the lock, so a run cannot end between being saved and end deleting it.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.running {
+		if s.Repo == nil {
+			break
+		}
+		if err := s.Repo.SaveWorkflowRun(r); err != nil {
+			s.Log.Error("failed to save running relogin", "alias", r.Alias, "err", err)
+		}
 	}
+	return fmt.Errorf("%d workflows still running: %w", len(s.running), ctx.Err())
+}
+
+// Pending returns the aliases with a relogin waiting for "continue".
+func (s *WorkflowService) Pending() []string {
+	out := []string{}
+	s.pendingLogins.Range(func(k, _ any) bool {
+		out = append(out, k.(string))
+		return true
+	})
+	sort.Strings(out)
+	return out
+}
 
-	// 6. Restart Core Instance
-	if err := s.MCSM.InstanceAction(binding.CoreInstanceID, daemonID, "restart"); err != nil {
-		return fmt.Errorf("failed to restart core: %v", err)
+// Workflows lists the names accepted by Run.
+var Workflows = []string{"relogin"}
+
+// Run starts the named workflow for alias on behalf of caller and blocks until it finishes.
+func (s *WorkflowService) Run(name, alias, caller string, notifier Notifier, lg *slog.Logger) error {
+	switch name {
+	case "relogin":
+		return s.Relogin(alias, caller, notifier, lg)
+	default:
+		return fmt.Errorf("unknown workflow: %s", name)
 	}
-	
-	log.Printf("[%s] Relogin sequence completed.", alias)
-	notifier.SendEvent("success", "Relogin completed successfully.")
-	
-	return nil
 }
 
 func (s *WorkflowService) Continue(alias string) error {
@@ -117,9 +400,9 @@ func (s *WorkflowService) Continue(alias string) error {
 	if !ok {
 		return fmt.Errorf("no active relogin process for %s", alias)
 	}
-	
+
 	ch := val.(chan struct{})
-	
+
 	// Non-blocking send to avoid deadlock if receiver is gone (though Load check helps)
 	select {
 	case ch <- struct{}{}:
@@ -0,0 +1,154 @@
+// Package jsonschema derives JSON Schema documents from Go types via reflection,
+// following encoding/json's field naming rules.
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema object. It is a plain map so documents can be embedded
+// in other JSON (OpenAPI) without conversion.
+type Schema = map[string]any
+
+// Generator collects named struct types into a shared definitions map and hands out $refs to them.
+type Generator struct {
+	// RefPrefix is prepended to definition names, e.g. "#/$defs/" or "#/components/schemas/".
+	RefPrefix string
+	Defs      map[string]Schema
+}
+
+func NewGenerator(refPrefix string) *Generator {
+	return &Generator{RefPrefix: refPrefix, Defs: map[string]Schema{}}
+}
+
+// Schemer lets a type with custom JSON (un)marshalling describe itself.
+type Schemer interface {
+	JSONSchema() Schema
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	schemerType    = reflect.TypeOf((*Schemer)(nil)).Elem()
+)
+
+// Of returns the schema of v's type. Named structs are added to Defs and referenced.
+func (g *Generator) Of(v any) Schema {
+	if v == nil {
+		return Schema{}
+	}
+	return g.TypeSchema(reflect.TypeOf(v))
+}
+
+func (g *Generator) TypeSchema(t reflect.Type) Schema {
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(schemerType) {
+		return reflect.New(t).Interface().(Schemer).JSONSchema()
+	}
+
+	switch t {
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.TypeSchema(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": g.TypeSchema(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": g.TypeSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := t.Name()
+		if _, ok := g.Defs[name]; !ok {
+			g.Defs[name] = Schema{} // placeholder, breaks recursion
+			g.Defs[name] = g.structSchema(t)
+		}
+		return Schema{"$ref": g.RefPrefix + name}
+	default:
+		// interfaces (any) accept every value
+		return Schema{}
+	}
+}
+
+func (g *Generator) structSchema(t reflect.Type) Schema {
+	props := Schema{}
+	var required []string
+	g.collectFields(t, props, &required)
+
+	s := Schema{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// collectFields walks exported fields, flattening embedded structs like encoding/json does.
+// A field is required unless it is a pointer or tagged omitempty. A `doc` tag becomes the description.
+func (g *Generator) collectFields(t reflect.Type, props Schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.collectFields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := g.TypeSchema(ft)
+		if doc := f.Tag.Get("doc"); doc != "" {
+			if _, isRef := fs["$ref"]; isRef {
+				fs = Schema{"allOf": []any{fs}, "description": doc}
+			} else {
+				fs["description"] = doc
+			}
+		}
+		props[name] = fs
+
+		if ft.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -2,29 +2,66 @@ package mcsm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	Base   string
-	APIKey string
-	HTTP   *http.Client
+	HTTP *http.Client
+	Log  *slog.Logger
+
+	mu     sync.RWMutex // guards base and apiKey, which change on config reload
+	base   string
+	apiKey string
+
+	// Observe, when set, is called after every API call with its endpoint path
+	// (no query) and HTTP status, 0 if the request got no answer.
+	Observe func(method, endpoint string, status int, d time.Duration)
+	// PollInterval is how often WaitForStatus and WaitForQRCode ask the panel;
+	// zero means DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+const DefaultPollInterval = 2 * time.Second
+
+func (c *Client) pollInterval() time.Duration {
+	if c.PollInterval > 0 {
+		return c.PollInterval
+	}
+	return DefaultPollInterval
 }
 
 func NewClient(base, apikey string) *Client {
 	return &Client{
-		Base:   base,
-		APIKey: apikey,
 		HTTP:   &http.Client{Timeout: 15 * time.Second},
+		Log:    slog.Default(),
+		base:   base,
+		apiKey: apikey,
 	}
 }
 
+// SetCredentials points the client at another panel URL and API key. Requests
+// already sent finish with the old ones.
+func (c *Client) SetCredentials(base, apikey string) {
+	c.mu.Lock()
+	c.base, c.apiKey = base, apikey
+	c.mu.Unlock()
+}
+
+func (c *Client) credentials() (base, apikey string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.base, c.apiKey
+}
+
 // Response Types
 type DashboardResponse struct {
 	Status int `json:"status"`
@@ -51,12 +88,29 @@ type InstanceDetailResponse struct {
 	} `json:"data"`
 }
 
+// HTTPError is a non-2xx answer from the panel.
+type HTTPError struct {
+	Status int
+	Body   string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http %d: %s", e.Status, e.Body)
+}
+
 func (c *Client) do(method, p string, body any) ([]byte, error) {
-	u, err := url.Parse(c.Base)
+	base, apikey := c.credentials()
+	u, err := url.Parse(base)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, p)
+	// p may carry a query; joining it into the path would escape the '?'
+	ref, err := url.Parse(p)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, ref.Path)
+	u.RawQuery = ref.RawQuery
 	var rdr io.Reader
 	if body != nil {
 		b, _ := json.Marshal(body)
@@ -67,24 +121,33 @@ func (c *Client) do(method, p string, body any) ([]byte, error) {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	if c.APIKey != "" {
-		req.Header.Set("apikey", c.APIKey)
+	if apikey != "" {
+		req.Header.Set("apikey", apikey)
 	}
+	started := time.Now()
 	resp, err := c.HTTP.Do(req)
 	if err != nil {
+		c.observe(method, ref.Path, 0, started)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	b, err := io.ReadAll(resp.Body)
+	c.observe(method, ref.Path, resp.StatusCode, started)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("http %d: %s", resp.StatusCode, string(b))
+		return nil, &HTTPError{Status: resp.StatusCode, Body: string(b)}
 	}
 	return b, nil
 }
 
+func (c *Client) observe(method, endpoint string, status int, started time.Time) {
+	if c.Observe != nil {
+		c.Observe(method, endpoint, status, time.Since(started))
+	}
+}
+
 func (c *Client) Dashboard() (*DashboardResponse, error) {
 	b, err := c.do(http.MethodGet, "/api/dashboard", nil)
 	if err != nil {
@@ -112,9 +175,7 @@ func (c *Client) InstanceAction(instanceID, daemonID, action string) error {
 		endpoint = "kill"
 	default:
 		// Fallback to sending command
-		p := fmt.Sprintf("/api/protected_instance/command?uuid=%s&daemonId=%s&command=%s", instanceID, daemonID, action)
-		_, err := c.do(http.MethodGet, p, nil)
-		return err
+		return c.SendCommand(instanceID, daemonID, action)
 	}
 
 	p := fmt.Sprintf("/api/protected_instance/%s?uuid=%s&daemonId=%s", endpoint, instanceID, daemonID)
@@ -122,6 +183,13 @@ func (c *Client) InstanceAction(instanceID, daemonID, action string) error {
 	return err
 }
 
+// SendCommand writes a line to the instance console.
+func (c *Client) SendCommand(instanceID, daemonID, command string) error {
+	p := fmt.Sprintf("/api/protected_instance/command?uuid=%s&daemonId=%s&command=%s", instanceID, daemonID, url.QueryEscape(command))
+	_, err := c.do(http.MethodGet, p, nil)
+	return err
+}
+
 func (c *Client) StartInstance(uuid, daemonID string) error {
 	return c.InstanceAction(uuid, daemonID, "start")
 }
@@ -199,7 +267,7 @@ func (c *Client) GetFileStatus(uuid, daemonID, filePath string) (*FileStatus, er
 			t, err := parseMCSMTime(item.Time)
 			if err != nil {
 				// Log warning?
-				fmt.Printf("Warning: failed to parse time '%s': %v\n", item.Time, err)
+				c.Log.Warn("failed to parse file time", "file", filePath, "time", item.Time, "err", err)
 				t = time.Now() // Fallback?
 			}
 
@@ -242,18 +310,17 @@ type DownloadConfigResponse struct {
 	} `json:"data"`
 }
 
-func (c *Client) DownloadFile(uuid, daemonID, filePath string) ([]byte, error) {
-	// 1. Get download config
-	// p := fmt.Sprintf("/api/files/download?daemonId=%s&uuid=%s&file_name=%s",
-	// 	daemonID, uuid, url.QueryEscape(filePath))
-
+// DownloadConfig asks the panel for a one-time download of filePath: the daemon
+// address to fetch it from and the password that goes in the URL.
+func (c *Client) DownloadConfig(uuid, daemonID, filePath string) (*DownloadConfigResponse, error) {
 	// Note: POST according to docs
 	// httpPOST /api/files/download
 	// Query params? Docs say "Query Params" but it's a POST?
 	// Usually POST takes body, but docs say "Query Params".
 	// Let's try POST with empty body and query params.
 
-	u, err := url.Parse(c.Base)
+	base, apikey := c.credentials()
+	u, err := url.Parse(base)
 	if err != nil {
 		return nil, err
 	}
@@ -269,21 +336,27 @@ func (c *Client) DownloadFile(uuid, daemonID, filePath string) ([]byte, error) {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	if c.APIKey != "" {
-		req.Header.Set("apikey", c.APIKey)
-		req.Header.Set("X-API-KEY", c.APIKey)
+	if apikey != "" {
+		req.Header.Set("apikey", apikey)
+		req.Header.Set("X-API-KEY", apikey)
 	}
 
+	started := time.Now()
 	resp, err := c.HTTP.Do(req)
 	if err != nil {
+		c.observe(http.MethodPost, "/api/files/download", 0, started)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	b, err := io.ReadAll(resp.Body)
+	c.observe(http.MethodPost, "/api/files/download", resp.StatusCode, started)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &HTTPError{Status: resp.StatusCode, Body: string(b)}
+	}
 
 	var res DownloadConfigResponse
 	if err := json.Unmarshal(b, &res); err != nil {
@@ -293,6 +366,15 @@ func (c *Client) DownloadFile(uuid, daemonID, filePath string) ([]byte, error) {
 	if res.Status != 200 {
 		return nil, fmt.Errorf("download config error: %d", res.Status)
 	}
+	return &res, nil
+}
+
+func (c *Client) DownloadFile(uuid, daemonID, filePath string) ([]byte, error) {
+	// 1. Get download config
+	res, err := c.DownloadConfig(uuid, daemonID, filePath)
+	if err != nil {
+		return nil, err
+	}
 
 	// 2. Download from node
 	// URL: http(s)://{{Daemon Addr}}/download/{{password}}/{{fileName}}
@@ -313,33 +395,42 @@ func (c *Client) DownloadFile(uuid, daemonID, filePath string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	// The daemon URL embeds a one-time password; it is reported as plain "/download"
+	started := time.Now()
 	dResp, err := c.HTTP.Do(dReq)
 	if err != nil {
+		c.observe(http.MethodGet, "/download", 0, started)
 		return nil, err
 	}
 	defer dResp.Body.Close()
 
 	if dResp.StatusCode != 200 {
+		c.observe(http.MethodGet, "/download", dResp.StatusCode, started)
 		return nil, fmt.Errorf("download failed: %d", dResp.StatusCode)
 	}
 
-	return io.ReadAll(dResp.Body)
+	data, err := io.ReadAll(dResp.Body)
+	c.observe(http.MethodGet, "/download", dResp.StatusCode, started)
+	return data, err
 }
 
-func (c *Client) WaitForQRCode(uuid, daemonID, filePath string, startTime time.Time) ([]byte, error) {
-	timeout := time.After(60 * time.Second)
-	ticker := time.NewTicker(2 * time.Second)
+// WaitForQRCode polls filePath until it is modified after startTime and returns its
+// content. It gives up after wait, or when ctx is done.
+func (c *Client) WaitForQRCode(ctx context.Context, uuid, daemonID, filePath string, startTime time.Time, wait time.Duration) ([]byte, error) {
+	timeout := time.After(wait)
+	ticker := time.NewTicker(c.pollInterval())
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		case <-timeout:
 			return nil, fmt.Errorf("timeout waiting for qrcode")
 		case <-ticker.C:
 			status, err := c.GetFileStatus(uuid, daemonID, filePath)
 			if err != nil {
-				// Log error but continue polling?
-				// fmt.Println("Poll error:", err)
+				// The file may not exist yet; keep polling until the timeout.
 				continue
 			}
 
@@ -350,3 +441,34 @@ func (c *Client) WaitForQRCode(uuid, daemonID, filePath string, startTime time.T
 		}
 	}
 }
+
+// Instance status codes as reported by MCSM in InstanceDetailResponse.Data.Status.
+const (
+	StatusBusy     = -1
+	StatusStopped  = 0
+	StatusStopping = 1
+	StatusStarting = 2
+	StatusRunning  = 3
+)
+
+// WaitForStatus polls the instance until it reports the wanted status or the timeout expires.
+func (c *Client) WaitForStatus(uuid, daemonID string, want int, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(c.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf("timeout waiting for instance %s to reach status %d", uuid, want)
+		case <-ticker.C:
+			detail, err := c.InstanceDetail(uuid, daemonID)
+			if err != nil {
+				continue
+			}
+			if detail.Data.Status == want {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package s3 is a minimal client for S3-compatible object storage (AWS S3, MinIO,
+// R2, ...): put, get and delete objects and presign GET URLs, signed with SigV4.
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// MaxPresignExpiry is the longest expiry SigV4 allows on a presigned URL.
+	MaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+type Client struct {
+	Endpoint  *url.URL // e.g. https://s3.amazonaws.com or http://127.0.0.1:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses objects as endpoint/bucket/key (MinIO and most
+	// self-hosted stores) instead of bucket.endpoint/key.
+	PathStyle bool
+	HTTP      *http.Client
+
+	now func() time.Time
+}
+
+func NewClient(endpoint, region, bucket, accessKey, secretKey string, pathStyle bool) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("s3: endpoint %q needs a scheme and host", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("s3: bucket is required")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &Client{
+		Endpoint:  u,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: pathStyle,
+		HTTP:      &http.Client{Timeout: 30 * time.Second},
+		now:       time.Now,
+	}, nil
+}
+
+// Error is an S3 error response.
+type Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: http %d", e.Status)
+	}
+	return fmt.Sprintf("s3: http %d: %s: %s", e.Status, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the store.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Status == http.StatusNotFound
+}
+
+// objectURL returns the unsigned URL of key.
+func (c *Client) objectURL(key string) *url.URL {
+	u := *c.Endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if c.PathStyle {
+		u.Path = base + "/" + c.Bucket + "/" + key
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	return &u
+}
+
+func (c *Client) PutObject(key string, body []byte, contentType string) error {
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	_, err := c.do(http.MethodPut, key, body, h)
+	return err
+}
+
+func (c *Client) GetObject(key string) ([]byte, error) {
+	return c.do(http.MethodGet, key, nil, nil)
+}
+
+// DeleteObject removes key; deleting a missing key is not an error.
+func (c *Client) DeleteObject(key string) error {
+	_, err := c.do(http.MethodDelete, key, nil, nil)
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) do(method, key string, body []byte, h http.Header) ([]byte, error) {
+	u := c.objectURL(key)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]))
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		e := &Error{Status: resp.StatusCode}
+		xml.Unmarshal(b, e)
+		return nil, e
+	}
+	return b, nil
+}
+
+// sign adds SigV4 headers to req, covering host, x-amz-* and content-type.
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	t := c.now().UTC()
+	amzDate := t.Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	scope := c.scope(t)
+	canon := strings.Join([]string{
+		req.Method,
+		encodePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	sig := c.signature(t, scope, canon)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signed, sig))
+}
+
+// PresignGet returns a URL anyone can GET key with until ttl has passed.
+func (c *Client) PresignGet(key string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > MaxPresignExpiry {
+		return "", fmt.Errorf("s3: presign expiry %s out of range", ttl)
+	}
+	t := c.now().UTC()
+	scope := c.scope(t)
+	u := c.objectURL(key)
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", c.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", t.Format(amzDateFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	canon := strings.Join([]string{
+		http.MethodGet,
+		encodePath(u.Path),
+		canonicalQuery(q),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	q.Set("X-Amz-Signature", c.signature(t, scope, canon))
+	u.RawQuery = canonicalQuery(q)
+	return u.String(), nil
+}
+
+func (c *Client) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + c.Region + "/s3/aws4_request"
+}
+
+func (c *Client) signature(t time.Time, scope, canonRequest string) string {
+	sum := sha256.Sum256([]byte(canonRequest))
+	toSign := "AWS4-HMAC-SHA256\n" + t.Format(amzDateFormat) + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + c.SecretKey)
+	for _, part := range []string{t.Format("20060102"), c.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// uriEncode percent-encodes everything but unreserved characters, as SigV4 requires.
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || ch == '/' && keepSlash {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func encodePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return uriEncode(p, true)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,181 @@
+package tempstore
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Disk stores files in a single directory. Expiries live in memory only, so
+// NewDisk treats whatever it finds in the directory as left over from a
+// previous run (see cleanOrphans).
+type Disk struct {
+	root string
+	idx  *index
+	log  *slog.Logger
+}
+
+// NewDisk creates dir if needed, pins it and clears orphans, reporting them to lg
+// (nil for slog.Default). The root is resolved once, so a symlink swapped in for it
+// later does not redirect reads. Files younger than ttl are kept until their age reaches ttl.
+func NewDisk(dir string, ttl time.Duration, limits Limits, lg *slog.Logger) (*Disk, error) {
+	if lg == nil {
+		lg = slog.Default()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	d := &Disk{root: root, idx: newIndex(limits), log: lg}
+	if err := d.cleanOrphans(ttl); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Root is the resolved directory files live in.
+func (d *Disk) Root() string { return d.root }
+
+// cleanOrphans removes what a previous run left behind: half-written uploads,
+// symlinks, stray names and files past their ttl. Younger files are adopted
+// (subject to Limits) so links handed out just before a restart keep working.
+func (d *Disk) cleanOrphans(ttl time.Duration) error {
+	ents, err := os.ReadDir(d.root)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	removed := 0
+	for _, de := range ents {
+		p := filepath.Join(d.root, de.Name())
+		if de.IsDir() {
+			continue // not ours to recurse into
+		}
+		fi, err := de.Info()
+		keep := err == nil && fi.Mode().IsRegular() && ValidName(de.Name()) == nil
+		if keep {
+			expires := fi.ModTime().Add(ttl)
+			keep = now.Before(expires) && d.idx.reserve(de.Name(), fi.Size(), expires) == nil
+		}
+		if !keep {
+			if err := os.Remove(p); err == nil {
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		d.log.Info("removed orphaned temp files", "count", removed, "dir", d.root)
+	}
+	return nil
+}
+
+func (d *Disk) path(name string) (string, error) {
+	if err := ValidName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.root, name), nil
+}
+
+// Put writes data under name via a temp file and rename, so readers never see a partial file.
+func (d *Disk) Put(name string, data []byte, ttl time.Duration) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := d.idx.reserve(name, int64(len(data)), time.Now().Add(ttl)); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(d.root, ".put-*")
+	if err != nil {
+		d.idx.remove(name)
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		d.idx.remove(name)
+	}
+	return err
+}
+
+// Open opens name for reading. Expired and untracked files, symlinks,
+// directories and other non-regular files are reported as not found.
+func (d *Disk) Open(name string) (*File, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if !d.idx.live(name, time.Now()) {
+		return nil, ErrNotFound
+	}
+	fi, err := os.Lstat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	// The entry may have been swapped for a symlink between Lstat and Open.
+	if ofi, err := f.Stat(); err != nil || !os.SameFile(fi, ofi) {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	return &File{ReadSeeker: f, Name: name, ModTime: fi.ModTime(), closer: f}, nil
+}
+
+func (d *Disk) Delete(name string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	d.idx.remove(name)
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *Disk) Sweep(now time.Time) (int, error) {
+	var firstErr error
+	n := 0
+	for _, name := range d.idx.expired(now) {
+		if err := d.Delete(name); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		n++
+	}
+	return n, firstErr
+}
+
+func (d *Disk) Usage() (int, int64) { return d.idx.usage() }
@@ -0,0 +1,130 @@
+package tempstore
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dirFiles counts the regular files in dir and returns their names.
+func dirFiles(t *testing.T, dir string) (int, []string) {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, de := range ents {
+		if de.Type().IsRegular() {
+			names = append(names, de.Name())
+		}
+	}
+	return len(names), names
+}
+
+func TestDiskCleanOrphans(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string, age time.Duration) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		must(t, os.WriteFile(p, []byte(data), 0o600))
+		mod := time.Now().Add(-age)
+		must(t, os.Chtimes(p, mod, mod))
+	}
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	must(t, os.WriteFile(outside, []byte("secret"), 0o600))
+
+	write("young.png", "abc", time.Second)     // adopted
+	write("old.png", "abc", time.Hour)         // past ttl
+	write(".put-123", "half", time.Second)     // interrupted upload
+	write("big.png", "123456789", time.Second) // over MaxFileSize
+	must(t, os.Symlink(outside, filepath.Join(dir, "link.png")))
+	must(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+
+	var logs bytes.Buffer
+	d, err := NewDisk(dir, time.Minute, Limits{MaxFileSize: 8}, slog.New(slog.NewTextHandler(&logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, names := dirFiles(t, dir); len(names) != 1 || names[0] != "young.png" {
+		t.Errorf("left in the dir: %v", names)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "link.png")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("symlink kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub")); err != nil {
+		t.Errorf("directory removed: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("symlink target removed: %v", err)
+	}
+	if got, err := read(t, d, "young.png"); err != nil || got != "abc" {
+		t.Errorf("adopted file: %q, %v", got, err)
+	}
+	if n, size := d.Usage(); n != 1 || size != 3 {
+		t.Errorf("Usage = %d, %d", n, size)
+	}
+	if !strings.Contains(logs.String(), "removed orphaned temp files") || !strings.Contains(logs.String(), "count=4") {
+		t.Errorf("orphans not reported to the store's logger: %q", logs.String())
+	}
+}
+
+func TestDiskSymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	must(t, os.WriteFile(outside, []byte("secret"), 0o600))
+
+	// A symlinked root is resolved once.
+	real := t.TempDir()
+	link := filepath.Join(t.TempDir(), "public")
+	must(t, os.Symlink(real, link))
+	d, err := NewDisk(link, time.Minute, Limits{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := filepath.EvalSymlinks(real); d.Root() != want {
+		t.Errorf("Root = %s, want %s", d.Root(), want)
+	}
+
+	tests := []struct {
+		name    string
+		replace func(p string)
+	}{
+		{"symlink out of the root", func(p string) { must(t, os.Symlink(outside, p)) }},
+		{"directory", func(p string) { must(t, os.Mkdir(p, 0o700)) }},
+		{"regular file", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			must(t, d.Put("a.png", []byte("png"), time.Minute))
+			defer d.Delete("a.png")
+			p := filepath.Join(d.Root(), "a.png")
+			if tt.replace == nil {
+				if got, err := read(t, d, "a.png"); err != nil || got != "png" {
+					t.Errorf("Open = %q, %v", got, err)
+				}
+				return
+			}
+			must(t, os.Remove(p))
+			tt.replace(p)
+			defer os.RemoveAll(p)
+			if f, err := d.Open("a.png"); !errors.Is(err, ErrNotFound) {
+				if f != nil {
+					f.Close()
+				}
+				t.Errorf("Open = %v, want ErrNotFound", err)
+			}
+		})
+	}
+
+	// Retargeting the root symlink does not move the store.
+	must(t, os.Remove(link))
+	must(t, os.Symlink(filepath.Dir(outside), link))
+	if _, err := d.Open("secret.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open through a retargeted root = %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package tempstore
+
+import (
+	"log/slog"
+	"time"
+)
+
+const DefaultSweepInterval = time.Minute
+
+// Janitor sweeps a store on a fixed interval; one per store replaces a timer per file.
+type Janitor struct {
+	store    Store
+	interval time.Duration
+	Log      *slog.Logger
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewJanitor(s Store, interval time.Duration) *Janitor {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	return &Janitor{store: s, interval: interval, Log: slog.Default()}
+}
+
+func (j *Janitor) Start() {
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	go func() {
+		defer close(j.done)
+		t := time.NewTicker(j.interval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				if n, err := j.store.Sweep(now); err != nil {
+					j.Log.Warn("temp file sweep failed", "err", err)
+				} else if n > 0 {
+					j.Log.Debug("swept expired temp files", "count", n)
+				}
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts sweeping and waits for a sweep in progress to finish.
+func (j *Janitor) Stop() {
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+}
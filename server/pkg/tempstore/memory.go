@@ -0,0 +1,77 @@
+package tempstore
+
+import (
+	"sync"
+	"time"
+)
+
+type memEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Memory keeps files in process memory; nothing touches the disk and
+// everything is gone after a restart.
+type Memory struct {
+	idx *index
+
+	mu    sync.RWMutex
+	files map[string]memEntry
+}
+
+func NewMemory(limits Limits) *Memory {
+	return &Memory{idx: newIndex(limits), files: map[string]memEntry{}}
+}
+
+func (m *Memory) Put(name string, data []byte, ttl time.Duration) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := m.idx.reserve(name, int64(len(data)), now.Add(ttl)); err != nil {
+		return err
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	m.mu.Lock()
+	m.files[name] = memEntry{data: buf, modTime: now}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Open(name string) (*File, error) {
+	if err := ValidName(name); err != nil {
+		return nil, err
+	}
+	if !m.idx.live(name, time.Now()) {
+		return nil, ErrNotFound
+	}
+	m.mu.RLock()
+	e, ok := m.files[name]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return memFile(name, e.data, e.modTime), nil
+}
+
+func (m *Memory) Delete(name string) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.files, name)
+	m.mu.Unlock()
+	m.idx.remove(name)
+	return nil
+}
+
+func (m *Memory) Sweep(now time.Time) (int, error) {
+	names := m.idx.expired(now)
+	for _, name := range names {
+		m.Delete(name)
+	}
+	return len(names), nil
+}
+
+func (m *Memory) Usage() (int, int64) { return m.idx.usage() }
@@ -0,0 +1,102 @@
+package tempstore
+
+import (
+	"mime"
+	"path/filepath"
+	"time"
+
+	"sealdice-mcsm/server/pkg/s3"
+)
+
+// Linker is implemented by stores whose files are fetched straight from the
+// backend rather than through /public.
+type Linker interface {
+	// URL returns a link to name that stops working after ttl.
+	URL(name string, ttl time.Duration) (string, error)
+}
+
+// S3 keeps files in an S3-compatible bucket under Prefix and hands out
+// presigned URLs, for setups where /public is not reachable from the chat platform.
+//
+// Expiries are tracked in memory like Disk, but objects left by a previous run
+// cannot be told apart from others in the bucket; a bucket lifecycle rule on
+// Prefix is the backstop for those.
+type S3 struct {
+	client *s3.Client
+	prefix string
+	idx    *index
+}
+
+func NewS3(client *s3.Client, prefix string, limits Limits) *S3 {
+	return &S3{client: client, prefix: prefix, idx: newIndex(limits)}
+}
+
+func (s *S3) Put(name string, data []byte, ttl time.Duration) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+	if err := s.idx.reserve(name, int64(len(data)), time.Now().Add(ttl)); err != nil {
+		return err
+	}
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	if err := s.client.PutObject(s.prefix+name, data, ctype); err != nil {
+		s.idx.remove(name)
+		return err
+	}
+	return nil
+}
+
+func (s *S3) Open(name string) (*File, error) {
+	if err := ValidName(name); err != nil {
+		return nil, err
+	}
+	if !s.idx.live(name, time.Now()) {
+		return nil, ErrNotFound
+	}
+	data, err := s.client.GetObject(s.prefix + name)
+	if s3.IsNotFound(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return memFile(name, data, time.Time{}), nil
+}
+
+func (s *S3) Delete(name string) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+	s.idx.remove(name)
+	return s.client.DeleteObject(s.prefix + name)
+}
+
+func (s *S3) Sweep(now time.Time) (int, error) {
+	var firstErr error
+	n := 0
+	for _, name := range s.idx.expired(now) {
+		if err := s.Delete(name); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		n++
+	}
+	return n, firstErr
+}
+
+func (s *S3) Usage() (int, int64) { return s.idx.usage() }
+
+// URL presigns a GET for name. Expired files get no link.
+func (s *S3) URL(name string, ttl time.Duration) (string, error) {
+	if err := ValidName(name); err != nil {
+		return "", err
+	}
+	if !s.idx.live(name, time.Now()) {
+		return "", ErrNotFound
+	}
+	return s.client.PresignGet(s.prefix+name, ttl)
+}
@@ -0,0 +1,140 @@
+// Package tempstore keeps short-lived files (QR codes) that are handed out over /public.
+//
+// Names are flat: a store never resolves anything outside its own root, so a name
+// is checked against a strict allowlist before it touches the filesystem. Every
+// file carries an expiry; a Janitor sweeps expired files so nothing needs its own timer.
+package tempstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("tempstore: file not found")
+	ErrInvalidName = errors.New("tempstore: invalid file name")
+	ErrTooLarge    = errors.New("tempstore: file exceeds max file size")
+	ErrQuota       = errors.New("tempstore: store is full")
+)
+
+// nameRe admits flat names like "3f2a...e1.png": no separators, no leading dot.
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// ValidName reports whether name can be stored; it rejects anything that could
+// leave the root ("..", "a/b", "a\b", absolute paths) or name a hidden file.
+func ValidName(name string) error {
+	if !nameRe.MatchString(name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// File is an open stored file. Close it when done.
+type File struct {
+	io.ReadSeeker
+	Name    string
+	ModTime time.Time
+	closer  io.Closer
+}
+
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// Store holds temp files by name until they expire.
+type Store interface {
+	// Put stores data under name for ttl, replacing any file of that name.
+	Put(name string, data []byte, ttl time.Duration) error
+	// Open returns a stored file; expired files are not found.
+	Open(name string) (*File, error)
+	Delete(name string) error
+	// Sweep removes files that expired before now and returns how many went.
+	Sweep(now time.Time) (int, error)
+	// Usage reports the files and bytes currently held.
+	Usage() (files int, bytes int64)
+}
+
+// Limits cap what a store holds; zero fields are unlimited.
+type Limits struct {
+	MaxFileSize  int64
+	MaxTotalSize int64
+}
+
+type entry struct {
+	size    int64
+	expires time.Time
+}
+
+// index tracks sizes and expiries for a store and enforces its Limits.
+type index struct {
+	limits Limits
+
+	mu      sync.Mutex
+	entries map[string]entry
+	total   int64
+}
+
+func newIndex(l Limits) *index {
+	return &index{limits: l, entries: map[string]entry{}}
+}
+
+// reserve accounts for name before it is written. Live files are never evicted
+// to make room: their links are still out there.
+func (x *index) reserve(name string, size int64, expires time.Time) error {
+	if x.limits.MaxFileSize > 0 && size > x.limits.MaxFileSize {
+		return ErrTooLarge
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	total := x.total - x.entries[name].size + size
+	if x.limits.MaxTotalSize > 0 && total > x.limits.MaxTotalSize {
+		return ErrQuota
+	}
+	x.entries[name] = entry{size: size, expires: expires}
+	x.total = total
+	return nil
+}
+
+func (x *index) remove(name string) {
+	x.mu.Lock()
+	x.total -= x.entries[name].size
+	delete(x.entries, name)
+	x.mu.Unlock()
+}
+
+// live reports whether name is tracked and not yet expired.
+func (x *index) live(name string, now time.Time) bool {
+	x.mu.Lock()
+	e, ok := x.entries[name]
+	x.mu.Unlock()
+	return ok && now.Before(e.expires)
+}
+
+func (x *index) expired(now time.Time) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var out []string
+	for name, e := range x.entries {
+		if !now.Before(e.expires) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func (x *index) usage() (int, int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.entries), x.total
+}
+
+func memFile(name string, data []byte, mod time.Time) *File {
+	return &File{ReadSeeker: bytes.NewReader(data), Name: name, ModTime: mod}
+}
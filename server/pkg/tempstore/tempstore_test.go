@@ -0,0 +1,180 @@
+package tempstore
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"sealdice-mcsm/server/pkg/s3"
+)
+
+// fakeBucket is a path-style S3 endpoint that keeps objects in memory.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[r.URL.Path], _ = io.ReadAll(r.Body)
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeBucket) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.objects)
+}
+
+// backends opens one store of each kind with limits; files reports how many
+// files the backend itself holds, to check deletes reach it.
+func backends(t *testing.T, limits Limits) map[string]struct {
+	store Store
+	files func() int
+} {
+	t.Helper()
+	disk, err := NewDisk(t.TempDir(), time.Minute, limits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := &fakeBucket{objects: map[string][]byte{}}
+	srv := httptest.NewServer(bucket)
+	t.Cleanup(srv.Close)
+	client, err := s3.NewClient(srv.URL, "", "tmp", "ak", "sk", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemory(limits)
+	return map[string]struct {
+		store Store
+		files func() int
+	}{
+		"disk":   {disk, func() int { n, _ := dirFiles(t, disk.Root()); return n }},
+		"memory": {mem, func() int { mem.mu.RLock(); defer mem.mu.RUnlock(); return len(mem.files) }},
+		"s3":     {NewS3(client, "qr/", limits), bucket.len},
+	}
+}
+
+func read(t *testing.T, s Store, name string) (string, error) {
+	t.Helper()
+	f, err := s.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	return string(b), err
+}
+
+func TestStores(t *testing.T) {
+	limits := Limits{MaxFileSize: 8, MaxTotalSize: 12}
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Store, files func() int)
+	}{
+		{"put and open", func(t *testing.T, s Store, files func() int) {
+			must(t, s.Put("a.png", []byte("abc"), time.Minute))
+			if got, err := read(t, s, "a.png"); err != nil || got != "abc" {
+				t.Errorf("Open = %q, %v", got, err)
+			}
+			if _, err := s.Open("b.png"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Open of a missing name = %v", err)
+			}
+		}},
+		{"invalid names", func(t *testing.T, s Store, files func() int) {
+			for _, name := range []string{"", "../a.png", "a/b.png", `a\b.png`, "/etc/passwd", ".hidden", "%2e%2e"} {
+				if err := s.Put(name, []byte("x"), time.Minute); !errors.Is(err, ErrInvalidName) {
+					t.Errorf("Put(%q) = %v", name, err)
+				}
+				if _, err := s.Open(name); !errors.Is(err, ErrInvalidName) {
+					t.Errorf("Open(%q) = %v", name, err)
+				}
+			}
+		}},
+		{"file size", func(t *testing.T, s Store, files func() int) {
+			if err := s.Put("big.png", []byte("123456789"), time.Minute); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("Put over MaxFileSize = %v", err)
+			}
+			if n, _ := s.Usage(); n != 0 || files() != 0 {
+				t.Errorf("rejected file counted or stored")
+			}
+		}},
+		{"quota", func(t *testing.T, s Store, files func() int) {
+			must(t, s.Put("a.png", []byte("12345678"), time.Minute))
+			if err := s.Put("b.png", []byte("12345"), time.Minute); !errors.Is(err, ErrQuota) {
+				t.Errorf("Put over MaxTotalSize = %v", err)
+			}
+			// Replacing a file counts only the new size.
+			must(t, s.Put("a.png", []byte("1234"), time.Minute))
+			must(t, s.Put("b.png", []byte("12345678"), time.Minute))
+			if n, size := s.Usage(); n != 2 || size != 12 {
+				t.Errorf("Usage = %d, %d; want 2, 12", n, size)
+			}
+			must(t, s.Delete("a.png"))
+			if n, size := s.Usage(); n != 1 || size != 8 || files() != 1 {
+				t.Errorf("after Delete: Usage = %d, %d, %d files", n, size, files())
+			}
+		}},
+		{"expiry and sweep", func(t *testing.T, s Store, files func() int) {
+			must(t, s.Put("old.png", []byte("x"), -time.Second))
+			must(t, s.Put("new.png", []byte("y"), time.Minute))
+			if _, err := s.Open("old.png"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Open of an expired file = %v", err)
+			}
+			if n, err := s.Sweep(time.Now()); n != 1 || err != nil {
+				t.Errorf("Sweep = %d, %v; want 1", n, err)
+			}
+			if n, _ := s.Usage(); n != 1 || files() != 1 {
+				t.Errorf("after Sweep: %d tracked, %d stored; want 1, 1", n, files())
+			}
+			if n, _ := s.Sweep(time.Now().Add(2 * time.Minute)); n != 1 || files() != 0 {
+				t.Errorf("later Sweep = %d, %d stored", n, files())
+			}
+		}},
+		{"janitor", func(t *testing.T, s Store, files func() int) {
+			must(t, s.Put("old.png", []byte("x"), -time.Second))
+			j := NewJanitor(s, 5*time.Millisecond)
+			j.Start()
+			defer j.Stop()
+			deadline := time.Now().Add(2 * time.Second)
+			for files() != 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("janitor did not sweep the expired file")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		for _, kind := range []string{"disk", "memory", "s3"} {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				b := backends(t, limits)[kind]
+				tt.run(t, b.store, b.files)
+			})
+		}
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
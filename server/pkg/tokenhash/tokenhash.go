@@ -0,0 +1,62 @@
+// Package tokenhash hashes API tokens for storage: "sha256$<salt>$<digest>" in hex,
+// the digest being SHA-256 over the random salt followed by the token.
+package tokenhash
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix starts every hash, telling it apart from a plaintext token.
+const Prefix = "sha256$"
+
+// Hash returns a salted hash of token.
+func Hash(token string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return Prefix + hex.EncodeToString(salt) + "$" + hex.EncodeToString(digest(salt, token)), nil
+}
+
+// Verify checks token against a Hash result in constant time.
+func Verify(token, stored string) bool {
+	salt, sum, ok := parse(stored)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare(digest(salt, token), sum) == 1
+}
+
+// Valid reports whether stored is a well-formed Hash result.
+func Valid(stored string) bool {
+	_, _, ok := parse(stored)
+	return ok
+}
+
+func parse(stored string) (salt, sum []byte, ok bool) {
+	rest, ok := strings.CutPrefix(stored, Prefix)
+	if !ok {
+		return nil, nil, false
+	}
+	saltHex, sumHex, ok := strings.Cut(rest, "$")
+	if !ok {
+		return nil, nil, false
+	}
+	salt, err1 := hex.DecodeString(saltHex)
+	sum, err2 := hex.DecodeString(sumHex)
+	if err1 != nil || err2 != nil || len(salt) == 0 || len(sum) != sha256.Size {
+		return nil, nil, false
+	}
+	return salt, sum, true
+}
+
+func digest(salt []byte, token string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
@@ -0,0 +1,48 @@
+package tokenhash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestHashVerify(t *testing.T) {
+	h, err := Hash("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, _ := Hash("s3cret")
+	if h == h2 {
+		t.Error("two hashes of one token are equal, salt not random")
+	}
+	if !Valid(h) {
+		t.Errorf("Valid(%q) = false", h)
+	}
+
+	unsalted := sha256.Sum256([]byte("s3cret"))
+	tests := []struct {
+		name   string
+		token  string
+		stored string
+		want   bool
+	}{
+		{name: "match", token: "s3cret", stored: h, want: true},
+		{name: "other salt", token: "s3cret", stored: h2, want: true},
+		{name: "wrong token", token: "s3cret2", stored: h, want: false},
+		{name: "empty token", token: "", stored: h, want: false},
+		{name: "plaintext stored", token: "s3cret", stored: "s3cret", want: false},
+		{name: "unsalted digest", token: "s3cret", stored: Prefix + hex.EncodeToString(unsalted[:]), want: false},
+		{name: "empty salt", token: "s3cret", stored: Prefix + "$" + hex.EncodeToString(unsalted[:]), want: false},
+		{name: "short digest", token: "s3cret", stored: h[:len(h)-2], want: false},
+		{name: "not hex", token: "s3cret", stored: strings.Replace(h, Prefix, Prefix+"zz", 1), want: false},
+	}
+	for _, tt := range tests {
+		if got := Verify(tt.token, tt.stored); got != tt.want {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.stored != h && tt.stored != h2 && Valid(tt.stored) {
+			t.Errorf("%s: Valid(%q) = true", tt.name, tt.stored)
+		}
+	}
+}